@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"fmt"
 	"net/http"
-	//"fmt"
+	"os"
 	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_core"
 	"github.com/scyth/go-webproject/gwp/gwp_template"
 	"github.com/scyth/go-webproject/gwp/gwp_module"
-	"github.com/scyth/go-webproject/gwp/libs/gorilla/mux"
 	"github.com/scyth/go-webproject/gwp/modules/mod_sessions"
 	"github.com/scyth/go-webproject/gwp/modules/mod_example"
 )
@@ -21,21 +21,19 @@ type Example struct {
 
 
 // initHandlres defines all the routes for our web application
-func initHandlers(r *mux.Router) {
-	// If gorilla-mux is enabled, r is pointer to mux.Router and we have to use it.
+func initHandlers(ctx *gwp_context.Context) {
+	// Handlers are registered with the active router, see gwp_module.Routes.
+	// If gorilla-mux is enabled, patterns are gorilla path templates, eg. "/items/{id:[0-9]+}".
 	// Once first match is found, appropriate handler is called, so make sure you 
-	// order patterns appropriately. Pattern is regexp.
-	// usage would be: r.HandleFunc("/some-regexp-pattern", handlerFn)
+	// order patterns appropriately.
 	//
-	// If gorilla-mux is disabled, you will use default http's way of defining patterns.
+	// If gorilla-mux is disabled, http's way of defining patterns is used.
 	// Patterns are not regexp. Instead, longest match will win, so you don't have to worry
 	// about ordering in this case.
-	// usage: http.HandleFunc("/pattern", handlerFn)
 	//
-	// this code is based on sample config provided, where gorilla-mux is enabled, so
-	r.HandleFunc("/", indexPage) // otherwise, we would use http.HandleFunc("/", indexPage)
-	r.HandleFunc("/login", loginPage)
-		
+	// Registered patterns can be turned back into URLs with gwp_module.Routes(ctx).URL
+	gwp_module.RegisterHandler(ctx, "/", indexPage)
+	gwp_module.RegisterHandler(ctx, "/login", loginPage)
 }
 
 func initModules(ctx *gwp_context.Context) {
@@ -51,22 +49,18 @@ func initModules(ctx *gwp_context.Context) {
 	encKey := mod_sessions.ReadParamStr("encryption-key")
 
         // setup session management. We use filestore as default backend
-	if len(encKey) == 0 {
-		mod_sessions.RegisterStore([]byte(secretKey))
-
-	} else {
-		mod_sessions.RegisterStore([]byte(secretKey), []byte(encKey))
+	keys := [][]byte{[]byte(secretKey)}
+	if len(encKey) != 0 {
+		keys = append(keys, []byte(encKey))
+	}
+	if err := mod_sessions.RegisterStore(keys...); err != nil {
+		fmt.Println("Error initializing module: mod_sessions -", err.Error())
+		os.Exit(1)
 	}
 }
 
 // indexPage() is a handler which will load some template and send the result back to the client
 func indexPage(writer http.ResponseWriter, req *http.Request) {
-	tpl, err := gwp_template.Load(ctx, "index.html")
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	var displayContent bool
 
 	// if session parameter "session_id" is present, we already have session set and we can show the content
@@ -90,10 +84,11 @@ func indexPage(writer http.ResponseWriter, req *http.Request) {
 	if errmsg == "login" { msg = "Invalid login" } 
 		
 	mydata := Example{ID: s_id, Name: "Joe", LoggedIn: displayContent, ErrorMsg: msg}
-	buff := new(bytes.Buffer)
 
-	tpl.Execute(buff, mydata)
-	writer.Write(buff.Bytes())
+	// Render resolves request bound template functions, like csrfField
+	if err := gwp_template.Render(ctx, writer, req, "index.html", mydata); err != nil {
+		gwp_core.Error(writer, req, http.StatusInternalServerError, err)
+	}
 }
 
 
@@ -103,8 +98,18 @@ func loginPage(writer http.ResponseWriter, req *http.Request) {
 	valid_user := "testu"
 	valid_pass := "testp"
 
+	if !gwp_core.VerifyCSRF(req) {
+		http.Error(writer, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
 	if req.FormValue("user") == valid_user && req.FormValue("pass") == valid_pass {
 		sess,_ := mod_sessions.CheckSession(req, writer)
+		// new id on login, so a session id planted before can't be used to hijack the login
+		if err := mod_sessions.RegenerateId(req, writer); err != nil {
+			http.Error(writer, "Session error", http.StatusInternalServerError)
+			return
+		}
 		sess.Values["session_id"] = sess.ID // we set this to indicate we're logged in.
 		mod_sessions.Save(req, writer, sess) 
 		http.Redirect(writer, req, "/", http.StatusFound)
@@ -1,7 +1,10 @@
 package gwp_context
 
 import (
-	"html/template"
+	"io"
+	"net/http"
+	"sync"
+	"time"
 	"github.com/scyth/go-webproject/gwp/libs/gorilla/mux"
 )
 
@@ -12,34 +15,106 @@ var (
         TypeFloat64 uint8 = 0x04 
 )
 
+// LiveTplBuffer is the number of parsed templates which can wait in LiveTplMsg
+// for the template watcher, so first loads don't block on it
+const LiveTplBuffer = 64
+
 // Context is used to store all runtime app data (modules, templates, configs...)
 type Context struct {
-	ConfigFile string
-	Router     *mux.Router
-	LiveTplMsg chan *ParsedTemplate
-	ErrorMsg   chan error
-	App        *AppConfig
-	Templates  map[string]*template.Template // keys = relative file path, vals = parsed template objects
+	ConfigFile    string
+	Router        *mux.Router // gorilla router, if gorilla-mux is on
+	Routes        Router      // active router, all handler registration should go through it
+	LiveTplMsg    chan *ParsedTemplate
+	InvalidTplMsg chan string // invalidated template names for the watcher, "" means all
+	ErrorMsg      chan error
+	Build         *BuildInfo          // set by gwp_core.SetBuildInfo
+	Templates     map[string]Renderer // keys = relative file path, vals = parsed template objects
+	TemplatesMu   sync.RWMutex        // guards Templates and TemplatesGen
+	TemplatesGen  uint64              // bumped when templates are invalidated, stale parses aren't cached
+
+	app   *AppConfig
+	appMu sync.RWMutex // guards app
 }
 
 // NewContext creates new instance of Context, and returns pointer to it
 func NewContext() *Context {
 	c := new(Context)
-	c.App = NewAppConfig()
-	c.LiveTplMsg = make(chan *ParsedTemplate)
+	c.app = NewAppConfig()
+	c.LiveTplMsg = make(chan *ParsedTemplate, LiveTplBuffer)
+	c.InvalidTplMsg = make(chan string, LiveTplBuffer)
 	c.ErrorMsg = make(chan error)
-	c.Templates = make(map[string]*template.Template)
+	c.Templates = make(map[string]Renderer)
 	return c
 }
 
+// Config returns the current AppConfig. It's replaced as a whole on config reload, so
+// a request handler should call it once and use the returned value throughout.
+func (c *Context) Config() *AppConfig {
+	c.appMu.RLock()
+	defer c.appMu.RUnlock()
+	return c.app
+}
+
+// SetConfig replaces the AppConfig returned by Config. The given config must not
+// be modified afterwards, as requests may be reading it.
+func (c *Context) SetConfig(app *AppConfig) {
+	c.appMu.Lock()
+	c.app = app
+	c.appMu.Unlock()
+}
+
 // AppConfig holds data parsed from configuration file, [default] and [project] sections only
 type AppConfig struct {
 	ListenAddr    string
 	Mux           string
+	DevMode       bool // show error details to clients, never turn on in production
+
+	// request handling time limit, see gwp_core.TimeoutMiddleware. 0 means unlimited
+	RequestTimeout time.Duration
+
+	// load shedding, see gwp_core.LimitMiddleware. MaxRequests of 0 means unlimited
+	MaxRequests           int
+	MaxRequestsWait       time.Duration
+	MaxRequestsRetryAfter int
+
+	// reverse proxies allowed to set X-Forwarded-* headers, see gwp_core.ProxyConfig
+	TrustedProxies []string
+	ProxyHeaders   []string
+
+	// Content-Security-Policy header, with per request script nonce, see gwp_core.CSPMiddleware
+	ContentSecurityPolicy string
+
+	// connection handling, see gwp_core.ListenAndServe
+	KeepAlive       bool
+	KeepAlivePeriod time.Duration
+
 	ProjectRoot   string
 	TempDir       string
 	TemplatePath  string
 	LiveTemplates bool
+	VersionPath   string // build info endpoint, disabled if empty
+	MetricsPath   string // metrics endpoint, disabled if empty
+
+	// flags from [features] section, see gwp_core.Feature
+	Features map[string]string
+
+	// request body limits, in bytes. MaxBodySize of 0 means unlimited
+	MaxMultipartMemory int64
+	MaxBodySize        int64
+
+	// maintenance mode settings, see gwp_core.MaintenanceMiddleware
+	Maintenance           bool
+	MaintenanceTemplate   string
+	MaintenanceRetryAfter int
+	MaintenanceAllowPaths []string
+	MaintenanceAllowIPs   []string
+}
+
+// BuildInfo describes the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
 }
 
 // NewAppConfig creates new instance of AppConfig, and returns pointer to it
@@ -48,10 +123,26 @@ func NewAppConfig() *AppConfig {
 	return ac
 }
 
-// ParsedTemplate is a wrapper type around template.Template
+// Router is implemented by the routers handlers can be registered with, so modules
+// work the same with gorilla-mux turned on or off. Patterns follow the active router's syntax.
+type Router interface {
+	http.Handler
+	Handle(pattern string, handler http.Handler)
+	HandleMethods(pattern string, handler http.Handler, methods ...string) // only for given HTTP methods
+	NotFound(handler http.Handler)                                         // handler for unmatched requests
+	URL(pattern string, pairs ...string) (string, error)                   // builds URL of registered pattern
+}
+
+// Renderer is a parsed template, of any template engine (see gwp_template.TemplateEngine)
+type Renderer interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// ParsedTemplate is a wrapper type around parsed template
 type ParsedTemplate struct {
 	Name string
-	Tpl  *template.Template
+	Tpl  Renderer
+	Gen  uint64 // TemplatesGen at the time the file was read
 }
 
 
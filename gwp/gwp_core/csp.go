@@ -0,0 +1,92 @@
+package gwp_core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
+)
+
+// ----------------------------------------
+// Content-Security-Policy nonce
+// ----------------------------------------
+
+// ErrNonceUnavailable is passed to Error when CSPMiddleware can't generate a nonce
+var ErrNonceUnavailable = errors.New("csp: can't generate nonce")
+
+type cspKey int
+
+const cspNonceKey cspKey = 0
+
+func init() {
+	gwp_template.AddRequestFunc("cspNonce", func(r *http.Request) interface{} {
+		return func() string {
+			if r == nil {
+				return ""
+			}
+			return CSPNonce(r)
+		}
+	})
+}
+
+// CSPMiddleware sends configured content-security-policy with a random nonce, new for every
+// request, added to its script-src directive. Inline scripts are allowed only when they carry
+// the nonce, which templates get with {{cspNonce}}:
+//
+//	<script nonce="{{cspNonce}}">...</script>
+//
+// The nonce is kept in gorilla context, and cleared with it. Nothing is done if the policy is not configured.
+func CSPMiddleware(ctx *gwp_context.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if policy := ctx.Config().ContentSecurityPolicy; policy != "" {
+			key := securecookie.GenerateRandomKey(16)
+			if key == nil {
+				Error(w, r, http.StatusInternalServerError, ErrNonceUnavailable)
+				return
+			}
+			// hex digits are valid base64, and need no escaping in templates
+			nonce := fmt.Sprintf("%x", key)
+			context.DefaultContext.Set(r, cspNonceKey, nonce)
+			w.Header().Set("Content-Security-Policy", addScriptNonce(policy, nonce))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// CSPNonce returns CSP nonce for the current request, or empty string if CSPMiddleware is not in use.
+func CSPNonce(r *http.Request) string {
+	return context.DefaultContext.GetString(r, cspNonceKey)
+}
+
+// addScriptNonce adds nonce source to script-src directive of policy. Without script-src,
+// scripts fall back to default-src, so the directive is created from default-src sources.
+func addScriptNonce(policy, nonce string) string {
+	source := "'nonce-" + nonce + "'"
+	var directives []string
+	var fallback []string
+	found := false
+	for _, d := range strings.Split(policy, ";") {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "script-src":
+			fields = append(fields, source)
+			found = true
+		case "default-src":
+			fallback = fields[1:]
+		}
+		directives = append(directives, strings.Join(fields, " "))
+	}
+	if !found {
+		script := append([]string{"script-src"}, fallback...)
+		directives = append(directives, strings.Join(append(script, source), " "))
+	}
+	return strings.Join(directives, "; ")
+}
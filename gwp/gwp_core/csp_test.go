@@ -0,0 +1,79 @@
+package gwp_core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+func TestAddScriptNonce(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"default-src 'self'; script-src 'self'", "default-src 'self'; script-src 'self' 'nonce-n'"},
+		{"default-src 'self' cdn.example.com; ", "default-src 'self' cdn.example.com; script-src 'self' cdn.example.com 'nonce-n'"},
+		{"object-src 'none'", "object-src 'none'; script-src 'nonce-n'"},
+	}
+	for _, test := range tests {
+		if got := addScriptNonce(test.policy, "n"); got != test.want {
+			t.Errorf("addScriptNonce(%q) = %q, want %q", test.policy, got, test.want)
+		}
+	}
+}
+
+func TestCSPMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_csp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "page.html"), []byte(`<script nonce="{{cspNonce}}"></script>{{liveReload}}`), 0644)
+	ctx := newTestContext(dir)
+	ctx.Config().ContentSecurityPolicy = "default-src 'self'"
+	ctx.Config().LiveTemplates = true
+
+	var nonce string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r)
+		if err := gwp_template.Render(ctx, w, r, "page.html", nil); err != nil {
+			t.Error(err)
+		}
+	})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		CleanupMiddleware(ctx, CSPMiddleware(ctx, h)).ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve()
+	if nonce == "" {
+		t.Fatal("Expected nonce to be set for the request")
+	}
+	if want := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'"; w.Header().Get("Content-Security-Policy") != want {
+		t.Errorf("Expected policy %q, got %q", want, w.Header().Get("Content-Security-Policy"))
+	}
+	if strings.Count(w.Body.String(), `<script nonce="`+nonce+`">`) != 2 {
+		t.Errorf("Expected nonce in the page and in live reload script, got %q", w.Body.String())
+	}
+
+	first := nonce
+	serve()
+	if nonce == first {
+		t.Errorf("Expected a new nonce for every request")
+	}
+
+	ctx.Config().ContentSecurityPolicy = ""
+	if w := serve(); w.Header().Get("Content-Security-Policy") != "" || nonce != "" {
+		t.Errorf("Expected no policy and no nonce when not configured")
+	} else if !strings.Contains(w.Body.String(), `<script>new EventSource`) {
+		t.Errorf("Expected live reload script without nonce, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,83 @@
+package gwp_core
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
+)
+
+// ----------------------------------------
+// CSRF protection
+// ----------------------------------------
+
+// CSRFFieldName is the name of form field (and cookie) carrying the CSRF token
+const CSRFFieldName = "_csrf"
+
+// CSRFHeaderName is the request header checked for CSRF token when the form field is not present
+const CSRFHeaderName = "X-CSRF-Token"
+
+type csrfKey int
+
+const csrfTokenKey csrfKey = 0
+
+func init() {
+	gwp_template.AddRequestFunc("csrfField", func(r *http.Request) interface{} {
+		return func() template.HTML {
+			if r == nil {
+				return ""
+			}
+			return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+				CSRFFieldName, template.HTMLEscapeString(CSRFToken(r))))
+		}
+	})
+}
+
+// CSRFMiddleware makes sure every client has a CSRF token. The token is kept in a cookie
+// for the lifetime of the browser session and stored in gorilla context for the current request.
+// The cookie is marked secure when the client uses https, see RequestScheme.
+func CSRFMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if c, err := r.Cookie(CSRFFieldName); err == nil && len(c.Value) == 64 {
+			token = c.Value
+		} else {
+			token = fmt.Sprintf("%x", securecookie.GenerateRandomKey(32))
+			http.SetCookie(w, &http.Cookie{Name: CSRFFieldName, Value: token, Path: "/", HttpOnly: true,
+				Secure: RequestScheme(r) == "https"})
+		}
+		context.DefaultContext.Set(r, csrfTokenKey, token)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// CSRFToken returns CSRF token for the current request, or empty string if CSRFMiddleware is not in use.
+func CSRFToken(r *http.Request) string {
+	return context.DefaultContext.GetString(r, csrfTokenKey)
+}
+
+// VerifyCSRF checks the token submitted with the request (form field or header) against the client's token.
+// Safe methods (GET, HEAD, OPTIONS) are always allowed. Requests with Origin header of another
+// site are refused, Origin is compared with RequestScheme and RequestHost.
+func VerifyCSRF(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != RequestScheme(r)+"://"+RequestHost(r) {
+		return false
+	}
+	token := CSRFToken(r)
+	if token == "" {
+		return false
+	}
+	submitted := r.FormValue(CSRFFieldName)
+	if submitted == "" {
+		submitted = r.Header.Get(CSRFHeaderName)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(submitted)) == 1
+}
@@ -0,0 +1,140 @@
+package gwp_core
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+// serveCSRF serves r with h wrapped in CSRFMiddleware, and returns the new CSRF cookie, if any
+func serveCSRF(ctx *gwp_context.Context, h http.HandlerFunc, r *http.Request) (*httptest.ResponseRecorder, *http.Cookie) {
+	w := httptest.NewRecorder()
+	CleanupMiddleware(ctx, CSRFMiddleware(h)).ServeHTTP(w, r)
+	for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+		if c.Name == CSRFFieldName {
+			return w, c
+		}
+	}
+	return w, nil
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	ctx := gwp_context.NewContext()
+	var token string
+	h := func(w http.ResponseWriter, r *http.Request) { token = CSRFToken(r) }
+
+	// missing cookie, a new token is issued
+	r, _ := http.NewRequest("GET", "/", nil)
+	_, c := serveCSRF(ctx, h, r)
+	if c == nil || len(c.Value) != 64 || c.Value != token || !c.HttpOnly || c.Secure {
+		t.Fatalf("Expected a new token cookie, got %v for token %q", c, token)
+	}
+
+	// valid cookie, the token is kept
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	if _, c2 := serveCSRF(ctx, h, r); c2 != nil || token != c.Value {
+		t.Errorf("Expected token to be kept, got %v for token %q", c2, token)
+	}
+
+	// malformed cookie, the token is rotated
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: CSRFFieldName, Value: "short"})
+	if _, c2 := serveCSRF(ctx, h, r); c2 == nil || c2.Value == c.Value || token != c2.Value {
+		t.Errorf("Expected a new token, got %v for token %q", c2, token)
+	}
+
+	// https clients get a secure cookie
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.TLS = new(tls.ConnectionState)
+	if _, c := serveCSRF(ctx, h, r); c == nil || !c.Secure {
+		t.Errorf("Expected a secure cookie for https, got %v", c)
+	}
+}
+
+func TestVerifyCSRF(t *testing.T) {
+	ctx := gwp_context.NewContext()
+	r, _ := http.NewRequest("GET", "/", nil)
+	_, cookie := serveCSRF(ctx, func(http.ResponseWriter, *http.Request) {}, r)
+	if cookie == nil {
+		t.Fatal("Expected a token cookie")
+	}
+	token := cookie.Value
+
+	tests := []struct {
+		method string
+		form   string
+		header http.Header
+		cookie bool
+		want   bool
+	}{
+		{"GET", "", nil, false, true},
+		{"HEAD", "", nil, false, true},
+		{"OPTIONS", "", nil, true, true},
+		{"POST", "", nil, true, false},                                           // missing token
+		{"POST", "_csrf=" + strings.Repeat("0", 64), nil, true, false},           // wrong token
+		{"POST", "_csrf=" + token, nil, false, false},                            // token without the cookie
+		{"POST", "_csrf=" + token, nil, true, true},                              // form field
+		{"DELETE", "", http.Header{CSRFHeaderName: {token}}, true, true},         // header
+		{"PUT", "", http.Header{CSRFHeaderName: {"x" + token[1:]}}, true, false}, // wrong header
+		{"POST", "_csrf=" + token, http.Header{"Origin": {"http://evil.example"}}, true, false},
+		{"POST", "_csrf=" + token, http.Header{"Origin": {"http://example.com"}}, true, true},
+	}
+	for i, test := range tests {
+		r, _ := http.NewRequest(test.method, "http://example.com/", strings.NewReader(test.form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range test.header {
+			r.Header[http.CanonicalHeaderKey(k)] = v
+		}
+		if test.cookie {
+			r.AddCookie(cookie)
+		}
+		var ok bool
+		serveCSRF(ctx, func(w http.ResponseWriter, r *http.Request) { ok = VerifyCSRF(r) }, r)
+		if ok != test.want {
+			t.Errorf("%d: %s %q %v: expected %v, got %v", i, test.method, test.form, test.header, test.want, ok)
+		}
+	}
+
+	// without the middleware there is no token to check against
+	r, _ = http.NewRequest("POST", "/", strings.NewReader(url.Values{CSRFFieldName: {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+	if VerifyCSRF(r) {
+		t.Errorf("Expected request to fail without CSRFMiddleware")
+	}
+}
+
+func TestCSRFField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_csrf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "form.html"), []byte(`<form>{{csrfField}}</form>`), 0644)
+	ctx := newTestContext(dir)
+
+	var token, out string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+		b, err := gwp_template.Execute(ctx, r, "form.html", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		out = string(b)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	serveCSRF(ctx, h, r)
+	if want := `<form><input type="hidden" name="_csrf" value="` + token + `"></form>`; token == "" || out != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+}
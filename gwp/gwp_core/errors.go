@@ -0,0 +1,264 @@
+package gwp_core
+
+import (
+	"bytes"
+	stdctx "context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
+)
+
+// ----------------------------------------
+// Error pages
+// ----------------------------------------
+
+// ErrRequestTimeout is passed to Error when TimeoutMiddleware gives up on a request
+var ErrRequestTimeout = errors.New("request timed out")
+
+// ErrorPage is the data error templates are executed with
+type ErrorPage struct {
+	Status     int
+	StatusText string
+	Detail     string // error detail, set in dev-mode only
+}
+
+// Error responds to the request with the given status code and an error page.
+// Page is rendered from errors/<status>.html template, falling back to errors/default.html,
+// and then to plain text. In dev-mode, err detail is shown on the page, otherwise it's hidden.
+// Template parse errors (*gwp_template.ParseError) get a dev-mode page showing the template
+// source around the offending line.
+// Templates are available only for requests served through CleanupMiddleware.
+func Error(w http.ResponseWriter, r *http.Request, status int, err error) {
+	page := &ErrorPage{Status: status, StatusText: http.StatusText(status)}
+	ctx := requestContext(r)
+	if err != nil && ctx != nil && ctx.Config().DevMode {
+		page.Detail = err.Error()
+	}
+
+	if pe, ok := err.(*gwp_template.ParseError); ok && page.Detail != "" {
+		if templateErrorPage(w, status, pe) == nil {
+			return
+		}
+	}
+
+	if ctx != nil {
+		for _, name := range []string{"errors/" + strconv.Itoa(status) + ".html", "errors/default.html"} {
+			if out, e := gwp_template.Execute(ctx, r, name, page); e == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(status)
+				w.Write(out)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%d %s\n", status, page.StatusText)
+	if page.Detail != "" {
+		fmt.Fprintln(w, page.Detail)
+	}
+}
+
+// templateErrorTpl is the dev-mode page for template parse errors. It's built in, as
+// the error templates may be broken too.
+var templateErrorTpl = template.Must(template.New("parse error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Status}} {{.StatusText}}</title>
+<style>
+	body { font-family: sans-serif; }
+	pre { background: #f4f4f4; padding: 8px; }
+	.line { color: #888; }
+	.error { background: #fdd; display: block; }
+</style>
+</head>
+<body>
+<h1>Template parse error</h1>
+<p>{{.Err}}</p>
+<h2>{{.File}}</h2>
+<pre>{{range .Lines}}<span{{if .Error}} class="error"{{end}}><span class="line">{{printf "%4d" .Num}}</span>  {{.Text}}</span>
+{{end}}</pre>
+</body>
+</html>
+`))
+
+// templateErrorContext is the number of lines shown around the line of a template parse error
+const templateErrorContext = 5
+
+// sourceLine is a line of template source on the parse error page
+type sourceLine struct {
+	Num   int
+	Text  string
+	Error bool
+}
+
+// templateErrorPage responds with the template source around the parse error
+func templateErrorPage(w http.ResponseWriter, status int, pe *gwp_template.ParseError) error {
+	src, err := ioutil.ReadFile(pe.File)
+	if err != nil {
+		return err
+	}
+	all := strings.Split(string(src), "\n")
+	from, to := 0, len(all)
+	if pe.Line > 0 {
+		if from = pe.Line - 1 - templateErrorContext; from < 0 {
+			from = 0
+		}
+		if to = pe.Line + templateErrorContext; to > len(all) {
+			to = len(all)
+		}
+	}
+	var lines []sourceLine
+	for i := from; i < to; i++ {
+		lines = append(lines, sourceLine{Num: i + 1, Text: all[i], Error: i+1 == pe.Line})
+	}
+
+	buf := new(bytes.Buffer)
+	err = templateErrorTpl.Execute(buf, map[string]interface{}{
+		"Status": status, "StatusText": http.StatusText(status),
+		"Err": pe.Err.Error(), "File": pe.File, "Lines": lines,
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// NotFound replies to the request with 404 error page
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusNotFound, nil)
+}
+
+// RecoveryMiddleware wraps h, turning panics into 500 error page.
+// In dev-mode the page shows the panic value and stack trace.
+func RecoveryMiddleware(ctx *gwp_context.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				stack := debug.Stack()
+				Metrics.Counter("gwp_panics_total").Inc()
+				fmt.Printf("Recovered from panic serving %s: %v\n%s", r.URL.Path, rec, stack)
+				Error(w, r, http.StatusInternalServerError, fmt.Errorf("panic: %v\n\n%s", rec, stack))
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// TimeoutMiddleware wraps h, replying with 503 error page if h doesn't finish within
+// configured request-timeout. Output written by h after the timeout is discarded.
+// h gets a request whose context is canceled on timeout, so it can stop early; the request
+// resources are released by CleanupMiddleware only after h returns.
+// The live reload stream, when live-templates is on, is long lived and not subject to the timeout.
+func TimeoutMiddleware(ctx *gwp_context.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := ctx.Config().RequestTimeout
+		if timeout <= 0 || isLiveReload(ctx, r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c, cancel := stdctx.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		// the copy gets the gorilla context values of r, and has them cleared when h returns
+		inner := r.WithContext(c)
+		context.DefaultContext.SetMulti(inner, context.DefaultContext.GetAll(r))
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})     // h returned
+		finished := make(chan struct{}) // h returned or panicked
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer close(finished)
+			defer context.DefaultContext.Clear(inner)
+			defer func() {
+				if rec := recover(); rec != nil {
+					r.MultipartForm = inner.MultipartForm
+					panicked <- rec
+				}
+			}()
+			h.ServeHTTP(tw, inner)
+			// so CleanupMiddleware removes temporary files of the form parsed by h
+			r.MultipartForm = inner.MultipartForm
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.buf.Bytes())
+		case rec := <-panicked:
+			// let RecoveryMiddleware handle it
+			panic(rec)
+		case <-time.After(timeout):
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			context.DefaultContext.Set(r, detachedKey, finished)
+			Error(w, r, http.StatusServiceUnavailable, ErrRequestTimeout)
+		}
+	})
+}
+
+// timeoutWriter buffers the response until the handler finishes
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// requestContext returns the runtime Context stored for the request by CleanupMiddleware
+func requestContext(r *http.Request) *gwp_context.Context {
+	ctx, _ := context.DefaultContext.Get(r, appContextKey).(*gwp_context.Context)
+	return ctx
+}
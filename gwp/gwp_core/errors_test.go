@@ -0,0 +1,166 @@
+package gwp_core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
+)
+
+// newTestContext returns a Context loading templates from dir
+func newTestContext(dir string) *gwp_context.Context {
+	ctx := gwp_context.NewContext()
+	ctx.Config().TemplatePath = dir + "/"
+	go func() {
+		for _ = range ctx.LiveTplMsg {
+			// templates are not cached in tests
+		}
+	}()
+	return ctx
+}
+
+func serveError(ctx *gwp_context.Context, status int, err error) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, status, err)
+	})
+	CleanupMiddleware(ctx, h).ServeHTTP(w, r)
+	return w
+}
+
+func TestErrorFallbackChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "errors"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "errors", "404.html"), []byte("custom {{.Status}}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "errors", "default.html"), []byte("default {{.Status}} {{.Detail}}"), 0644)
+	ctx := newTestContext(dir)
+
+	tests := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusNotFound, "custom 404"},
+		{http.StatusInternalServerError, "default 500 "},
+	}
+	for _, test := range tests {
+		w := serveError(ctx, test.status, os.ErrInvalid)
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("Expected %d %q, got %d %q", test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	// err detail is shown in dev-mode only
+	ctx.Config().DevMode = true
+	if w := serveError(ctx, http.StatusBadRequest, os.ErrInvalid); w.Body.String() != "default 400 "+os.ErrInvalid.Error() {
+		t.Errorf("Expected error detail in dev-mode, got %q", w.Body.String())
+	}
+	ctx.Config().DevMode = false
+
+	// plain text when there are no templates
+	os.Remove(filepath.Join(dir, "errors", "default.html"))
+	w := serveError(ctx, http.StatusInternalServerError, os.ErrInvalid)
+	if w.Code != 500 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ||
+		w.Body.String() != "500 Internal Server Error\n" {
+		t.Errorf("Expected plain text 500, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTemplateParseErrorPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := "<html>\n<body>\n{{if .X}}\n<p>{{.Y</p>\n</body>\n</html>\n"
+	ioutil.WriteFile(filepath.Join(dir, "broken.html"), []byte(src), 0644)
+	ctx := newTestContext(dir)
+
+	_, err = gwp_template.Load(ctx, "broken.html")
+	pe, ok := err.(*gwp_template.ParseError)
+	if !ok || pe.Line != 4 {
+		t.Fatalf("Expected ParseError at line 4, got %#v", err)
+	}
+
+	ctx.Config().DevMode = true
+	w := serveError(ctx, http.StatusInternalServerError, err)
+	if w.Code != 500 || !strings.Contains(w.Body.String(), `<span class="error"><span class="line">   4</span>  &lt;p&gt;{{.Y&lt;/p&gt;</span>`) {
+		t.Errorf("Expected source with highlighted line, got %d %q", w.Code, w.Body.String())
+	}
+
+	// production hides it
+	ctx.Config().DevMode = false
+	w = serveError(ctx, http.StatusInternalServerError, err)
+	if w.Body.String() != "500 Internal Server Error\n" {
+		t.Errorf("Expected generic 500, got %q", w.Body.String())
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	ctx := gwp_context.NewContext()
+	ctx.Config().RequestTimeout = 20 * time.Millisecond
+	release := make(chan struct{})
+	kept := make(chan bool)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			w.Write([]byte("ok"))
+			return
+		}
+		context.DefaultContext.Set(r, "user", "admin")
+		<-r.Context().Done()
+		<-release
+		// request values are kept until the handler returns
+		kept <- requestContext(r) == ctx && context.DefaultContext.GetString(r, "user") == "admin"
+	})
+	serve := func(path string) (*httptest.ResponseRecorder, *http.Request) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		CleanupMiddleware(ctx, TimeoutMiddleware(ctx, h)).ServeHTTP(w, r)
+		return w, r
+	}
+
+	if w, _ := serve("/fast"); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("expected handler output, got %d %q", w.Code, w.Body.String())
+	}
+
+	w, r := serve("/slow")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+	close(release)
+	if !<-kept {
+		t.Errorf("expected request values to be kept while the handler runs")
+	}
+	for i := 0; i < 100 && requestContext(r) != nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if requestContext(r) != nil {
+		t.Errorf("expected request values to be cleared after the handler returned")
+	}
+
+	// only the live reload stream is left without a deadline, and only when it's served
+	ctx.Config().LiveTemplates = true
+	for path, want := range map[string]bool{LiveReloadPath: false, "/events": true} {
+		var deadline bool
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "text/event-stream")
+		TimeoutMiddleware(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, deadline = r.Context().Deadline()
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if deadline != want {
+			t.Errorf("%s: expected deadline %v, got %v", path, want, deadline)
+		}
+	}
+}
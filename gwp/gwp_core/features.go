@@ -0,0 +1,43 @@
+package gwp_core
+
+import (
+	"sync"
+
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+// ----------------------------------------
+// Feature flags
+// ----------------------------------------
+
+func init() {
+	gwp_template.AddFunc("feature", func(name string) string {
+		value, _ := Feature(name)
+		return value
+	})
+}
+
+var (
+	featuresMu sync.RWMutex
+	features   map[string]string
+)
+
+// Feature returns value of the named flag from [features] config section, and whether it's set.
+// Flags are usually on/off switches, but can hold any string. In templates, {{feature "name"}}
+// returns the value, or "" if the flag is missing:
+//
+//	{{if eq (feature "new-checkout") "on"}}...{{end}}
+func Feature(name string) (string, bool) {
+	featuresMu.RLock()
+	defer featuresMu.RUnlock()
+	value, ok := features[name]
+	return value, ok
+}
+
+// SetFeatures replaces the flags read by Feature, usually with AppConfig.Features.
+// It's called on startup and on config reload.
+func SetFeatures(flags map[string]string) {
+	featuresMu.Lock()
+	features = flags
+	featuresMu.Unlock()
+}
@@ -3,7 +3,11 @@ package gwp_core
 import (
 	"errors"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 	"github.com/scyth/go-webproject/gwp/libs/goconf"
         "github.com/scyth/go-webproject/gwp/libs/inotify"
 	"github.com/scyth/go-webproject/gwp/gwp_context"
@@ -14,15 +18,34 @@ import (
 // ----------------------------------------
 
 const (
-	dflt_conf_addr    = "127.0.0.1:8000"
-	dflt_conf_mux     = true
-	dflt_conf_tmpdir  = "/tmp/"
-	dflt_conf_livetpl = false
+	dflt_conf_addr        = "127.0.0.1:8000"
+	dflt_conf_mux         = true
+	dflt_conf_tmpdir      = "/tmp/"
+	dflt_conf_livetpl     = false
+	dflt_conf_maint_tpl   = "maintenance.html"
+	dflt_conf_maint_retry = 300
+	dflt_conf_multipart   = 32 << 20
+	dflt_conf_keepalive   = true
+	dflt_conf_ka_period   = 180
+	dflt_conf_proxy_hdrs  = "X-Forwarded-Proto, X-Forwarded-Host, X-Forwarded-For"
+	dflt_conf_limit_retry = 5
 )
 
+// ConfigError describes invalid setting of the configuration file
+type ConfigError struct {
+	Section string
+	Key     string
+	Problem string
+}
+
+func (e *ConfigError) Error() string {
+	return "Configuration error, [" + e.Section + "] " + e.Key + ": " + e.Problem
+}
+
 // ParseConfig parses the configuration file and does meaningful checks on defined parameters.
 // If optional parameters are not met, it sets default values.
-// It parses only [default] and [project] sections.
+// It parses only [default] and [project] sections. Invalid settings are reported as *ConfigError.
+// Project root defaults to the directory holding the config file.
 func ParseConfig(configPath string) (*gwp_context.AppConfig, error) {
 	ac := gwp_context.NewAppConfig()
 
@@ -43,10 +66,105 @@ func ParseConfig(configPath string) (*gwp_context.AppConfig, error) {
 		conf_mux = dflt_conf_mux
 	}
 
+	conf_maint, err := c.GetBool("default", "maintenance")
+	if err != nil {
+		conf_maint = false
+	}
+
+	conf_maint_tpl, err := c.GetString("default", "maintenance-template")
+	if err != nil {
+		conf_maint_tpl = dflt_conf_maint_tpl
+	}
+
+	conf_maint_retry, err := c.GetInt("default", "maintenance-retry-after")
+	if err != nil {
+		conf_maint_retry = dflt_conf_maint_retry
+	}
+
+	conf_maint_paths, _ := c.GetString("default", "maintenance-allow-paths")
+	conf_maint_ips, _ := c.GetString("default", "maintenance-allow-ips")
+
+	conf_multipart, err := c.GetInt("default", "max-multipart-memory")
+	if err != nil {
+		conf_multipart = dflt_conf_multipart
+	}
+
+	conf_bodysize, err := c.GetInt("default", "max-body-size")
+	if err != nil {
+		conf_bodysize = 0
+	}
+
+	conf_version_path, err := c.GetString("default", "version-path")
+	if err != nil {
+		conf_version_path = ""
+	}
+
+	conf_metrics_path, err := c.GetString("default", "metrics-path")
+	if err != nil {
+		conf_metrics_path = ""
+	}
+
+	conf_devmode, err := c.GetBool("default", "dev-mode")
+	if err != nil {
+		conf_devmode = false
+	}
+
+	conf_timeout, err := c.GetInt("default", "request-timeout")
+	if err != nil {
+		conf_timeout = 0
+	}
+
+	conf_max_requests, err := c.GetInt("default", "max-requests")
+	if err != nil {
+		conf_max_requests = 0
+	}
+
+	conf_max_requests_wait, err := c.GetInt("default", "max-requests-wait")
+	if err != nil {
+		conf_max_requests_wait = 0
+	}
+
+	conf_max_requests_retry, err := c.GetInt("default", "max-requests-retry-after")
+	if err != nil {
+		conf_max_requests_retry = dflt_conf_limit_retry
+	}
+
+	conf_csp, err := c.GetString("default", "content-security-policy")
+	if err != nil {
+		conf_csp = ""
+	}
+
+	conf_proxies, err := c.GetString("default", "trusted-proxies")
+	if err != nil {
+		conf_proxies = ""
+	}
+
+	conf_proxy_headers, err := c.GetString("default", "proxy-headers")
+	if err != nil {
+		conf_proxy_headers = dflt_conf_proxy_hdrs
+	}
+
+	conf_keepalive, err := c.GetBool("default", "keep-alive")
+	if err != nil {
+		conf_keepalive = dflt_conf_keepalive
+	}
+
+	conf_ka_period, err := c.GetInt("default", "keep-alive-period")
+	if err != nil {
+		conf_ka_period = dflt_conf_ka_period
+	}
+
 	// read params from [project] section
 	conf_root, err := c.GetString("project", "root")
 	if err != nil {
-		return nil, err
+		// default to the directory holding the config file
+		abs, err := filepath.Abs(configPath)
+		if err != nil {
+			return nil, &ConfigError{"project", "root", "not set, and config file directory is unknown: " + err.Error()}
+		}
+		conf_root = filepath.Dir(abs)
+	} else if _, err := os.Stat(conf_root); err != nil {
+		return nil, &ConfigError{"project", "root", "directory does not exist: " + conf_root}
 	}
 	if !strings.HasSuffix(conf_root, "/") {
 		conf_root += "/"
@@ -73,9 +191,19 @@ func ParseConfig(configPath string) (*gwp_context.AppConfig, error) {
 		conf_livetpl = dflt_conf_livetpl
 	}
 
+	// read flags from [features] section, options inherited from [default] are skipped
+	conf_features := make(map[string]string)
+	if names, err := c.GetOptions("features"); err == nil {
+		for _, name := range names {
+			if value, err := c.GetString("features", name); err == nil {
+				conf_features[name] = value
+			}
+		}
+	}
+
 	testpath := conf_tmpdir + "go-webproject_tmptest"
 	if err := os.Mkdir(testpath, 0755); err != nil {
-		return nil, errors.New("Error with tmp dir configuration: " + err.Error())
+		return nil, &ConfigError{"project", "tmpDir", "directory is not writable: " + err.Error()}
 	} else {
 		os.Remove(testpath)
 	}
@@ -83,7 +211,7 @@ func ParseConfig(configPath string) (*gwp_context.AppConfig, error) {
 	p := strings.TrimSpace(conf_template_path)
 	// check if path exists
 	if _, err := os.Stat(p); err != nil {
-		return nil, errors.New("Configuration error, template directory does not exist: " + conf_template_path)
+		return nil, &ConfigError{"project", "templatePath", "template directory does not exist: " + conf_template_path}
 	}
 
 	ac.ListenAddr = conf_addr
@@ -96,9 +224,41 @@ func ParseConfig(configPath string) (*gwp_context.AppConfig, error) {
 	ac.TempDir = conf_tmpdir
 	ac.TemplatePath = conf_template_path
 	ac.LiveTemplates = conf_livetpl
+	ac.Maintenance = conf_maint
+	ac.MaintenanceTemplate = conf_maint_tpl
+	ac.MaintenanceRetryAfter = conf_maint_retry
+	ac.MaintenanceAllowPaths = splitList(conf_maint_paths)
+	ac.MaintenanceAllowIPs = splitList(conf_maint_ips)
+	ac.MaxMultipartMemory = int64(conf_multipart)
+	ac.MaxBodySize = int64(conf_bodysize)
+	ac.DevMode = conf_devmode
+	ac.VersionPath = conf_version_path
+	ac.MetricsPath = conf_metrics_path
+	ac.RequestTimeout = time.Duration(conf_timeout) * time.Second
+	ac.MaxRequests = conf_max_requests
+	ac.MaxRequestsWait = time.Duration(conf_max_requests_wait) * time.Second
+	ac.MaxRequestsRetryAfter = conf_max_requests_retry
+	ac.TrustedProxies = splitList(conf_proxies)
+	ac.ProxyHeaders = splitList(conf_proxy_headers)
+	ac.KeepAlive = conf_keepalive
+	ac.KeepAlivePeriod = time.Duration(conf_ka_period) * time.Second
+	ac.ContentSecurityPolicy = conf_csp
+	ac.Features = conf_features
 	return ac, nil
 }
 
+// splitList splits comma separated config value into a slice of trimmed, non-empty items
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 
 // ParseConfigParams parses module specific config file parameters
 func ParseConfigParams(configPath string, section string, params *gwp_context.ModParams) (error) {
@@ -165,14 +325,40 @@ func ParseConfigParams(configPath string, section string, params *gwp_context.Mo
 // ----------------------------------------
 
 var (
-	WatchList map[string]bool
+	WatchList map[string]bool // files watched for changes, use WatchedTemplates to read it
+	watchMu   sync.RWMutex    // guards WatchList
 )
 
+// WatchedTemplates returns the template files currently watched for changes
+func WatchedTemplates() []string {
+	watchMu.RLock()
+	defer watchMu.RUnlock()
+	names := make([]string, 0, len(WatchList))
+	for name, watched := range WatchList {
+		if watched {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cacheTemplate stores the parsed template, unless templates were invalidated since it was read.
+func cacheTemplate(ctx *gwp_context.Context, pt *gwp_context.ParsedTemplate) bool {
+	ctx.TemplatesMu.Lock()
+	defer ctx.TemplatesMu.Unlock()
+	if pt.Gen != ctx.TemplatesGen {
+		return false
+	}
+	ctx.Templates[pt.Name] = pt.Tpl
+	return true
+}
+
 // WatchTemplates is responsible for template caching
 // and live reloading (if live-templates option is activated)
 func WatchTemplates(ctx *gwp_context.Context) {
 	// we're tracking live changes to template files
-	if ctx.App.LiveTemplates == true {
+	if ctx.Config().LiveTemplates == true {
 		watcher, err := inotify.NewWatcher()
 		if err != nil {
 			ctx.ErrorMsg <- errors.New("Could not create inotify watcher: " + err.Error())
@@ -180,19 +366,25 @@ func WatchTemplates(ctx *gwp_context.Context) {
 		}
 		defer watcher.Close()
 
+		watchMu.Lock()
 		WatchList = make(map[string]bool)
+		watchMu.Unlock()
 
 		for {
 			select {
 			case ev := <-watcher.Event:
 				// cached file was modified
-				if ctx.Templates[ev.Name] != nil {
-					delete(ctx.Templates, ev.Name)
-				}
+				ctx.TemplatesMu.Lock()
+				delete(ctx.Templates, ev.Name)
+				ctx.TemplatesMu.Unlock()
+				watchMu.Lock()
 				if WatchList[ev.Name] == true {
 					watcher.RemoveWatch(ev.Name)
 					WatchList[ev.Name] = false
 				}
+				watchMu.Unlock()
+				// let the browsers reload the page
+				liveReload.notify(ev.Name)
 
 			case ev := <-watcher.Error:
 				// this probably means something has gone terribly wrong, so we exit
@@ -200,9 +392,12 @@ func WatchTemplates(ctx *gwp_context.Context) {
 				return
 
 			case ev := <-ctx.LiveTplMsg:
-				ctx.Templates[ev.Name] = ev.Tpl
+				if !cacheTemplate(ctx, ev) {
+					continue
+				}
 
 				// check if we're already watching this file name
+				watchMu.Lock()
 				if WatchList[ev.Name] == true {
 					watcher.RemoveWatch(ev.Name)
 					watcher.AddWatch(ev.Name, inotify.IN_MODIFY)
@@ -210,14 +405,33 @@ func WatchTemplates(ctx *gwp_context.Context) {
 					watcher.AddWatch(ev.Name, inotify.IN_MODIFY)
 					WatchList[ev.Name] = true
 				}
+				watchMu.Unlock()
+
+			case name := <-ctx.InvalidTplMsg:
+				// template was dropped from cache by gwp_template.Invalidate
+				// files cached again since then are still watched
+				ctx.TemplatesMu.RLock()
+				watchMu.Lock()
+				for watched, on := range WatchList {
+					if on && (name == "" || name == watched) && ctx.Templates[watched] == nil {
+						watcher.RemoveWatch(watched)
+						WatchList[watched] = false
+					}
+				}
+				watchMu.Unlock()
+				ctx.TemplatesMu.RUnlock()
 			}
 		}
 		// we're just preloading/caching templates. No runtime updates are possible.
 	} else {
 
 		for {
-			ev := <-ctx.LiveTplMsg
-			ctx.Templates[ev.Name] = ev.Tpl
+			select {
+			case ev := <-ctx.LiveTplMsg:
+				cacheTemplate(ctx, ev)
+			case <-ctx.InvalidTplMsg:
+				// nothing is watched
+			}
 		}
 	}
 
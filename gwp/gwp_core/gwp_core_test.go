@@ -0,0 +1,134 @@
+package gwp_core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+func TestParseConfigMissingRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "server.conf")
+	if err := ioutil.WriteFile(path, []byte("[default]\n\n[project]\ntmpdir = "+dir+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// root defaults to the config file directory, which has no templates yet
+	_, err = ParseConfig(path)
+	ce, ok := err.(*ConfigError)
+	if !ok || ce.Section != "project" || ce.Key != "templatePath" {
+		t.Fatalf("Expected templatePath ConfigError, got %v", err)
+	}
+
+	os.Mkdir(filepath.Join(dir, "templates"), 0755)
+	ac, err := ParseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.ProjectRoot != dir+"/" || ac.TemplatePath != dir+"/templates/" {
+		t.Errorf("Expected root %s/, got root %s and templates %s", dir, ac.ProjectRoot, ac.TemplatePath)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	ioutil.WriteFile(path, []byte("[project]\nroot = "+missing+"\ntmpdir = "+dir+"\n"), 0644)
+	_, err = ParseConfig(path)
+	if ce, ok := err.(*ConfigError); !ok || ce.Section != "project" || ce.Key != "root" {
+		t.Errorf("Expected root ConfigError, got %v", err)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "templates"), 0755)
+	path := filepath.Join(dir, "server.conf")
+	conf := "[default]\nlisten = :8080\n\n[project]\ntmpdir = " + dir + "\n\n[features]\nnew-checkout = on\nbanner = Spring sale\n"
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ac, err := ParseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ac.Features) != 2 {
+		t.Errorf("Expected 2 flags, without [default] options, got %v", ac.Features)
+	}
+	SetFeatures(ac.Features)
+	defer SetFeatures(nil)
+
+	if v, ok := Feature("new-checkout"); !ok || v != "on" {
+		t.Errorf("Expected new-checkout=on, got %q, %v", v, ok)
+	}
+	if v, ok := Feature("missing"); ok || v != "" {
+		t.Errorf("Expected missing flag, got %q, %v", v, ok)
+	}
+
+	tpl := `{{if eq (feature "new-checkout") "on"}}new{{end}} {{feature "banner"}}{{feature "missing"}}`
+	ioutil.WriteFile(filepath.Join(dir, "templates", "page.html"), []byte(tpl), 0644)
+	ctx := newTestContext(filepath.Join(dir, "templates"))
+	r, _ := http.NewRequest("GET", "/", nil)
+	out, err := gwp_template.Execute(ctx, r, "page.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "new Spring sale" {
+		t.Errorf("Expected flags in template output, got %q", out)
+	}
+}
+
+func TestWatchTemplatesInvalidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.html")
+	ioutil.WriteFile(path, []byte("old"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.html"), []byte("b"), 0644)
+	ctx := gwp_context.NewContext()
+	ctx.Config().TemplatePath = dir + "/"
+
+	// the old template is still queued for the watcher when the file changes
+	if _, err := gwp_template.Load(ctx, "a.html"); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path, []byte("new"), 0644)
+	gwp_template.Invalidate(ctx, "a.html")
+	go WatchTemplates(ctx)
+
+	// b.html is queued after a.html, once it's cached a.html was handled too
+	if _, err := gwp_template.Load(ctx, "b.html"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		ctx.TemplatesMu.RLock()
+		cached := ctx.Templates[dir+"/b.html"] != nil
+		ctx.TemplatesMu.RUnlock()
+		if cached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watcher didn't cache queued templates")
+		}
+	}
+	out, err := gwp_template.Execute(ctx, nil, "a.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "new" {
+		t.Errorf("expected the stale template not to be cached, got %q", out)
+	}
+}
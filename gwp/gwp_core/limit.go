@@ -0,0 +1,112 @@
+package gwp_core
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+// ----------------------------------------
+// Load shedding
+// ----------------------------------------
+
+// ErrOverloaded is passed to Error when a request is rejected by Limiter
+var ErrOverloaded = errors.New("too many requests in flight")
+
+// RequestLimiter is the Limiter installed by LimitMiddleware, nil if requests are not limited.
+// It's exposed for reading the metrics.
+var RequestLimiter *Limiter
+
+// Limiter caps the number of requests handled at once, protecting the service from overload.
+// Unlike rate limiting, it doesn't care who the requests come from.
+type Limiter struct {
+	Wait       time.Duration // how long a request may queue for a free slot, 0 rejects it right away
+	RetryAfter int           // Retry-After header sent with rejections, in seconds
+
+	sem      chan struct{}
+	inFlight int64
+	rejected int64
+}
+
+// Limit creates a Limiter allowing at most max requests to be handled at once
+func Limit(max int) *Limiter {
+	return &Limiter{sem: make(chan struct{}, max)}
+}
+
+// Handler wraps h, rejecting requests with 503 error page while the limit is reached.
+// The slot is released when h returns, even if it panics.
+func (l *Limiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire() {
+			atomic.AddInt64(&l.rejected, 1)
+			Metrics.Counter("gwp_requests_rejected_total").Inc()
+			if l.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(l.RetryAfter))
+			}
+			Error(w, r, http.StatusServiceUnavailable, ErrOverloaded)
+			return
+		}
+		atomic.AddInt64(&l.inFlight, 1)
+		defer func() {
+			atomic.AddInt64(&l.inFlight, -1)
+			<-l.sem
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// InFlight returns the number of requests being handled
+func (l *Limiter) InFlight() int64 {
+	return atomic.LoadInt64(&l.inFlight)
+}
+
+// Rejected returns the number of requests rejected so far
+func (l *Limiter) Rejected() int64 {
+	return atomic.LoadInt64(&l.rejected)
+}
+
+// acquire takes a slot, waiting for it up to l.Wait
+func (l *Limiter) acquire() bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.Wait <= 0 {
+		return false
+	}
+	t := time.NewTimer(l.Wait)
+	defer t.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// LimitMiddleware wraps h with a Limiter configured by max-requests, max-requests-wait
+// and max-requests-retry-after settings. If max-requests is 0, h is returned as is.
+// The live reload stream, when live-templates is on, is long lived and doesn't take a slot.
+func LimitMiddleware(ctx *gwp_context.Context, h http.Handler) http.Handler {
+	app := ctx.Config()
+	if app.MaxRequests <= 0 {
+		return h
+	}
+	l := Limit(app.MaxRequests)
+	l.Wait = app.MaxRequestsWait
+	l.RetryAfter = app.MaxRequestsRetryAfter
+	RequestLimiter = l
+	limited := l.Handler(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLiveReload(ctx, r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
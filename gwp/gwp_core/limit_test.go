@@ -0,0 +1,135 @@
+package gwp_core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+func TestLimiter(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	l := Limit(1)
+	l.RetryAfter = 7
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/block":
+			close(started)
+			<-release
+		case "/panic":
+			panic("boom")
+		}
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	done := make(chan struct{})
+	go func() {
+		serve("/block")
+		close(done)
+	}()
+	<-started
+	if n := l.InFlight(); n != 1 {
+		t.Errorf("expected 1 request in flight, got %d", n)
+	}
+
+	w := serve("/")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "7" {
+		t.Errorf("expected 503 with Retry-After: 7, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// a queued request gets the slot once it's released
+	l.Wait = time.Second
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	if w = serve("/"); w.Code != http.StatusOK {
+		t.Errorf("expected queued request to be served, got %d", w.Code)
+	}
+	<-done
+
+	// panicking handler must release its slot
+	func() {
+		defer func() { recover() }()
+		serve("/panic")
+	}()
+	l.Wait = 0
+	if w = serve("/"); w.Code != http.StatusOK {
+		t.Errorf("expected slot to be released after panic, got %d", w.Code)
+	}
+	if n := l.Rejected(); n != 1 {
+		t.Errorf("expected 1 rejected request, got %d", n)
+	}
+	if n := l.InFlight(); n != 0 {
+		t.Errorf("expected no requests in flight, got %d", n)
+	}
+}
+
+func TestLimitMiddlewareLiveReload(t *testing.T) {
+	defer func() { RequestLimiter = nil }()
+	ctx := gwp_context.NewContext()
+	ctx.Config().MaxRequests = 1
+	ctx.Config().LiveTemplates = true
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	h := LimitMiddleware(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	// hold opens a streaming request, returning a func ending it
+	hold := func(path string) func() {
+		done := make(chan struct{})
+		go func() {
+			r, _ := http.NewRequest("GET", path, nil)
+			r.Header.Set("Accept", "text/event-stream")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			close(done)
+		}()
+		<-started
+		return func() {
+			release <- struct{}{}
+			<-done
+		}
+	}
+	serve := func() int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// an open live reload stream doesn't take the only slot
+	end := hold(LiveReloadPath)
+	if n := RequestLimiter.InFlight(); n != 0 {
+		t.Errorf("expected live reload not to be counted, got %d in flight", n)
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("expected request to be served next to live reload, got %d", code)
+	}
+	end()
+
+	// other routes are limited, whatever they accept
+	end = hold("/events")
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected request to be rejected next to an event stream, got %d", code)
+	}
+	end()
+
+	// live reload is only exempt when it's served
+	ctx.Config().LiveTemplates = false
+	end = hold(LiveReloadPath)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected live reload to be limited without live-templates, got %d", code)
+	}
+	end()
+}
@@ -0,0 +1,110 @@
+package gwp_core
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+// ----------------------------------------
+// Template live reload
+// ----------------------------------------
+
+// LiveReloadPath is where browsers listen for template changes, when live-templates is on
+const LiveReloadPath = "/__livereload"
+
+// liveReloadScript is formatted with the nonce attribute, see CSPMiddleware
+const liveReloadScript = `<script%s>new EventSource("` + LiveReloadPath +
+	`").addEventListener("reload", function() { location.reload(); });</script>`
+
+func init() {
+	gwp_template.AddRequestFunc("liveReload", func(r *http.Request) interface{} {
+		return func() template.HTML {
+			if r == nil {
+				return ""
+			}
+			if ctx := requestContext(r); ctx != nil && ctx.Config().LiveTemplates {
+				nonce := ""
+				if n := CSPNonce(r); n != "" {
+					nonce = ` nonce="` + n + `"`
+				}
+				return template.HTML(fmt.Sprintf(liveReloadScript, nonce))
+			}
+			return ""
+		}
+	})
+}
+
+// reloadNotifier fans out template change events to connected browsers
+type reloadNotifier struct {
+	mu      sync.Mutex
+	clients map[chan string]bool
+}
+
+var liveReload = &reloadNotifier{clients: make(map[chan string]bool)}
+
+func (n *reloadNotifier) subscribe() chan string {
+	ch := make(chan string, 1)
+	n.mu.Lock()
+	n.clients[ch] = true
+	n.mu.Unlock()
+	return ch
+}
+
+func (n *reloadNotifier) unsubscribe(ch chan string) {
+	n.mu.Lock()
+	delete(n.clients, ch)
+	n.mu.Unlock()
+}
+
+// notify tells all the clients the template has changed. Slow clients already
+// having a pending event are skipped, they will reload anyway.
+func (n *reloadNotifier) notify(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.clients {
+		select {
+		case ch <- name:
+		default:
+		}
+	}
+}
+
+// isLiveReload checks if r is for the live reload stream, which is only served
+// when live-templates is on
+func isLiveReload(ctx *gwp_context.Context, r *http.Request) bool {
+	return r.URL.Path == LiveReloadPath && ctx.Config().LiveTemplates
+}
+
+// LiveReloadHandler returns a handler streaming server-sent "reload" events whenever
+// WatchTemplates sees a template change. Pages include the listening script with {{liveReload}}.
+// It is registered at LiveReloadPath when live-templates is on.
+func LiveReloadHandler(ctx *gwp_context.Context) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok || !ctx.Config().LiveTemplates {
+			NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ch := liveReload.subscribe()
+		defer liveReload.unsubscribe(ch)
+		for {
+			select {
+			case name := <-ch:
+				fmt.Fprintf(w, "event: reload\ndata: %s\n\n", name)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gwp_core
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+// ----------------------------------------
+// Maintenance mode
+// ----------------------------------------
+
+// MaintenanceMiddleware wraps h and, while maintenance mode is turned on in the config,
+// serves the maintenance template with status 503 and a Retry-After header.
+// Requests for allowed paths (eg. health checks) and requests coming from allowed
+// IP addresses are passed through to h.
+// Settings are read from ctx.Config() once per request, so reloaded configuration
+// takes effect without restarting the service.
+func MaintenanceMiddleware(ctx *gwp_context.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app := ctx.Config()
+		if !app.Maintenance || maintenanceAllowed(app, r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(app.MaintenanceRetryAfter))
+		out, err := gwp_template.Execute(ctx, r, app.MaintenanceTemplate, nil)
+		if err != nil || len(out) == 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			out = []byte("Service is temporarily down for maintenance.\n")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(out)
+	})
+}
+
+// maintenanceAllowed checks if request bypasses maintenance mode
+func maintenanceAllowed(app *gwp_context.AppConfig, r *http.Request) bool {
+	for _, p := range app.MaintenanceAllowPaths {
+		if r.URL.Path == p {
+			return true
+		}
+	}
+	ip := ClientIP(r)
+	for _, allowed := range app.MaintenanceAllowIPs {
+		if ip == allowed {
+			return true
+		}
+	}
+	return false
+}
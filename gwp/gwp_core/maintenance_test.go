@@ -0,0 +1,85 @@
+package gwp_core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "maintenance.html"), []byte(`<p>down for {{"maintenance"}}</p>`), 0644)
+	ctx := gwp_context.NewContext()
+	app := ctx.Config()
+	app.TemplatePath = dir + "/"
+	app.MaintenanceTemplate = "maintenance.html"
+	app.MaintenanceRetryAfter = 120
+	app.MaintenanceAllowPaths = []string{"/health"}
+	app.MaintenanceAllowIPs = []string{"10.0.0.1"}
+	go WatchTemplates(ctx)
+
+	h := MaintenanceMiddleware(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	serve := func(path, peer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = peer
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("/", "192.0.2.1:1234"); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("expected request to be served with maintenance off, got %d %q", w.Code, w.Body.String())
+	}
+
+	app.Maintenance = true
+	want := "<p>down for maintenance</p>"
+	w := serve("/", "192.0.2.1:1234")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "120" || w.Body.String() != want {
+		t.Errorf("expected maintenance page, got %d %q %q", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+
+	// the cached template is served again
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		ctx.TemplatesMu.RLock()
+		cached := ctx.Templates[dir+"/maintenance.html"] != nil
+		ctx.TemplatesMu.RUnlock()
+		if cached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("maintenance template wasn't cached")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if w := serve("/", "192.0.2.1:1234"); w.Code != http.StatusServiceUnavailable || w.Body.String() != want {
+			t.Errorf("expected cached maintenance page, got %d %q", w.Code, w.Body.String())
+		}
+	}
+
+	if w := serve("/health", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected allowed path to be served, got %d", w.Code)
+	}
+	if w := serve("/", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected allowed IP to be served, got %d", w.Code)
+	}
+
+	// plain text without a template
+	app.MaintenanceTemplate = "missing.html"
+	w = serve("/", "192.0.2.1:1234")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" ||
+		w.Body.String() != "Service is temporarily down for maintenance.\n" {
+		t.Errorf("expected plain text maintenance page, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,135 @@
+package gwp_core
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ----------------------------------------
+// Metrics
+// ----------------------------------------
+
+// Counter is a metric which only goes up, like the number of served requests
+type Counter interface {
+	Inc()
+	Add(delta float64)
+}
+
+// Gauge is a metric which can go up and down, like the number of open connections
+type Gauge interface {
+	Set(v float64)
+	Add(delta float64)
+}
+
+// MetricsRegistry creates named metrics. Asking for the same name again returns the same metric.
+type MetricsRegistry interface {
+	Counter(name string) Counter
+	Gauge(name string) Gauge
+}
+
+// Metrics is the registry modules and middleware record their metrics in. It can be replaced at
+// initialization time, eg. with an adapter to a Prometheus client. Names should follow
+// Prometheus naming, like "gwp_requests_total".
+var Metrics MetricsRegistry = NewRegistry()
+
+// Registry is the built-in MetricsRegistry. It's safe for concurrent use, and serves
+// its metrics in Prometheus text format.
+type Registry struct {
+	mu       sync.RWMutex
+	counters map[string]*metric
+	gauges   map[string]*metric
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{counters: make(map[string]*metric), gauges: make(map[string]*metric)}
+}
+
+// Counter returns the named counter, creating it if needed
+func (reg *Registry) Counter(name string) Counter {
+	return reg.get(reg.counters, name)
+}
+
+// Gauge returns the named gauge, creating it if needed
+func (reg *Registry) Gauge(name string) Gauge {
+	return reg.get(reg.gauges, name)
+}
+
+func (reg *Registry) get(metrics map[string]*metric, name string) *metric {
+	reg.mu.RLock()
+	m := metrics[name]
+	reg.mu.RUnlock()
+	if m != nil {
+		return m
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if m = metrics[name]; m == nil {
+		m = new(metric)
+		metrics[name] = m
+	}
+	return m
+}
+
+// ServeHTTP writes all the metrics in Prometheus text format
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-cache")
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	for _, kind := range []struct {
+		name    string
+		metrics map[string]*metric
+	}{{"counter", reg.counters}, {"gauge", reg.gauges}} {
+		names := make([]string, 0, len(kind.metrics))
+		for name := range kind.metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "# TYPE %s %s\n%s %v\n", name, kind.name, name, kind.metrics[name].value())
+		}
+	}
+}
+
+// MetricsHandler returns a handler serving Metrics, if the registry is an http.Handler
+// (like Registry is). It is registered at configured metrics-path, if set.
+func MetricsHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := Metrics.(http.Handler); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		NotFound(w, r)
+	}
+}
+
+// metric is a float64 value updated atomically, used for both counters and gauges
+type metric struct {
+	bits uint64
+}
+
+func (m *metric) Inc() {
+	m.Add(1)
+}
+
+func (m *metric) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&m.bits)
+		if atomic.CompareAndSwapUint64(&m.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (m *metric) Set(v float64) {
+	atomic.StoreUint64(&m.bits, math.Float64bits(v))
+}
+
+func (m *metric) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.bits))
+}
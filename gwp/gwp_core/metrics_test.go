@@ -0,0 +1,44 @@
+package gwp_core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRegistryConcurrent(t *testing.T) {
+	reg := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				reg.Counter("test_requests_total").Inc()
+				reg.Gauge("test_in_flight").Add(1)
+				reg.Gauge("test_in_flight").Add(-1)
+			}
+			reg.Gauge("test_last").Set(float64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	if v := reg.Counter("test_requests_total").(*metric).value(); v != 5000 {
+		t.Errorf("Expected counter 5000, got %v", v)
+	}
+	if v := reg.Gauge("test_in_flight").(*metric).value(); v != 0 {
+		t.Errorf("Expected gauge 0, got %v", v)
+	}
+
+	reg.Gauge("test_last").Set(2.5)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	reg.ServeHTTP(w, r)
+	want := "# TYPE test_requests_total counter\ntest_requests_total 5000\n" +
+		"# TYPE test_in_flight gauge\ntest_in_flight 0\n" +
+		"# TYPE test_last gauge\ntest_last 2.5\n"
+	if w.Body.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, w.Body.String())
+	}
+}
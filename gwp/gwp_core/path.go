@@ -0,0 +1,51 @@
+package gwp_core
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ----------------------------------------
+// Path normalization
+// ----------------------------------------
+
+// CleanPathMiddleware canonicalizes request path before it's routed, so paths like
+// "//admin", "/public/../admin" or "/admin " can't slip past route matching, or checks
+// done on the path. GET and HEAD requests are redirected (301) to the clean path, keeping the
+// query. Other methods can't be redirected safely, so their path is rewritten in place.
+// Trailing slash is kept, it's left for the router's strict-slash setting.
+func CleanPathMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := cleanPath(r.URL.Path)
+		if p == r.URL.Path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == "GET" || r.Method == "HEAD" {
+			u := *r.URL
+			u.Path, u.RawPath = p, ""
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+		r.URL.Path, r.URL.RawPath = p, ""
+		h.ServeHTTP(w, r)
+	})
+}
+
+// cleanPath returns canonical form of p: rooted, without trailing spaces, repeated slashes,
+// and "." or ".." elements. Trailing slash is kept.
+func cleanPath(p string) string {
+	p = strings.TrimRight(p, " \t")
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
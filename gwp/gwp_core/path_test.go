@@ -0,0 +1,50 @@
+package gwp_core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCleanPathMiddleware(t *testing.T) {
+	var served string
+	h := CleanPathMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.Path
+	}))
+
+	tests := []struct {
+		method string
+		url    string
+		want   string // path served, or redirect location
+		status int
+	}{
+		{"GET", "/admin", "/admin", 200},
+		{"GET", "/dir/", "/dir/", 200},
+		{"GET", "//admin//users", "/admin/users", 301},
+		{"GET", "/./admin", "/admin", 301},
+		{"GET", "/public/../admin?x=1", "/admin?x=1", 301},
+		{"GET", "/../../admin/", "/admin/", 301},
+		{"GET", "/admin%20%20", "/admin", 301},
+		{"HEAD", "/a/./b", "/a/b", 301},
+		{"POST", "//admin", "/admin", 200},
+		{"POST", "/public/../admin/", "/admin/", 200},
+		{"DELETE", "/admin%20", "/admin", 200},
+	}
+	for _, test := range tests {
+		served = ""
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://example.com"+test.url, nil)
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.status, w.Code)
+			continue
+		}
+		if test.status == 301 {
+			if loc := w.Header().Get("Location"); loc != test.want || served != "" {
+				t.Errorf("%s %s: expected redirect to %s, got %s", test.method, test.url, test.want, loc)
+			}
+		} else if served != test.want {
+			t.Errorf("%s %s: expected %s to be served, got %s", test.method, test.url, test.want, served)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package gwp_core
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+// ----------------------------------------
+// Reverse proxy trust
+// ----------------------------------------
+
+var (
+	proxyMu sync.RWMutex
+	proxy   = new(ProxyConfig)
+)
+
+// SetProxy replaces the policy used by RequestScheme, RequestHost and ClientIP.
+// It's called on startup and on config reload. By default no proxy is trusted,
+// so forwarding headers are ignored.
+func SetProxy(pc *ProxyConfig) {
+	proxyMu.Lock()
+	proxy = pc
+	proxyMu.Unlock()
+}
+
+// currentProxy returns the policy set with SetProxy
+func currentProxy() *ProxyConfig {
+	proxyMu.RLock()
+	defer proxyMu.RUnlock()
+	return proxy
+}
+
+// ProxyConfig decides whether X-Forwarded-* headers are honored for a request.
+// Headers are trusted only when the immediate peer is within one of Trusted networks.
+type ProxyConfig struct {
+	Trusted    []*net.IPNet
+	HonorProto bool // X-Forwarded-Proto
+	HonorHost  bool // X-Forwarded-Host
+	HonorFor   bool // X-Forwarded-For
+}
+
+// NewProxyConfig creates ProxyConfig from trusted-proxies and proxy-headers settings.
+func NewProxyConfig(app *gwp_context.AppConfig) (*ProxyConfig, error) {
+	pc := new(ProxyConfig)
+	for _, p := range app.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errors.New("Config file error, invalid trusted-proxies entry: " + p)
+		}
+		pc.Trusted = append(pc.Trusted, ipnet)
+	}
+	for _, h := range app.ProxyHeaders {
+		switch textproto.CanonicalMIMEHeaderKey(h) {
+		case "X-Forwarded-Proto":
+			pc.HonorProto = true
+		case "X-Forwarded-Host":
+			pc.HonorHost = true
+		case "X-Forwarded-For":
+			pc.HonorFor = true
+		default:
+			return nil, errors.New("Config file error, unsupported proxy-headers entry: " + h)
+		}
+	}
+	return pc, nil
+}
+
+// TrustedPeer checks if the immediate peer of the request is a trusted proxy
+func (pc *ProxyConfig) TrustedPeer(r *http.Request) bool {
+	return pc.trusted(remoteIP(r))
+}
+
+func (pc *ProxyConfig) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range pc.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestScheme returns "https" or "http", as seen by the client
+func RequestScheme(r *http.Request) string {
+	if pc := currentProxy(); pc.HonorProto && pc.TrustedPeer(r) {
+		switch proto := strings.ToLower(firstValue(r.Header.Get("X-Forwarded-Proto"))); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// RequestHost returns the host (with optional port) requested by the client
+func RequestHost(r *http.Request) string {
+	if pc := currentProxy(); pc.HonorHost && pc.TrustedPeer(r) {
+		if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// ClientIP returns IP address of the client. When the peer is a trusted proxy, X-Forwarded-For
+// is walked from the right, skipping trusted proxies, so clients can't spoof their address.
+func ClientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if pc := currentProxy(); pc.HonorFor && pc.trusted(ip) {
+		hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !pc.trusted(hop) {
+				break
+			}
+		}
+	}
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	return ip.String()
+}
+
+// remoteIP returns IP address of the immediate peer, or nil
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// firstValue returns the first item of comma separated header value
+func firstValue(value string) string {
+	if i := strings.Index(value, ","); i != -1 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,102 @@
+package gwp_core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+func setProxy(t *testing.T, trusted []string, headers []string) {
+	pc, err := NewProxyConfig(&gwp_context.AppConfig{TrustedProxies: trusted, ProxyHeaders: headers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetProxy(pc)
+}
+
+func proxyRequest(peer string, header http.Header) *http.Request {
+	r, _ := http.NewRequest("GET", "http://internal:8000/", nil)
+	r.RemoteAddr = peer
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	return r
+}
+
+func TestNewProxyConfig(t *testing.T) {
+	pc, err := NewProxyConfig(&gwp_context.AppConfig{})
+	if err != nil || len(pc.Trusted) != 0 || pc.HonorProto || pc.HonorHost || pc.HonorFor {
+		t.Errorf("expected empty config to trust nothing, got %+v, %v", pc, err)
+	}
+	pc, err = NewProxyConfig(&gwp_context.AppConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1", "::1"},
+		ProxyHeaders:   []string{"x-forwarded-for"},
+	})
+	if err != nil || len(pc.Trusted) != 3 || !pc.HonorFor || pc.HonorProto {
+		t.Errorf("unexpected config %+v, %v", pc, err)
+	}
+	if _, err := NewProxyConfig(&gwp_context.AppConfig{TrustedProxies: []string{"10.0.0"}}); err == nil {
+		t.Errorf("expected error for invalid trusted-proxies entry")
+	}
+	if _, err := NewProxyConfig(&gwp_context.AppConfig{ProxyHeaders: []string{"X-Real-IP"}}); err == nil {
+		t.Errorf("expected error for unsupported proxy-headers entry")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer SetProxy(new(ProxyConfig))
+
+	// empty config, forwarding headers are ignored
+	SetProxy(new(ProxyConfig))
+	r := proxyRequest("10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}})
+	if ip := ClientIP(r); ip != "10.0.0.1" {
+		t.Errorf("expected peer address with empty config, got %s", ip)
+	}
+
+	setProxy(t, []string{"10.0.0.0/8"}, []string{"X-Forwarded-For"})
+
+	// spoofed header from untrusted peer
+	r = proxyRequest("192.0.2.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}})
+	if ip := ClientIP(r); ip != "192.0.2.1" {
+		t.Errorf("expected untrusted peer address, got %s", ip)
+	}
+
+	// multi-hop chain, client prepended a spoofed address
+	r = proxyRequest("10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.7", "10.0.0.2"}})
+	if ip := ClientIP(r); ip != "198.51.100.7" {
+		t.Errorf("expected first untrusted hop from the right, got %s", ip)
+	}
+
+	// all hops trusted
+	r = proxyRequest("10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}})
+	if ip := ClientIP(r); ip != "10.0.0.3" {
+		t.Errorf("expected leftmost hop, got %s", ip)
+	}
+
+	// garbage stops the walk
+	r = proxyRequest("10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.7, junk"}})
+	if ip := ClientIP(r); ip != "10.0.0.1" {
+		t.Errorf("expected peer address for invalid hop, got %s", ip)
+	}
+}
+
+func TestRequestSchemeHost(t *testing.T) {
+	defer SetProxy(new(ProxyConfig))
+	header := http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"example.com, internal"}}
+
+	SetProxy(new(ProxyConfig))
+	r := proxyRequest("10.0.0.1:1234", header)
+	if s, h := RequestScheme(r), RequestHost(r); s != "http" || h != "internal:8000" {
+		t.Errorf("expected http://internal:8000 with empty config, got %s://%s", s, h)
+	}
+
+	setProxy(t, []string{"10.0.0.0/8"}, []string{"X-Forwarded-Proto", "X-Forwarded-Host"})
+	if s, h := RequestScheme(r), RequestHost(r); s != "https" || h != "example.com" {
+		t.Errorf("expected https://example.com, got %s://%s", s, h)
+	}
+	r = proxyRequest("192.0.2.1:1234", header)
+	if s, h := RequestScheme(r), RequestHost(r); s != "http" || h != "internal:8000" {
+		t.Errorf("expected headers of untrusted peer to be ignored, got %s://%s", s, h)
+	}
+}
@@ -0,0 +1,68 @@
+package gwp_core
+
+import (
+	"mime/multipart"
+	"net/http"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
+)
+
+// ----------------------------------------
+// Request helpers
+// ----------------------------------------
+
+type requestKey int
+
+// appContextKey is the gorilla context key CleanupMiddleware stores runtime Context under
+const appContextKey requestKey = 0
+
+// detachedKey is the gorilla context key TimeoutMiddleware stores a channel under, when it gives
+// up on a handler which still runs. The channel is closed once the handler returns.
+const detachedKey requestKey = 1
+
+// FormFiles parses multipart form (keeping up to configured max-multipart-memory bytes in memory,
+// the rest goes to temporary files) and returns all the files uploaded under the given field name.
+// It returns http.ErrMissingFile if there are no such files.
+// Temporary files are removed by CleanupMiddleware once the request is served.
+func FormFiles(ctx *gwp_context.Context, r *http.Request, field string) ([]*multipart.FileHeader, error) {
+	if err := r.ParseMultipartForm(ctx.Config().MaxMultipartMemory); err != nil {
+		return nil, err
+	}
+	files := r.MultipartForm.File[field]
+	if len(files) == 0 {
+		return nil, http.ErrMissingFile
+	}
+	return files, nil
+}
+
+// CleanupMiddleware wraps h, limiting the request body to configured max-body-size, and releasing
+// all the per-request resources after h returns: temporary files backing the multipart form are
+// removed and request values stored in gorilla context are cleared. If TimeoutMiddleware gave up
+// on h, the resources are released once h really returns. It also makes ctx available to Error, so it should be the outermost middleware.
+func CleanupMiddleware(ctx *gwp_context.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := ctx.Config().MaxBodySize; limit > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		context.DefaultContext.Set(r, appContextKey, ctx)
+		Metrics.Counter("gwp_requests_total").Inc()
+		defer func() {
+			release := func() {
+				if r.MultipartForm != nil {
+					r.MultipartForm.RemoveAll()
+				}
+				context.DefaultContext.Clear(r)
+			}
+			if finished, ok := context.DefaultContext.Get(r, detachedKey).(chan struct{}); ok {
+				go func() {
+					<-finished
+					release()
+				}()
+				return
+			}
+			release()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package gwp_core
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+// ----------------------------------------
+// HTTP server
+// ----------------------------------------
+
+// ListenAndServe creates the listener and http.Server configured by ctx.Config() and serves handler.
+// HTTP keep-alives are turned on or off with keep-alive setting, and accepted TCP connections
+// get keep-alive probes every keep-alive-period seconds (0 turns the probes off).
+func ListenAndServe(ctx *gwp_context.Context, handler http.Handler) error {
+	app := ctx.Config()
+	srv := &http.Server{Addr: app.ListenAddr, Handler: handler}
+	srv.SetKeepAlivesEnabled(app.KeepAlive)
+
+	ln, err := net.Listen("tcp", app.ListenAddr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(keepAliveListener{ln.(*net.TCPListener), app.KeepAlivePeriod})
+}
+
+// keepAliveListener sets TCP keep-alive options on accepted connections
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (ln keepAliveListener) Accept() (net.Conn, error) {
+	tc, err := ln.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	if ln.period > 0 {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(ln.period)
+	} else {
+		tc.SetKeepAlive(false)
+	}
+	return tc, nil
+}
@@ -0,0 +1,64 @@
+package gwp_core
+
+import (
+	"errors"
+	"sync"
+)
+
+// ----------------------------------------
+// Request coalescing
+// ----------------------------------------
+
+// ErrFlightAborted is returned to the callers waiting on a SingleFlight.Do call which panicked
+var ErrFlightAborted = errors.New("singleflight: call panicked")
+
+// flight is an in-progress or completed SingleFlight.Do call
+type flight struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// SingleFlight groups concurrent computations by key, so that an expensive
+// operation (eg. a database query feeding a template) is executed only once
+// while other callers asking for the same key wait for its result.
+// The zero value is ready to use.
+type SingleFlight struct {
+	mu sync.Mutex
+	m  map[string]*flight
+}
+
+// Do executes fn and returns its results, making sure that only one execution
+// is in flight for a given key at a time. If a duplicate call comes in, the
+// caller waits for the original one to complete and receives the same results.
+// If fn panics, waiting callers get ErrFlightAborted and the panic goes on in the caller executing fn.
+func (g *SingleFlight) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*flight)
+	}
+	if f, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		f.wg.Wait()
+		return f.val, f.err
+	}
+	f := new(flight)
+	f.wg.Add(1)
+	g.m[key] = f
+	g.mu.Unlock()
+
+	// make sure waiters are released even if fn panics, or doesn't return at all
+	f.err = ErrFlightAborted
+	defer func() {
+		p := recover()
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		f.wg.Done()
+		if p != nil {
+			panic(p)
+		}
+	}()
+	f.val, f.err = fn()
+	return f.val, f.err
+}
@@ -0,0 +1,93 @@
+package gwp_core
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlightDo(t *testing.T) {
+	var g SingleFlight
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	vals := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do("key", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			vals <- v
+		}()
+	}
+	// let the duplicate calls come in before the first one completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(vals)
+
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Errorf("expected fn to be executed once, got %d", c)
+	}
+	for v := range vals {
+		if v != "bar" {
+			t.Errorf("expected shared result bar, got %v", v)
+		}
+	}
+
+	// completed calls are forgotten
+	v, err := g.Do("key", func() (interface{}, error) { return "baz", nil })
+	if v != "baz" || err != nil {
+		t.Errorf("expected a new execution, got %v, %v", v, err)
+	}
+}
+
+func TestSingleFlightPanic(t *testing.T) {
+	var g SingleFlight
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	waiter := make(chan error)
+	go func() {
+		v, err := g.Do("key", func() (interface{}, error) { return "other", nil })
+		if v != nil {
+			t.Errorf("expected no value for waiter, got %v", v)
+		}
+		waiter <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if p := <-panicked; p != "boom" {
+		t.Errorf("expected panic to go on in the executing caller, got %v", p)
+	}
+	if err := <-waiter; err != ErrFlightAborted {
+		t.Errorf("expected ErrFlightAborted for waiter, got %v", err)
+	}
+
+	// the key is usable again
+	if v, err := g.Do("key", func() (interface{}, error) { return "ok", nil }); v != "ok" || err != nil {
+		t.Errorf("expected a new execution, got %v, %v", v, err)
+	}
+}
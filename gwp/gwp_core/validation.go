@@ -0,0 +1,100 @@
+package gwp_core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+// ----------------------------------------
+// Validation errors
+// ----------------------------------------
+
+// ValidationError holds field level messages about invalid request input,
+// keyed by field name (form field, or JSON property)
+type ValidationError struct {
+	Fields map[string]string
+}
+
+// NewValidationError creates an empty ValidationError
+func NewValidationError() *ValidationError {
+	return &ValidationError{Fields: make(map[string]string)}
+}
+
+// FieldErrors converts per field errors, such as the ones accumulated by a form decoder,
+// into ValidationError. It returns nil if there are no errors.
+func FieldErrors(errs map[string]error) *ValidationError {
+	if len(errs) == 0 {
+		return nil
+	}
+	ve := NewValidationError()
+	for field, err := range errs {
+		ve.Add(field, err.Error())
+	}
+	return ve
+}
+
+// Add sets the message for field, and returns ve so calls can be chained
+func (ve *ValidationError) Add(field, msg string) *ValidationError {
+	ve.Fields[field] = msg
+	return ve
+}
+
+// Len returns the number of invalid fields
+func (ve *ValidationError) Len() int {
+	return len(ve.Fields)
+}
+
+// Error lists the messages, ordered by field name
+func (ve *ValidationError) Error() string {
+	fields := make([]string, 0, len(ve.Fields))
+	for field := range ve.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for i, field := range fields {
+		fields[i] = field + ": " + ve.Fields[field]
+	}
+	return "invalid input: " + strings.Join(fields, "; ")
+}
+
+// ValidationPage is the data errors/422.html template is executed with
+type ValidationPage struct {
+	ErrorPage
+	Fields map[string]string
+}
+
+// RenderValidation responds with 422 status and the field messages of ve.
+// Clients accepting application/json get {"errors": {"field": "message", ...}}, others get
+// errors/422.html template executed with ValidationPage, falling back to plain text.
+func RenderValidation(w http.ResponseWriter, r *http.Request, ve *ValidationError) {
+	status := http.StatusUnprocessableEntity
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]map[string]string{"errors": ve.Fields})
+		return
+	}
+
+	page := &ValidationPage{
+		ErrorPage: ErrorPage{Status: status, StatusText: http.StatusText(status)},
+		Fields:    ve.Fields,
+	}
+	if ctx := requestContext(r); ctx != nil {
+		if out, err := gwp_template.Execute(ctx, r, "errors/422.html", page); err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write(out)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%d %s\n", status, page.StatusText)
+	fmt.Fprintln(w, ve.Error())
+}
@@ -0,0 +1,71 @@
+package gwp_core
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+func serveValidation(ctx *gwp_context.Context, accept string, ve *ValidationError) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", nil)
+	r.Header.Set("Accept", accept)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RenderValidation(w, r, ve)
+	})
+	CleanupMiddleware(ctx, h).ServeHTTP(w, r)
+	return w
+}
+
+func TestRenderValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_validation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "errors"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "errors", "422.html"),
+		[]byte("{{.Status}}{{range $f, $m := .Fields}} {{$f}}={{$m}}{{end}}"), 0644)
+	ctx := newTestContext(dir)
+
+	ve := FieldErrors(map[string]error{"Age": errors.New("must be a number")})
+	ve.Add("Name", "is required")
+
+	// JSON
+	w := serveValidation(ctx, "application/json", ve)
+	if w.Code != 422 || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("Expected 422 JSON, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct{ Errors map[string]string }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", w.Body.String(), err)
+	}
+	if len(body.Errors) != 2 || body.Errors["Age"] != "must be a number" || body.Errors["Name"] != "is required" {
+		t.Errorf("Unexpected JSON errors: %v", body.Errors)
+	}
+
+	// HTML
+	w = serveValidation(ctx, "text/html", ve)
+	if w.Code != 422 || w.Body.String() != "422 Age=must be a number Name=is required" {
+		t.Errorf("Expected 422 page, got %d %q", w.Code, w.Body.String())
+	}
+
+	// plain text when there's no template
+	os.Remove(filepath.Join(dir, "errors", "422.html"))
+	w = serveValidation(ctx, "text/html", ve)
+	want := "422 Unprocessable Entity\ninvalid input: Age: must be a number; Name: is required\n"
+	if w.Code != 422 || w.Body.String() != want {
+		t.Errorf("Expected plain text 422, got %d %q", w.Code, w.Body.String())
+	}
+
+	if FieldErrors(nil) != nil {
+		t.Errorf("Expected nil ValidationError without errors")
+	}
+}
@@ -0,0 +1,48 @@
+package gwp_core
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_template"
+)
+
+// ----------------------------------------
+// Build info
+// ----------------------------------------
+
+func init() {
+	gwp_template.AddRequestFunc("version", func(r *http.Request) interface{} {
+		return func() string {
+			if r == nil {
+				return ""
+			}
+			if ctx := requestContext(r); ctx != nil && ctx.Build != nil {
+				return ctx.Build.Version
+			}
+			return ""
+		}
+	})
+}
+
+// SetBuildInfo stores version info of the running build on the Context.
+// Values are usually injected at build time, eg. with -ldflags "-X main.version=1.2.0".
+// Version is available to templates rendered with gwp_template.Render as {{version}}.
+func SetBuildInfo(ctx *gwp_context.Context, version, commit, buildTime string) {
+	ctx.Build = &gwp_context.BuildInfo{Version: version, Commit: commit, BuildTime: buildTime}
+}
+
+// VersionHandler returns a handler which responds with build info as JSON.
+// It is registered at configured version-path, if set.
+func VersionHandler(ctx *gwp_context.Context) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := ctx.Build
+		if info == nil {
+			info = new(gwp_context.BuildInfo)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(info)
+	}
+}
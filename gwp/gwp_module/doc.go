@@ -14,5 +14,8 @@ mod_example provided, ilustrates this.
 
 * a module can spawn goroutines for background tasks that are not request based.
 
+* a module can implement ConfigReloader to pick up changed parameters when the config file
+is reloaded (the server does it on SIGHUP, see ReloadConfig).
+
 */
 package gwp_module
@@ -2,6 +2,7 @@ package gwp_module
 
 import (
 	"net/http"
+	"sync"
 	"github.com/scyth/go-webproject/gwp/gwp_context"
 	"github.com/scyth/go-webproject/gwp/gwp_core"
 )
@@ -17,6 +18,19 @@ type Module interface {
 }
 
 
+// ConfigReloader is implemented by modules which want to be told about config reload
+// (see ReloadConfig). OnConfigReload gets the re-parsed module parameters, so a module can
+// swap its settings. Modules not implementing it are unaffected by reload.
+type ConfigReloader interface {
+	OnConfigReload(params gwp_context.ModParams)
+}
+
+// registered holds modules registered with RegisterModule, for ReloadConfig
+var (
+	registeredMu sync.Mutex
+	registered   []Module
+)
+
 // ModContext is passed back to module after registration
 type ModContext struct {
 	Name    string                 // module name
@@ -39,17 +53,79 @@ func RegisterModule(ctx *gwp_context.Context, m Module) {
 		}
 	}
 	m.ModInit(modctx, nil)
+
+	registeredMu.Lock()
+	registered = append(registered, m)
+	registeredMu.Unlock()
+}
+
+// ReloadConfig parses the configuration file again. [default], [project] and [features] settings
+// replace ctx.Config() (listen address, mux and paths still need a restart to change), and every registered
+// module gets its parameters re-parsed and saved. Modules implementing ConfigReloader are then
+// notified. Nothing is changed if the file has errors.
+func ReloadConfig(ctx *gwp_context.Context) error {
+	app, err := gwp_core.ParseConfig(ctx.ConfigFile)
+	if err != nil {
+		return err
+	}
+	proxy, err := gwp_core.NewProxyConfig(app)
+	if err != nil {
+		return err
+	}
+
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+
+	// parse into copies first, so a bad module section doesn't leave a half applied config
+	params := make([]gwp_context.ModParams, len(registered))
+	for i, m := range registered {
+		if m.GetParams() == nil {
+			continue
+		}
+		params[i] = copyParams(*m.GetParams())
+		if err := gwp_core.ParseConfigParams(ctx.ConfigFile, m.GetName(), &params[i]); err != nil {
+			return err
+		}
+	}
+
+	ctx.SetConfig(app)
+	gwp_core.SetProxy(proxy)
+	gwp_core.SetFeatures(app.Features)
+	for i, m := range registered {
+		if params[i] == nil {
+			continue
+		}
+		m.SaveParams(params[i])
+		if r, ok := m.(ConfigReloader); ok {
+			r.OnConfigReload(params[i])
+		}
+	}
+	return nil
+}
+
+// copyParams returns a deep copy of params
+func copyParams(params gwp_context.ModParams) gwp_context.ModParams {
+	cp := make(gwp_context.ModParams, len(params))
+	for i, p := range params {
+		if p != nil {
+			q := *p
+			cp[i] = &q
+		}
+	}
+	return cp
 }
 
 // RegisterHandler can be called to register handlers directly from modules.
-// It takes standard http's(or mux's) pattern and a HandlerFunc as arguments, 
-// along with a pointer to the global Context.
-func RegisterHandler(ctx *gwp_context.Context, pattern string, 
+// It takes a pattern of the active router (gorilla-mux or http's) and a HandlerFunc
+// as arguments, along with a pointer to the global Context.
+func RegisterHandler(ctx *gwp_context.Context, pattern string,
 	handler func(http.ResponseWriter, *http.Request)) {
-	
-	if ctx.App.Mux == "gorilla" {
-		ctx.Router.HandleFunc(pattern, handler)
-	} else {
-		http.HandleFunc(pattern, handler)
-	}
+	Routes(ctx).Handle(pattern, http.HandlerFunc(handler))
+}
+
+// RegisterHandlerMethods is like RegisterHandler, but the handler serves only requests
+// with the given HTTP methods (eg. "GET", "POST").
+func RegisterHandlerMethods(ctx *gwp_context.Context, pattern string,
+	handler func(http.ResponseWriter, *http.Request), methods ...string) {
+	Routes(ctx).HandleMethods(pattern, http.HandlerFunc(handler), methods...)
 }
@@ -0,0 +1,138 @@
+package gwp_module
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_core"
+)
+
+// reloadModule records the params it's notified with
+type reloadModule struct {
+	ModCtx   *ModContext
+	reloaded gwp_context.ModParams
+}
+
+var reloadParams = &gwp_context.ModParams{
+	&gwp_context.ModParam{Name: "limit", Value: 0, Default: 1, Type: gwp_context.TypeInt},
+}
+
+func (m *reloadModule) ModInit(modCtx *ModContext, err error) {
+	if err == nil {
+		m.ModCtx = modCtx
+	}
+}
+func (m *reloadModule) GetName() string                         { return "mod_reload" }
+func (m *reloadModule) GetParams() *gwp_context.ModParams       { return reloadParams }
+func (m *reloadModule) SaveParams(params gwp_context.ModParams) { m.ModCtx.Params = &params }
+func (m *reloadModule) OnConfigReload(params gwp_context.ModParams) {
+	m.reloaded = params
+}
+
+func writeConfig(t *testing.T, path, root string, limit int) {
+	conf := fmt.Sprintf("[default]\n\n[project]\nroot = %s\ntmpDir = %s\n\n[mod_reload]\nlimit = %d\n", root, root, limit)
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "server.conf")
+	writeConfig(t, path, dir, 5)
+
+	ctx := gwp_context.NewContext()
+	ctx.ConfigFile = path
+	m := new(reloadModule)
+	RegisterModule(ctx, m)
+	if v := (*m.ModCtx.Params)[0].Value; v != 5 {
+		t.Fatalf("expected limit 5, got %v", v)
+	}
+
+	// requests keep reading the config while it's reloaded
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = ctx.Config().ProjectRoot
+			}
+		}
+	}()
+
+	writeConfig(t, path, dir, 10)
+	if err := ReloadConfig(ctx); err != nil {
+		t.Fatalf("error reloading config: %v", err)
+	}
+	if m.reloaded == nil || m.reloaded[0].Value != 10 {
+		t.Fatalf("expected module to observe limit 10, got %v", m.reloaded)
+	}
+	if v := (*m.ModCtx.Params)[0].Value; v != 10 {
+		t.Errorf("expected saved limit 10, got %v", v)
+	}
+	if v := (*reloadParams)[0].Value; v != 5 {
+		t.Errorf("expected params seen before reload to stay unchanged, got %v", v)
+	}
+
+	// broken config is not applied
+	broken := "[project]\nroot = " + filepath.Join(dir, "missing") + "\n"
+	if err := ioutil.WriteFile(path, []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := ctx.Config()
+	if err := ReloadConfig(ctx); err == nil {
+		t.Errorf("expected error reloading config with missing root directory")
+	}
+	if ctx.Config() != app || m.reloaded[0].Value != 10 {
+		t.Errorf("expected config to stay unchanged after failed reload")
+	}
+}
+
+func TestReloadFeatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "templates"), 0755)
+	path := filepath.Join(dir, "server.conf")
+	write := func(flag string) {
+		conf := fmt.Sprintf("[project]\nroot = %s\ntmpDir = %s\n\n[features]\nbeta = %s\n", dir, dir, flag)
+		if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer gwp_core.SetFeatures(nil)
+
+	ctx := gwp_context.NewContext()
+	ctx.ConfigFile = path
+	write("off")
+	if err := ReloadConfig(ctx); err != nil {
+		t.Fatalf("error reloading config: %v", err)
+	}
+	if v, _ := gwp_core.Feature("beta"); v != "off" {
+		t.Fatalf("expected beta=off, got %q", v)
+	}
+
+	write("on")
+	if err := ReloadConfig(ctx); err != nil {
+		t.Fatalf("error reloading config: %v", err)
+	}
+	if v, _ := gwp_core.Feature("beta"); v != "on" {
+		t.Errorf("expected reload to pick up beta=on, got %q", v)
+	}
+}
@@ -0,0 +1,185 @@
+package gwp_module
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/mux"
+)
+
+// ----------------------------------------
+// Routers
+// ----------------------------------------
+
+// Routes returns the active router of ctx. If ctx.Routes is not set yet, it's created
+// for the configured mux: ctx.Router with gorilla-mux (created if not set), http.DefaultServeMux
+// otherwise.
+func Routes(ctx *gwp_context.Context) gwp_context.Router {
+	if ctx.Routes == nil {
+		if ctx.Config().Mux == "gorilla" {
+			if ctx.Router == nil {
+				ctx.Router = new(mux.Router)
+				ctx.Router.StrictSlash(true)
+			}
+			ctx.Routes = NewGorillaRouter(ctx.Router)
+		} else {
+			ctx.Routes = NewServeMuxRouter(http.DefaultServeMux)
+		}
+	}
+	return ctx.Routes
+}
+
+// gorillaRouter is gwp_context.Router backed by gorilla mux. Patterns are gorilla path templates.
+type gorillaRouter struct {
+	r      *mux.Router
+	mu     sync.RWMutex
+	routes map[string]*mux.Route
+}
+
+// NewGorillaRouter returns gwp_context.Router registering handlers with r
+func NewGorillaRouter(r *mux.Router) gwp_context.Router {
+	return &gorillaRouter{r: r, routes: make(map[string]*mux.Route)}
+}
+
+func (g *gorillaRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.r.ServeHTTP(w, r)
+}
+
+func (g *gorillaRouter) Handle(pattern string, handler http.Handler) {
+	g.add(pattern, g.r.Handle(pattern, handler))
+}
+
+func (g *gorillaRouter) HandleMethods(pattern string, handler http.Handler, methods ...string) {
+	g.add(pattern, g.r.Handle(pattern, handler).Methods(methods...))
+}
+
+func (g *gorillaRouter) NotFound(handler http.Handler) {
+	g.r.NotFoundHandler = handler
+}
+
+// URL fills pattern variables from pairs of name and value
+func (g *gorillaRouter) URL(pattern string, pairs ...string) (string, error) {
+	g.mu.RLock()
+	route := g.routes[pattern]
+	g.mu.RUnlock()
+	if route == nil {
+		return "", errors.New("gwp_module: no route registered for " + pattern)
+	}
+	u, err := route.URL(pairs...)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (g *gorillaRouter) add(pattern string, route *mux.Route) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.routes[pattern] == nil {
+		g.routes[pattern] = route
+	}
+}
+
+// serveMuxRouter is gwp_context.Router backed by http.ServeMux. Patterns are ServeMux patterns.
+type serveMuxRouter struct {
+	mux      *http.ServeMux
+	mu       sync.RWMutex
+	routes   map[string]*methodHandler
+	notFound http.Handler
+}
+
+// NewServeMuxRouter returns gwp_context.Router registering handlers with m
+func NewServeMuxRouter(m *http.ServeMux) gwp_context.Router {
+	return &serveMuxRouter{mux: m, routes: make(map[string]*methodHandler)}
+}
+
+func (s *serveMuxRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, pattern := s.mux.Handler(r)
+	s.mu.RLock()
+	notFound := s.notFound
+	s.mu.RUnlock()
+	if pattern == "" && notFound != nil {
+		h = notFound
+	}
+	h.ServeHTTP(w, r)
+}
+
+func (s *serveMuxRouter) Handle(pattern string, handler http.Handler) {
+	s.HandleMethods(pattern, handler)
+}
+
+// HandleMethods can be called for the same pattern many times, with different methods.
+// Requests with other methods get 405 Method Not Allowed.
+func (s *serveMuxRouter) HandleMethods(pattern string, handler http.Handler, methods ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mh := s.routes[pattern]
+	if mh == nil {
+		mh = &methodHandler{methods: make(map[string]http.Handler)}
+		s.routes[pattern] = mh
+		s.mux.Handle(pattern, mh)
+	}
+	mh.set(handler, methods)
+}
+
+func (s *serveMuxRouter) NotFound(handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notFound = handler
+}
+
+// URL returns pattern itself, ServeMux patterns have no variables
+func (s *serveMuxRouter) URL(pattern string, pairs ...string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.routes[pattern] == nil {
+		return "", errors.New("gwp_module: no route registered for " + pattern)
+	}
+	if len(pairs) > 0 {
+		return "", errors.New("gwp_module: route " + pattern + " has no variables")
+	}
+	return pattern, nil
+}
+
+// methodHandler dispatches requests for a single ServeMux pattern by method
+type methodHandler struct {
+	mu      sync.RWMutex
+	any     http.Handler
+	methods map[string]http.Handler
+}
+
+func (mh *methodHandler) set(handler http.Handler, methods []string) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	if len(methods) == 0 {
+		mh.any = handler
+		return
+	}
+	for _, m := range methods {
+		mh.methods[strings.ToUpper(m)] = handler
+	}
+}
+
+func (mh *methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mh.mu.RLock()
+	h := mh.methods[r.Method]
+	if h == nil {
+		h = mh.any
+	}
+	var allow []string
+	if h == nil {
+		for m := range mh.methods {
+			allow = append(allow, m)
+		}
+	}
+	mh.mu.RUnlock()
+	if h == nil {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
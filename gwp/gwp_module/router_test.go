@@ -0,0 +1,113 @@
+package gwp_module
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/mux"
+)
+
+func text(s string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, s)
+	})
+}
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, "http://localhost"+path, nil)
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func testRouter(t *testing.T, name string, rt gwp_context.Router, itemPattern string) {
+	rt.Handle("/about", text("about"))
+	rt.HandleMethods(itemPattern, text("get"), "GET")
+	rt.HandleMethods(itemPattern, text("post"), "POST")
+	rt.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "missing")
+	}))
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/about", 200, "about"},
+		{"GET", "/items", 200, "get"},
+		{"POST", "/items", 200, "post"},
+		{"GET", "/nowhere", 404, "missing"},
+	}
+	for _, test := range tests {
+		w := serve(rt, test.method, test.path)
+		if w.Code != test.code || w.Body.String() != test.body {
+			t.Errorf("%s: %s %s: expected %d %q, got %d %q", name, test.method, test.path,
+				test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	if u, err := rt.URL("/about"); err != nil || u != "/about" {
+		t.Errorf("%s: expected /about, got %q, %v", name, u, err)
+	}
+	if _, err := rt.URL("/unknown"); err == nil {
+		t.Errorf("%s: expected error for unregistered pattern", name)
+	}
+}
+
+func TestServeMuxRouter(t *testing.T) {
+	rt := NewServeMuxRouter(http.NewServeMux())
+	testRouter(t, "ServeMux", rt, "/items")
+
+	if w := serve(rt, "DELETE", "/items"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for DELETE, got %d", w.Code)
+	}
+	if _, err := rt.URL("/about", "id", "1"); err == nil {
+		t.Errorf("expected error for variables in ServeMux pattern")
+	}
+}
+
+func TestGorillaRouter(t *testing.T) {
+	rt := NewGorillaRouter(new(mux.Router))
+	testRouter(t, "gorilla", rt, "/items")
+
+	rt.Handle("/items/{id:[0-9]+}", text("item"))
+	if w := serve(rt, "GET", "/items/7"); w.Body.String() != "item" {
+		t.Errorf("expected item, got %q", w.Body.String())
+	}
+	if u, err := rt.URL("/items/{id:[0-9]+}", "id", "7"); err != nil || u != "/items/7" {
+		t.Errorf("expected /items/7, got %q, %v", u, err)
+	}
+}
+
+func TestRegisterHandlerUsesRoutes(t *testing.T) {
+	ctx := gwp_context.NewContext()
+	ctx.Config().Mux = "gorilla"
+	ctx.Router = new(mux.Router)
+	RegisterHandler(ctx, "/hello", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	if w := serve(ctx.Router, "GET", "/hello"); w.Body.String() != "hello" {
+		t.Errorf("expected handler registered with gorilla router, got %q", w.Body.String())
+	}
+}
+
+func TestRoutesCreatesGorillaRouter(t *testing.T) {
+	ctx := gwp_context.NewContext()
+	ctx.Config().Mux = "gorilla"
+	RegisterHandler(ctx, "/login", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "login")
+	})
+	if ctx.Router == nil {
+		t.Fatal("expected gorilla router to be created")
+	}
+	if w := serve(ctx.Router, "GET", "/login/"); w.Code != http.StatusMovedPermanently {
+		t.Errorf("expected redirect to /login with strict slash, got %d", w.Code)
+	}
+	if u, err := Routes(ctx).URL("/login"); err != nil || u != "/login" {
+		t.Errorf("expected /login, got %q, %v", u, err)
+	}
+}
@@ -1,4 +1,10 @@
 /*
 Package gwp_template gives API for loading template files.
+
+Templates are parsed with html/template by default. Other engines can be plugged in
+per file extension with RegisterEngine.
+
+Parsed templates are cached. With live-templates on, changed files are dropped from the
+cache automatically; Invalidate and InvalidateAll do it by hand.
 */
 package gwp_template
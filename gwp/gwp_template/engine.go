@@ -0,0 +1,65 @@
+package gwp_template
+
+import (
+	"html/template"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+// ----------------------------------------
+// Template engines
+// ----------------------------------------
+
+// Renderer is a parsed template, ready to be executed.
+// It's the same type as gwp_context.Renderer, which the template cache holds.
+type Renderer = gwp_context.Renderer
+
+// TemplateEngine parses template sources into Renderers.
+// Engines are registered per file extension with RegisterEngine.
+type TemplateEngine interface {
+	Parse(name, src string) (Renderer, error)
+}
+
+var (
+	enginesMu sync.RWMutex
+	engines   = map[string]TemplateEngine{".html": GoEngine}
+)
+
+// GoEngine is the html/template engine. It's used for .html files, and for files
+// with extensions no engine is registered for. Functions added with AddFunc and
+// AddRequestFunc are available to its templates.
+var GoEngine TemplateEngine = goEngine{}
+
+// RegisterEngine sets the engine used by Load for template files with extension ext (eg. ".soy").
+// It is meant to be called at initialization time.
+func RegisterEngine(ext string, e TemplateEngine) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	engines[strings.ToLower(ext)] = e
+}
+
+// engineFor returns the engine registered for extension of the named file
+func engineFor(name string) TemplateEngine {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	if e, ok := engines[strings.ToLower(filepath.Ext(name))]; ok {
+		return e
+	}
+	return GoEngine
+}
+
+// goEngine parses html/template templates
+type goEngine struct{}
+
+func (goEngine) Parse(name, src string) (Renderer, error) {
+	funcsMu.RLock()
+	defer funcsMu.RUnlock()
+	tpl, err := template.New(filepath.Base(name)).Funcs(funcs).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
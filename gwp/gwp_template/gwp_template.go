@@ -1,23 +1,204 @@
 package gwp_template
 
 import (
+	"bytes"
+	"errors"
 	"html/template"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
 	"github.com/scyth/go-webproject/gwp/gwp_context"
 )
 
+var (
+	funcsMu      sync.RWMutex
+	funcs        = template.FuncMap{}
+	requestFuncs = make(map[string]func(*http.Request) interface{})
+)
+
+// AddFunc registers a template function which will be available to all the templates
+// loaded afterwards. It is meant to be called by modules at initialization time.
+func AddFunc(name string, fn interface{}) {
+	funcsMu.Lock()
+	defer funcsMu.Unlock()
+	funcs[name] = fn
+}
+
+// AddRequestFunc registers a template function which is bound to the current request.
+// For every Render call, bind is called with the request and must return the function to be
+// used by the template. At parse time bind is called with nil request, so it must handle that.
+func AddRequestFunc(name string, bind func(*http.Request) interface{}) {
+	funcsMu.Lock()
+	defer funcsMu.Unlock()
+	requestFuncs[name] = bind
+	funcs[name] = bind(nil)
+}
+
 // Load is API call which will return parsed template object, and will do this fast.
-// It is also thread safe
+// It is also thread safe, and concurrent first loads don't wait for each other.
+// Template must be parsed by GoEngine, use LoadRenderer for templates of other engines.
+// The returned template is a copy of the cached one, so it can be executed directly.
 func Load(ctx *gwp_context.Context, name string) (tpl *template.Template, err error) {
-	if ctx.Templates[ctx.App.TemplatePath+name] != nil {
-		return ctx.Templates[ctx.App.TemplatePath+name], nil
+	r, err := LoadRenderer(ctx, name)
+	if err != nil {
+		return nil, err
 	}
+	tpl, ok := r.(*template.Template)
+	if !ok {
+		return nil, errors.New("gwp_template: " + name + " is not a html/template template")
+	}
+	return tpl, nil
+}
 
-	tpl, err = template.ParseFiles(ctx.App.TemplatePath + name)
+// LoadRenderer is like Load, but parses the file with the engine registered for its
+// extension (see RegisterEngine). Parsed templates are cached the same way for all engines.
+// GoEngine templates are copied like with Load.
+func LoadRenderer(ctx *gwp_context.Context, name string) (Renderer, error) {
+	r, err := load(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	pt := &gwp_context.ParsedTemplate{ctx.App.TemplatePath + name, tpl}
+	// html/template can't be cloned once executed, so the cached one never is
+	if tpl, ok := r.(*template.Template); ok {
+		return tpl.Clone()
+	}
+	return r, nil
+}
 
-	ctx.LiveTplMsg <- pt
-	return tpl, nil
+// load returns the cached template, parsing it first if needed
+func load(ctx *gwp_context.Context, name string) (Renderer, error) {
+	path := ctx.Config().TemplatePath + name
+	ctx.TemplatesMu.RLock()
+	r := ctx.Templates[path]
+	gen := ctx.TemplatesGen
+	ctx.TemplatesMu.RUnlock()
+	if r != nil {
+		return r, nil
+	}
+
+	r, err := parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pt := &gwp_context.ParsedTemplate{Name: path, Tpl: r, Gen: gen}
+
+	// hand it over for caching, but never wait on a busy watcher.
+	// If the buffer is full, template is parsed again on next Load.
+	select {
+	case ctx.LiveTplMsg <- pt:
+	default:
+	}
+	return r, nil
+}
+
+// Invalidate removes the named template from the cache, so the next Load parses it again.
+// The file stops being watched until then. It's useful where file change events can't be
+// relied on, eg. on network filesystems. Templates parsed before the call and still waiting
+// for the watcher are not cached.
+func Invalidate(ctx *gwp_context.Context, name string) {
+	path := ctx.Config().TemplatePath + name
+	ctx.TemplatesMu.Lock()
+	delete(ctx.Templates, path)
+	ctx.TemplatesGen++
+	ctx.TemplatesMu.Unlock()
+	unwatch(ctx, path)
+}
+
+// InvalidateAll removes all the templates from the cache, see Invalidate.
+func InvalidateAll(ctx *gwp_context.Context) {
+	ctx.TemplatesMu.Lock()
+	ctx.Templates = make(map[string]gwp_context.Renderer)
+	ctx.TemplatesGen++
+	ctx.TemplatesMu.Unlock()
+	unwatch(ctx, "")
+}
+
+// unwatch tells the template watcher to stop watching the file, or all files if name is empty.
+// The message is never dropped, so a busy watcher is waited on once the buffer is full.
+func unwatch(ctx *gwp_context.Context, name string) {
+	ctx.InvalidTplMsg <- name
+}
+
+// Render loads the named template, executes it with data and writes the result to w.
+// Request bound template functions (see AddRequestFunc) are resolved for r.
+// Nothing is written if the template fails to execute.
+func Render(ctx *gwp_context.Context, w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	out, err := Execute(ctx, r, name, data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// Execute is like Render, but returns the output instead of writing it.
+// It's useful when response headers or status depend on the template being rendered successfully.
+// Output is minified if turned on with SetMinify.
+func Execute(ctx *gwp_context.Context, r *http.Request, name string, data interface{}) ([]byte, error) {
+	tpl, err := load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if gotpl, ok := tpl.(*template.Template); ok {
+		if tpl, err = bindRequest(gotpl, r); err != nil {
+			return nil, err
+		}
+	}
+	buff := new(bytes.Buffer)
+	if err = tpl.Execute(buff, data); err != nil {
+		return nil, err
+	}
+	if minifyEnabled() {
+		return minify(buff.Bytes()), nil
+	}
+	return buff.Bytes(), nil
+}
+
+// ParseError is returned by Load when a template file fails to parse
+type ParseError struct {
+	File string // template file path
+	Line int    // line of the error, 0 if unknown
+	Err  error  // error returned by the template engine
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+// errLine finds line number in html/template errors, like "template: index.html:12: ..."
+var errLine = regexp.MustCompile(`^template: [^:]*:(\d+):`)
+
+// parseFile parses template file with the engine registered for its extension
+func parseFile(filename string) (Renderer, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := engineFor(filename).Parse(filename, string(src))
+	if err != nil {
+		pe := &ParseError{File: filename, Err: err}
+		if m := errLine.FindStringSubmatch(err.Error()); m != nil {
+			pe.Line, _ = strconv.Atoi(m[1])
+		}
+		return nil, pe
+	}
+	return r, nil
+}
+
+// bindRequest returns a copy of tpl with request functions bound to r.
+// The cached template is never executed, see LoadRenderer, so it can always be cloned.
+func bindRequest(tpl *template.Template, r *http.Request) (*template.Template, error) {
+	funcsMu.RLock()
+	defer funcsMu.RUnlock()
+	bound := template.FuncMap{}
+	for fname, bind := range requestFuncs {
+		bound[fname] = bind(r)
+	}
+	clone, err := tpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return clone.Funcs(bound), nil
 }
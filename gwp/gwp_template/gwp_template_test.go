@@ -0,0 +1,252 @@
+package gwp_template
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+)
+
+func TestConcurrentFirstLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// more templates than the channel can buffer, and nobody receiving: a busy watcher
+	n := 2 * gwp_context.LiveTplBuffer
+	for i := 0; i < n; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("t%d.html", i))
+		if err := ioutil.WriteFile(name, []byte(fmt.Sprintf("tpl %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := gwp_context.NewContext()
+	ctx.Config().TemplatePath = dir + "/"
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := Load(ctx, fmt.Sprintf("t%d.html", i)); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("concurrent Load calls blocked on LiveTplMsg")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(ctx.LiveTplMsg) != gwp_context.LiveTplBuffer {
+		t.Errorf("expected %d queued templates, got %d", gwp_context.LiveTplBuffer, len(ctx.LiveTplMsg))
+	}
+}
+
+func TestMinify(t *testing.T) {
+	src := `<html>
+	<!-- comment -->
+	<!--[if IE]><p>ie</p><![endif]-->
+	<body   class="a  b">
+		<p>Hello,   <b>world</b> <i>!</i></p>
+		<pre>  keep
+   this  </pre>
+		<SCRIPT>var s = "a  <!-- b -->  c";
+		if (a < b) {}</script>
+		<textarea name="t">  x  </textarea>
+	</body>
+</html>
+`
+	want := `<html>
+<!--[if IE]><p>ie</p><![endif]-->
+<body   class="a  b">
+<p>Hello, <b>world</b> <i>!</i></p>
+<pre>  keep
+   this  </pre>
+<SCRIPT>var s = "a  <!-- b -->  c";
+		if (a < b) {}</script>
+<textarea name="t">  x  </textarea>
+</body>
+</html>
+`
+	if got := string(minify([]byte(src))); got != want {
+		t.Errorf("minify:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// upperEngine is a test engine rendering its source upper cased
+type upperEngine struct{}
+
+type upperRenderer string
+
+func (r upperRenderer) Execute(w io.Writer, data interface{}) error {
+	_, err := io.WriteString(w, strings.ToUpper(string(r)))
+	return err
+}
+
+func (upperEngine) Parse(name, src string) (Renderer, error) {
+	return upperRenderer(src), nil
+}
+
+func TestRegisterEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.upper"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterEngine(".upper", upperEngine{})
+	ctx := gwp_context.NewContext()
+	ctx.Config().TemplatePath = dir + "/"
+
+	out, err := Execute(ctx, nil, "a.upper", nil)
+	if err != nil || string(out) != "HELLO" {
+		t.Errorf("expected HELLO, got %q, %v", out, err)
+	}
+	if pt := <-ctx.LiveTplMsg; pt.Tpl != upperRenderer("hello") {
+		t.Errorf("expected parsed template to be sent for caching, got %v", pt.Tpl)
+	}
+	if _, err := Load(ctx, "a.upper"); err == nil {
+		t.Errorf("expected Load to refuse non html/template template")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.html", "b.html"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := gwp_context.NewContext()
+	ctx.Config().TemplatePath = dir + "/"
+
+	// cache both templates, as the watcher would, then change them on disk
+	for _, name := range []string{"a.html", "b.html"} {
+		if _, err := Load(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+		pt := <-ctx.LiveTplMsg
+		ctx.Templates[pt.Name] = pt.Tpl
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	render := func(name string) string {
+		out, err := Execute(ctx, nil, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+	if got := render("a.html"); got != "old" {
+		t.Fatalf("expected cached template, got %q", got)
+	}
+
+	Invalidate(ctx, "a.html")
+	if got := render("a.html"); got != "new" {
+		t.Errorf("expected a.html to be parsed again, got %q", got)
+	}
+	if got := render("b.html"); got != "old" {
+		t.Errorf("expected b.html to stay cached, got %q", got)
+	}
+	if name := <-ctx.InvalidTplMsg; name != dir+"/a.html" {
+		t.Errorf("expected watcher to be told about a.html, got %q", name)
+	}
+
+	InvalidateAll(ctx)
+	if got := render("b.html"); got != "new" {
+		t.Errorf("expected b.html to be parsed again, got %q", got)
+	}
+	if name := <-ctx.InvalidTplMsg; name != "" {
+		t.Errorf("expected watcher to be told about all templates, got %q", name)
+	}
+
+	// a busy watcher is waited on, the message isn't dropped
+	for i := 0; i < gwp_context.LiveTplBuffer; i++ {
+		ctx.InvalidTplMsg <- "busy"
+	}
+	done := make(chan struct{})
+	go func() {
+		Invalidate(ctx, "b.html")
+		close(done)
+	}()
+	for i := 0; i < gwp_context.LiveTplBuffer; i++ {
+		<-ctx.InvalidTplMsg
+	}
+	<-done
+	if name := <-ctx.InvalidTplMsg; name != dir+"/b.html" {
+		t.Errorf("expected watcher to be told about b.html, got %q", name)
+	}
+}
+
+func TestLoadReturnsCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp_template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "page.html"), []byte(`{{reqPath}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	AddRequestFunc("reqPath", func(r *http.Request) interface{} {
+		return func() string {
+			if r == nil {
+				return ""
+			}
+			return r.URL.Path
+		}
+	})
+	ctx := gwp_context.NewContext()
+	ctx.Config().TemplatePath = dir + "/"
+	if _, err := Load(ctx, "page.html"); err != nil {
+		t.Fatal(err)
+	}
+	pt := <-ctx.LiveTplMsg
+	ctx.Templates[pt.Name] = pt.Tpl
+
+	// executing the loaded template leaves the cached one clonable
+	tpl, err := Load(ctx, "page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl == pt.Tpl {
+		t.Fatal("expected a copy of the cached template")
+	}
+	if err := tpl.Execute(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "page.html"))
+	r, _ := http.NewRequest("GET", "/path", nil)
+	if out, err := Execute(ctx, r, "page.html", nil); err != nil || string(out) != "/path" {
+		t.Errorf("expected cached template bound to the request, got %q, %v", out, err)
+	}
+}
@@ -0,0 +1,135 @@
+package gwp_template
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// ----------------------------------------
+// HTML minification
+// ----------------------------------------
+
+var minifyOn int32
+
+// rawTags are elements whose content is copied as is
+var rawTags = []string{"pre", "textarea", "script", "style"}
+
+// SetMinify turns minification of rendered output on or off. It's off by default.
+// When on, Render and Execute strip HTML comments (except conditional comments) and
+// collapse runs of whitespace between and around tags. Tags themselves, and content of
+// pre, textarea, script and style elements, are left intact.
+func SetMinify(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&minifyOn, v)
+}
+
+// minifyEnabled checks if SetMinify is on
+func minifyEnabled() bool {
+	return atomic.LoadInt32(&minifyOn) == 1
+}
+
+// minify returns src HTML with comments removed and whitespace collapsed.
+// A whitespace run becomes a single newline if it contains one, or a single space otherwise,
+// so the page renders the same.
+func minify(src []byte) []byte {
+	out := bytes.NewBuffer(make([]byte, 0, len(src)))
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '<' && bytes.HasPrefix(src[i:], []byte("<!--")):
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end == -1 {
+				out.Write(src[i:])
+				return out.Bytes()
+			}
+			end += i + 7
+			if bytes.HasPrefix(src[i:], []byte("<!--[if")) || bytes.HasPrefix(src[i:], []byte("<!--<![endif]")) {
+				out.Write(src[i:end])
+			}
+			i = end
+
+		case c == '<':
+			end := tagEnd(src, i)
+			out.Write(src[i:end])
+			if name := rawTag(src[i:end]); name != "" {
+				closing := indexFold(src[end:], "</"+name)
+				if closing == -1 {
+					out.Write(src[end:])
+					return out.Bytes()
+				}
+				out.Write(src[end : end+closing])
+				end += closing
+			}
+			i = end
+
+		case isSpace(c):
+			nl := false
+			for ; i < len(src) && isSpace(src[i]); i++ {
+				nl = nl || src[i] == '\n'
+			}
+			// runs separated by a removed comment are merged
+			if b := out.Bytes(); len(b) > 0 && isSpace(b[len(b)-1]) {
+				nl = nl || b[len(b)-1] == '\n'
+				out.Truncate(len(b) - 1)
+			}
+			if nl {
+				out.WriteByte('\n')
+			} else {
+				out.WriteByte(' ')
+			}
+
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes()
+}
+
+// tagEnd returns position after the tag starting at i, skipping '>' in quoted attribute values
+func tagEnd(src []byte, i int) int {
+	var quote byte
+	for i++; i < len(src); i++ {
+		switch c := src[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// rawTag returns name of the raw element opened by tag, or empty string
+func rawTag(tag []byte) string {
+	for _, name := range rawTags {
+		if len(tag) > len(name)+1 && bytes.EqualFold(tag[1:len(name)+1], []byte(name)) {
+			switch tag[len(name)+1] {
+			case '>', '/', ' ', '\t', '\n', '\r', '\f':
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// indexFold is case insensitive bytes.Index for ASCII sep
+func indexFold(s []byte, sep string) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], []byte(sep)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -13,8 +13,8 @@ import (
 	"appengine/datastore"
 	"appengine/memcache"
 
-	"code.google.com/p/gorilla/securecookie"
-	"code.google.com/p/gorilla/sessions"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
 )
 
 // DatastoreStore -------------------------------------------------------------
@@ -81,43 +81,55 @@ func (s *DatastoreStore) New(r *http.Request, name string) (*sessions.Session,
 }
 
 // Save adds a single session to the response.
+//
+// The session is written and the cookie is set only if the values were
+// modified since the session was loaded (see Session.Modified), so new empty
+// and untouched sessions don't emit Set-Cookie. A session with MaxAge < 0
+// always gets the expiring cookie.
 func (s *DatastoreStore) Save(r *http.Request, w http.ResponseWriter,
 	session *sessions.Session) error {
+	options := s.Options
+	if session.Options != nil {
+		options = session.Options
+	}
+	modified := session.Modified()
+	if !modified && options.MaxAge >= 0 {
+		// Nothing changed: leave the backend and the cookie alone.
+		return nil
+	}
 	if session.ID == "" {
 		session.ID = string(securecookie.GenerateRandomKey(32))
 	}
-	if err := s.save(r, session); err != nil {
-		return err
+	if modified {
+		if err := s.save(r, session); err != nil {
+			return err
+		}
 	}
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
-		s.Codecs...)
-	if err != nil {
+	return setCookie(w, session, options, s.Codecs)
+}
+
+// Touch implements sessions.Toucher. It stores the session again with the
+// current date and sends the cookie again, so the session expires MaxAge
+// after the last request touching it.
+func (s *DatastoreStore) Touch(r *http.Request, w http.ResponseWriter,
+	session *sessions.Session) error {
+	if err := s.save(r, session); err != nil {
 		return err
 	}
-	options := s.Options
+	return setCookie(w, session, s.options(session), s.Codecs)
+}
+
+// options returns options of the session, or default options of the store.
+func (s *DatastoreStore) options(session *sessions.Session) *sessions.Options {
 	if session.Options != nil {
-		options = session.Options
+		return session.Options
 	}
-	cookie := &http.Cookie{
-		Name:     session.Name(),
-		Value:    encoded,
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
-	}
-	http.SetCookie(w, cookie)
-	return nil
+	return s.Options
 }
 
 // save writes encoded session.Values to datastore.
 func (s *DatastoreStore) save(r *http.Request,
 	session *sessions.Session) error {
-	if len(session.Values) == 0 {
-		// Don't need to write anything.
-		return nil
-	}
 	serialized, err := serialize(session.Values)
 	if err != nil {
 		return err
@@ -125,7 +137,7 @@ func (s *DatastoreStore) save(r *http.Request,
 	c := newContext(r)
 	k := datastore.NewKey(c, s.kind, session.ID, 0, nil)
 	k, err = datastore.Put(c, k, &Session{
-		Date:  time.Now(),
+		Date:  sessions.Now(),
 		Value: serialized,
 	})
 	if err != nil {
@@ -135,7 +147,8 @@ func (s *DatastoreStore) save(r *http.Request,
 }
 
 // load gets a value from datastore and decodes its content into
-// session.Values.
+// session.Values. Sessions not stored for longer than MaxAge are expired, and
+// their id is cleared, so they're saved under a new one.
 func (s *DatastoreStore) load(r *http.Request,
 	session *sessions.Session) error {
 	c := newContext(r)
@@ -144,10 +157,13 @@ func (s *DatastoreStore) load(r *http.Request,
 	if err := datastore.Get(c, k, &entity); err != nil {
 		return err
 	}
-	if err := deserialize(entity.Value, &session.Values); err != nil {
-		return err
+	if maxAge := s.options(session).MaxAge; maxAge > 0 &&
+		sessions.Now().Sub(entity.Date) > time.Duration(maxAge)*time.Second {
+		datastore.Delete(c, k)
+		session.ID = ""
+		return sessions.ErrSessionExpired
 	}
-	return nil
+	return loadValues(entity.Value, session)
 }
 
 // MemcacheStore --------------------------------------------------------------
@@ -208,50 +224,67 @@ func (s *MemcacheStore) New(r *http.Request, name string) (*sessions.Session,
 }
 
 // Save adds a single session to the response.
+//
+// The session is written and the cookie is set only if the values were
+// modified since the session was loaded (see Session.Modified), so new empty
+// and untouched sessions don't emit Set-Cookie. A session with MaxAge < 0
+// always gets the expiring cookie.
 func (s *MemcacheStore) Save(r *http.Request, w http.ResponseWriter,
 	session *sessions.Session) error {
+	options := s.Options
+	if session.Options != nil {
+		options = session.Options
+	}
+	modified := session.Modified()
+	if !modified && options.MaxAge >= 0 {
+		// Nothing changed: leave the backend and the cookie alone.
+		return nil
+	}
 	if session.ID == "" {
 		session.ID = s.prefix + string(securecookie.GenerateRandomKey(32))
 	}
-	if err := s.save(r, session); err != nil {
-		return err
+	if modified {
+		if err := s.save(r, session); err != nil {
+			return err
+		}
 	}
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
-		s.Codecs...)
-	if err != nil {
+	return setCookie(w, session, options, s.Codecs)
+}
+
+// Touch implements sessions.Toucher. It stores the session again, which
+// renews its memcache expiration, and sends the cookie again.
+func (s *MemcacheStore) Touch(r *http.Request, w http.ResponseWriter,
+	session *sessions.Session) error {
+	if err := s.save(r, session); err != nil {
 		return err
 	}
-	options := s.Options
+	return setCookie(w, session, s.options(session), s.Codecs)
+}
+
+// options returns options of the session, or default options of the store.
+func (s *MemcacheStore) options(session *sessions.Session) *sessions.Options {
 	if session.Options != nil {
-		options = session.Options
+		return session.Options
 	}
-	cookie := &http.Cookie{
-		Name:     session.Name(),
-		Value:    encoded,
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
-	}
-	http.SetCookie(w, cookie)
-	return nil
+	return s.Options
 }
 
-// save writes encoded session.Values to memcache.
+// save writes encoded session.Values to memcache. The item expires after
+// MaxAge.
 func (s *MemcacheStore) save(r *http.Request,
 	session *sessions.Session) error {
-	if len(session.Values) == 0 {
-		// Don't need to write anything.
-		return nil
-	}
 	serialized, err := serialize(session.Values)
 	if err != nil {
 		return err
 	}
+	var expiration time.Duration
+	if maxAge := s.options(session).MaxAge; maxAge > 0 {
+		expiration = time.Duration(maxAge) * time.Second
+	}
 	err = memcache.Set(newContext(r), &memcache.Item{
-		Key:   session.ID,
-		Value: serialized,
+		Key:        session.ID,
+		Value:      serialized,
+		Expiration: expiration,
 	})
 	if err != nil {
 		return err
@@ -266,14 +299,39 @@ func (s *MemcacheStore) load(r *http.Request,
 	if err != nil {
 		return err
 	}
-	if err := deserialize(item.Value, &session.Values); err != nil {
+	return loadValues(item.Value, session)
+}
+
+// Cookies --------------------------------------------------------------------
+
+// setCookie adds the cookie holding encoded session id to the response.
+func setCookie(w http.ResponseWriter, session *sessions.Session,
+	options *sessions.Options, codecs []securecookie.Codec) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
+		codecs...)
+	if err != nil {
 		return err
 	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, options))
 	return nil
 }
 
 // Serialization --------------------------------------------------------------
 
+// loadValues decodes serialized values into session.Values, and records a
+// copy of them so that unchanged sessions are not saved again.
+func loadValues(src []byte, session *sessions.Session) error {
+	if err := deserialize(src, &session.Values); err != nil {
+		return err
+	}
+	loaded := make(map[interface{}]interface{})
+	if err := deserialize(src, &loaded); err != nil {
+		return err
+	}
+	session.MarkLoaded(loaded)
+	return nil
+}
+
 // serialize encodes a value using gob.
 func serialize(src interface{}) ([]byte, error) {
 	buf := new(bytes.Buffer)
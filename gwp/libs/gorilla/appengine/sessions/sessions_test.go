@@ -9,7 +9,7 @@ import (
 	"net/http"
 	"testing"
 
-	"code.google.com/p/gorilla/sessions"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
 )
 
 // ----------------------------------------------------------------------------
@@ -163,3 +163,88 @@ func testSessionFlashes(t *testing.T, store sessions.Store) {
 		t.Errorf("Expected dumped flashes; Got %v", flashes)
 	}
 }
+
+// ----------------------------------------------------------------------------
+
+func TestDatastoreSessionUnchanged(t *testing.T) {
+	store := NewDatastoreStore("", []byte("secret-key"))
+	testSessionUnchanged(t, store)
+}
+
+// ----------------------------------------------------------------------------
+
+func TestMemcacheSessionUnchanged(t *testing.T) {
+	store := NewMemcacheStore("", []byte("secret-key"))
+	testSessionUnchanged(t, store)
+}
+
+// ----------------------------------------------------------------------------
+
+func testSessionUnchanged(t *testing.T, store sessions.Store) {
+	defer closeTestingContext()
+
+	var req *http.Request
+	var rsp *ResponseRecorder
+	var cookies []string
+	var session *sessions.Session
+	var err error
+
+	// New and empty: no cookie.
+	req = getRequest()
+	rsp = NewRecorder()
+	if session, err = store.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	if err = sessions.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if c := rsp.Header()["Set-Cookie"]; len(c) != 0 {
+		t.Errorf("Expected no cookie for new empty session; Got %v", c)
+	}
+
+	// Modified: cookie is set.
+	req = getRequest()
+	rsp = NewRecorder()
+	if session, err = store.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = sessions.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if cookies = rsp.Header()["Set-Cookie"]; len(cookies) != 1 {
+		t.Fatalf("Expected a cookie for modified session; Got %v", cookies)
+	}
+
+	// Loaded and untouched: no cookie.
+	req = getRequest()
+	req.Header.Add("Cookie", cookies[0])
+	rsp = NewRecorder()
+	if session, err = store.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	if session.Values["foo"] != "bar" {
+		t.Fatalf("Expected foo=bar; Got %v", session.Values)
+	}
+	if err = sessions.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if c := rsp.Header()["Set-Cookie"]; len(c) != 0 {
+		t.Errorf("Expected no cookie for untouched session; Got %v", c)
+	}
+
+	// Deleted: expiring cookie is set even though nothing changed.
+	req = getRequest()
+	req.Header.Add("Cookie", cookies[0])
+	rsp = NewRecorder()
+	if session, err = store.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Options = &sessions.Options{Path: "/", MaxAge: -1}
+	if err = sessions.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if c := rsp.Header()["Set-Cookie"]; len(c) != 1 {
+		t.Errorf("Expected an expiring cookie for deleted session; Got %v", c)
+	}
+}
@@ -5,8 +5,11 @@
 package context
 
 import (
+	stdctx "context"
 	"net/http"
 	"sync"
+	"time"
+	"unsafe"
 )
 
 // Original implementation by Brad Fitzpatrick:
@@ -15,49 +18,276 @@ import (
 // DefaultContext is a default context instance.
 var DefaultContext = new(Context)
 
+// Values are spread over shardCount shards, selected by shardBits bits of
+// the request hash.
+const (
+	shardBits  = 4
+	shardCount = 1 << shardBits
+)
+
 // Context stores values for requests.
+//
+// Requests are spread over shards, each with its own lock, so concurrent
+// requests rarely wait for each other.
 type Context struct {
-	l sync.Mutex
+	shards [shardCount]shard
+}
+
+// shard stores values for a subset of requests.
+type shard struct {
+	l sync.RWMutex
 	m map[*http.Request]map[interface{}]interface{}
+	t map[*http.Request]time.Time // when values were first set for a request
+}
+
+// shard returns the shard holding values of a given request.
+func (c *Context) shard(req *http.Request) *shard {
+	// Fibonacci hashing: requests are allocated at aligned addresses, so
+	// the low bits of the pointer are mostly equal.
+	h := uint64(uintptr(unsafe.Pointer(req))) * 0x9E3779B97F4A7C15
+	return &c.shards[h>>(64-shardBits)]
 }
 
 // Set stores a value for a given key in a given request.
 func (c *Context) Set(req *http.Request, key, val interface{}) {
-	c.l.Lock()
-	defer c.l.Unlock()
-	if c.m == nil {
-		c.m = make(map[*http.Request]map[interface{}]interface{})
+	s := c.shard(req)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.set(req, key, val)
+}
+
+// SetMulti stores several values for a given request at once.
+func (c *Context) SetMulti(req *http.Request, values map[interface{}]interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	s := c.shard(req)
+	s.l.Lock()
+	defer s.l.Unlock()
+	for key, val := range values {
+		s.set(req, key, val)
+	}
+}
+
+// set stores a value; the shard must be locked for writing.
+func (s *shard) set(req *http.Request, key, val interface{}) {
+	s.values(req)[key] = val
+}
+
+// values returns the values of a request, creating the map if there are none
+// yet; the shard must be locked for writing.
+func (s *shard) values(req *http.Request) map[interface{}]interface{} {
+	if s.m == nil {
+		s.m = make(map[*http.Request]map[interface{}]interface{})
+		s.t = make(map[*http.Request]time.Time)
 	}
-	if c.m[req] == nil {
-		c.m[req] = make(map[interface{}]interface{})
+	if s.m[req] == nil {
+		s.m[req] = make(map[interface{}]interface{})
+		s.t[req] = time.Now()
 	}
-	c.m[req][key] = val
+	return s.m[req]
 }
 
 // Get returns a value registered for a given key in a given request.
 func (c *Context) Get(req *http.Request, key interface{}) interface{} {
-	c.l.Lock()
-	defer c.l.Unlock()
-	if c.m != nil && c.m[req] != nil {
-		return c.m[req][key]
+	val, _ := c.GetOk(req, key)
+	return val
+}
+
+// GetOk returns a value registered for a given key in a given request, and
+// whether the key was set at all, so a nil value can be told from a missing one.
+func (c *Context) GetOk(req *http.Request, key interface{}) (interface{}, bool) {
+	s := c.shard(req)
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if s.m != nil && s.m[req] != nil {
+		val, ok := s.m[req][key]
+		return val, ok
+	}
+	return nil, false
+}
+
+// GetString returns a string value registered for a given key in a given
+// request. It returns "" if the key is missing or the value is not a string.
+func (c *Context) GetString(req *http.Request, key interface{}) string {
+	val, _ := c.Get(req, key).(string)
+	return val
+}
+
+// GetInt returns an int value registered for a given key in a given request.
+// It returns 0 if the key is missing or the value is not an int.
+func (c *Context) GetInt(req *http.Request, key interface{}) int {
+	val, _ := c.Get(req, key).(int)
+	return val
+}
+
+// GetBool returns a bool value registered for a given key in a given request.
+// It returns false if the key is missing or the value is not a bool.
+func (c *Context) GetBool(req *http.Request, key interface{}) bool {
+	val, _ := c.Get(req, key).(bool)
+	return val
+}
+
+// GetAll returns a copy of all values stored for a given request, or nil if
+// there are none. Changing the copy doesn't affect the stored values.
+func (c *Context) GetAll(req *http.Request) map[interface{}]interface{} {
+	s := c.shard(req)
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if s.m == nil || s.m[req] == nil {
+		return nil
+	}
+	all := make(map[interface{}]interface{}, len(s.m[req]))
+	for k, v := range s.m[req] {
+		all[k] = v
 	}
-	return nil
+	return all
 }
 
 // Delete removes the value for a given key in a given request.
 func (c *Context) Delete(req *http.Request, key interface{}) {
-	c.l.Lock()
-	defer c.l.Unlock()
-	if c.m != nil && c.m[req] != nil {
-		delete(c.m[req], key)
+	s := c.shard(req)
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.m != nil && s.m[req] != nil {
+		delete(s.m[req], key)
+	}
+}
+
+// DeleteFunc removes the values for keys matching pred in a given request,
+// e.g. all keys of one type. pred is called with the lock held, so it must
+// not use the context.
+func (c *Context) DeleteFunc(req *http.Request, pred func(key interface{}) bool) {
+	s := c.shard(req)
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.m == nil {
+		return
+	}
+	for key := range s.m[req] {
+		if pred(key) {
+			delete(s.m[req], key)
+		}
 	}
 }
 
 // Clear removes all values for a given request.
 func (c *Context) Clear(req *http.Request) {
-	c.l.Lock()
-	defer c.l.Unlock()
-	if c.m != nil {
-		delete(c.m, req)
+	s := c.shard(req)
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.m != nil {
+		delete(s.m, req)
+		delete(s.t, req)
+	}
+}
+
+// Len returns the number of requests values are stored for. A number growing
+// over time means requests are not cleared.
+func (c *Context) Len() int {
+	requests, _ := c.Stats()
+	return requests
+}
+
+// Stats returns the number of requests values are stored for, and the total
+// number of values. Shards are counted one at a time, so under concurrent
+// use the numbers are approximate.
+func (c *Context) Stats() (requests int, values int) {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.l.RLock()
+		requests += len(s.m)
+		for _, m := range s.m {
+			values += len(m)
+		}
+		s.l.RUnlock()
+	}
+	return requests, values
+}
+
+// PurgeOlderThan removes all values for requests which got their first value
+// longer than maxAge ago, and returns the number of requests purged. It's a
+// safety net for requests which were never cleared.
+func (c *Context) PurgeOlderThan(maxAge time.Duration) int {
+	cutoff := time.Now().Add(-maxAge)
+	count := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.l.Lock()
+		for req, t := range s.t {
+			if t.Before(cutoff) {
+				delete(s.m, req)
+				delete(s.t, req)
+				count++
+			}
+		}
+		s.l.Unlock()
+	}
+	return count
+}
+
+// StartPurge calls PurgeOlderThan(maxAge) every interval, until stop is called.
+func (c *Context) StartPurge(interval, maxAge time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.PurgeOlderThan(maxAge)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// attachment is the std context value set by AttachTo.
+type attachment struct {
+	c *Context
+	s *shard                      // shard guarding m
+	m map[interface{}]interface{} // values of the request
+}
+
+// attachKey is the std context key of the attachment.
+type attachKey struct{}
+
+// AttachTo returns a shallow copy of req whose context.Context carries the
+// values of req, for code which has only the context.Context in scope; see
+// FromStdContext and GetStd. Values set on req later, until it is cleared,
+// are seen too. The copy is a request of its own: Get and Set on it don't
+// reach the values of req.
+func (c *Context) AttachTo(req *http.Request) *http.Request {
+	s := c.shard(req)
+	s.l.Lock()
+	a := &attachment{c: c, s: s, m: s.values(req)}
+	s.l.Unlock()
+	return req.WithContext(stdctx.WithValue(req.Context(), attachKey{}, a))
+}
+
+// FromStdContext returns the Context attached to ctx with AttachTo.
+func FromStdContext(ctx stdctx.Context) (*Context, bool) {
+	a, ok := ctx.Value(attachKey{}).(*attachment)
+	if !ok {
+		return nil, false
+	}
+	return a.c, true
+}
+
+// GetStd returns a value registered for a given key in the request ctx was
+// attached to with AttachTo, like GetOk.
+func (c *Context) GetStd(ctx stdctx.Context, key interface{}) (interface{}, bool) {
+	a, ok := ctx.Value(attachKey{}).(*attachment)
+	if !ok || a.c != c {
+		return nil, false
 	}
+	a.s.l.RLock()
+	defer a.s.l.RUnlock()
+	val, ok := a.m[key]
+	return val, ok
 }
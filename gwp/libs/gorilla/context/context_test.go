@@ -6,7 +6,9 @@ package context
 
 import (
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 )
 
 type keyType int
@@ -14,8 +16,24 @@ type keyType int
 const (
 	key1 keyType = iota
 	key2
+	key3
 )
 
+// tracked returns the number of requests with values in c
+func tracked(c *Context) int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.l.RLock()
+		if len(s.m) != len(s.t) {
+			panic("context: values and timestamps out of sync")
+		}
+		n += len(s.m)
+		s.l.RUnlock()
+	}
+	return n
+}
+
 func TestContext(t *testing.T) {
 	assertEqual := func(val interface{}, exp interface{}) {
 		if val != exp {
@@ -29,25 +47,272 @@ func TestContext(t *testing.T) {
 	// Get()
 	assertEqual(c.Get(r, key1), nil)
 
+	// GetOk()
+	val, ok := c.GetOk(r, key1)
+	assertEqual(val, nil)
+	assertEqual(ok, false)
+
+	c.Set(r, key1, nil)
+	val, ok = c.GetOk(r, key1)
+	assertEqual(val, nil)
+	assertEqual(ok, true)
+	c.Delete(r, key1)
+
 	// Set()
 	c.Set(r, key1, "1")
 	assertEqual(c.Get(r, key1), "1")
-	assertEqual(len(c.m[r]), 1)
+	assertEqual(len(c.shard(r).m[r]), 1)
 
 	c.Set(r, key2, "2")
 	assertEqual(c.Get(r, key2), "2")
-	assertEqual(len(c.m[r]), 2)
+	assertEqual(len(c.shard(r).m[r]), 2)
 
 	// Delete()
 	c.Delete(r, key1)
 	assertEqual(c.Get(r, key1), nil)
-	assertEqual(len(c.m[r]), 1)
+	assertEqual(len(c.shard(r).m[r]), 1)
 
 	c.Delete(r, key2)
 	assertEqual(c.Get(r, key2), nil)
-	assertEqual(len(c.m[r]), 0)
+	assertEqual(len(c.shard(r).m[r]), 0)
 
 	// Clear()
 	c.Clear(r)
-	assertEqual(len(c.m), 0)
+	assertEqual(tracked(c), 0)
+}
+
+func TestGetAll(t *testing.T) {
+	c := new(Context)
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	if all := c.GetAll(r); all != nil {
+		t.Errorf("Expected nil for request without values, got %v", all)
+	}
+
+	c.Set(r, key1, "1")
+	c.Set(r, key2, "2")
+	all := c.GetAll(r)
+	if len(all) != 2 || all[key1] != "1" || all[key2] != "2" {
+		t.Errorf("Expected both values, got %v", all)
+	}
+
+	// the copy is independent of the context
+	c.Set(r, key1, "changed")
+	c.Set(r, key3, "3")
+	all[key2] = "mine"
+	if len(all) != 2 || all[key1] != "1" {
+		t.Errorf("Expected copy not to change with Set, got %v", all)
+	}
+	if c.Get(r, key2) != "2" {
+		t.Errorf("Expected stored value not to change with the copy, got %v", c.Get(r, key2))
+	}
+}
+
+func TestPurgeOlderThan(t *testing.T) {
+	c := new(Context)
+	r1, _ := http.NewRequest("GET", "http://localhost:8080/1", nil)
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/2", nil)
+	c.Set(r1, key1, "1")
+	c.Set(r2, key1, "2")
+	c.shard(r1).t[r1] = time.Now().Add(-time.Hour)
+
+	if n := c.PurgeOlderThan(time.Minute); n != 1 {
+		t.Errorf("Expected 1 request purged, got %d", n)
+	}
+	if c.Get(r1, key1) != nil || tracked(c) != 1 {
+		t.Errorf("Expected old request to be purged")
+	}
+	if c.Get(r2, key1) != "2" {
+		t.Errorf("Expected recent request to be kept")
+	}
+
+	// periodic purge
+	c.shard(r2).t[r2] = time.Now().Add(-time.Hour)
+	stop := c.StartPurge(time.Millisecond, time.Minute)
+	defer stop()
+	for i := 0; i < 1000; i++ {
+		if tracked(c) == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("Expected StartPurge to purge old request")
+}
+
+func TestTypedGetters(t *testing.T) {
+	c := new(Context)
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+
+	// missing keys
+	if c.GetString(r, key1) != "" || c.GetInt(r, key1) != 0 || c.GetBool(r, key1) {
+		t.Errorf("Expected zero values for missing key")
+	}
+
+	c.Set(r, key1, "1")
+	c.Set(r, key2, 2)
+	c.Set(r, key3, true)
+	if v := c.GetString(r, key1); v != "1" {
+		t.Errorf("Expected \"1\", got %q", v)
+	}
+	if v := c.GetInt(r, key2); v != 2 {
+		t.Errorf("Expected 2, got %d", v)
+	}
+	if v := c.GetBool(r, key3); !v {
+		t.Errorf("Expected true, got %v", v)
+	}
+
+	// values of other types
+	if c.GetString(r, key2) != "" || c.GetInt(r, key3) != 0 || c.GetBool(r, key1) {
+		t.Errorf("Expected zero values for mismatched types")
+	}
+}
+
+func TestSetMulti(t *testing.T) {
+	c := new(Context)
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+
+	c.SetMulti(r, nil)
+	c.SetMulti(r, map[interface{}]interface{}{})
+	if tracked(c) != 0 {
+		t.Errorf("Expected empty SetMulti not to track the request")
+	}
+
+	c.Set(r, key3, "3")
+	c.SetMulti(r, map[interface{}]interface{}{key1: "1", key2: 2, key3: "three"})
+	if c.Get(r, key1) != "1" || c.Get(r, key2) != 2 || c.Get(r, key3) != "three" {
+		t.Errorf("Expected all values to be set, got %v", c.GetAll(r))
+	}
+}
+
+func TestAttachTo(t *testing.T) {
+	c := new(Context)
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	if _, ok := FromStdContext(r.Context()); ok {
+		t.Errorf("Expected no Context attached to a plain request")
+	}
+
+	c.Set(r, key1, "1")
+	r2 := c.AttachTo(r)
+	if r2 == r {
+		t.Fatalf("Expected a copy of the request")
+	}
+	if fc, ok := FromStdContext(r2.Context()); !ok || fc != c {
+		t.Errorf("Expected attached Context, got %v, %v", fc, ok)
+	}
+	if v, ok := c.GetStd(r2.Context(), key1); !ok || v != "1" {
+		t.Errorf("Expected value from std context, got %v, %v", v, ok)
+	}
+	if _, ok := new(Context).GetStd(r2.Context(), key1); ok {
+		t.Errorf("Expected other Context not to see the values")
+	}
+
+	// values set on r later are seen, the copy has values of its own
+	c.Set(r, key2, "2")
+	if v, ok := c.GetStd(r2.Context(), key2); !ok || v != "2" {
+		t.Errorf("Expected later value from std context, got %v, %v", v, ok)
+	}
+	c.Set(r2, key3, "3")
+	if c.Get(r, key3) != nil || c.Get(r2, key1) != nil {
+		t.Errorf("Expected values of the copy to be separate, got %v and %v", c.GetAll(r), c.GetAll(r2))
+	}
+	c.Clear(r2)
+	c.Clear(r)
+	if tracked(c) != 0 {
+		t.Errorf("Expected Clear to clear both requests")
+	}
+}
+
+type otherKeyType int
+
+func TestDeleteFunc(t *testing.T) {
+	c := new(Context)
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	c.DeleteFunc(r, func(key interface{}) bool { return true })
+
+	c.Set(r, key1, "1")
+	c.Set(r, key2, "2")
+	c.Set(r, otherKeyType(0), "other")
+	c.Set(r, otherKeyType(1), "other")
+	c.DeleteFunc(r, func(key interface{}) bool {
+		_, ok := key.(otherKeyType)
+		return ok
+	})
+	all := c.GetAll(r)
+	if len(all) != 2 || all[key1] != "1" || all[key2] != "2" {
+		t.Errorf("Expected only keyType values to be left, got %v", all)
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := new(Context)
+	if n := c.Len(); n != 0 {
+		t.Errorf("Expected no requests, got %d", n)
+	}
+	r1, _ := http.NewRequest("GET", "http://localhost:8080/1", nil)
+	r2, _ := http.NewRequest("GET", "http://localhost:8080/2", nil)
+	c.Set(r1, key1, "1")
+	c.Set(r1, key2, "2")
+	c.Set(r2, key1, "1")
+	if requests, values := c.Stats(); requests != 2 || values != 3 {
+		t.Errorf("Expected 2 requests with 3 values, got %d, %d", requests, values)
+	}
+	c.Clear(r1)
+	if n := c.Len(); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+}
+
+// mutexContext is the single lock implementation Context replaced, kept for
+// comparison in benchmarks.
+type mutexContext struct {
+	l sync.Mutex
+	m map[*http.Request]map[interface{}]interface{}
+}
+
+func (c *mutexContext) Set(req *http.Request, key, val interface{}) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.m == nil {
+		c.m = make(map[*http.Request]map[interface{}]interface{})
+	}
+	if c.m[req] == nil {
+		c.m[req] = make(map[interface{}]interface{})
+	}
+	c.m[req][key] = val
+}
+
+func (c *mutexContext) Get(req *http.Request, key interface{}) interface{} {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.m != nil && c.m[req] != nil {
+		return c.m[req][key]
+	}
+	return nil
+}
+
+// benchmarkContext runs goroutines each doing 9 Gets per Set, on requests
+// of their own.
+func benchmarkContext(b *testing.B, set func(*http.Request, interface{}, interface{}), get func(*http.Request, interface{}) interface{}) {
+	b.RunParallel(func(pb *testing.PB) {
+		r, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		set(r, key1, "1")
+		i := 0
+		for pb.Next() {
+			if i%10 == 0 {
+				set(r, key2, i)
+			} else {
+				get(r, key1)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMutexContext(b *testing.B) {
+	c := new(mutexContext)
+	benchmarkContext(b, c.Set, c.Get)
+}
+
+func BenchmarkShardedContext(b *testing.B) {
+	c := new(Context)
+	benchmarkContext(b, c.Set, c.Get)
 }
@@ -59,6 +59,10 @@ and wrap Get() and Set() to accept and return values of a specific type:
 		context.DefaultContext.Set(request, key1, val)
 	}
 
+For strings, ints and bools, GetString(), GetInt() and GetBool() do the type
+assertion, returning the zero value if the key is missing or holds a value
+of another type.
+
 A context must be cleared at the end of a request, to remove all values
 that were stored. This can be done in a http.Handler, after a request was
 served. Just call Clear() passing the request:
@@ -68,5 +72,19 @@ served. Just call Clear() passing the request:
 The package gorilla/mux clears the default context, so if you are using the
 default handler from there you don't need to do anything: context variables
 will be deleted at the end of a request.
+
+Code which has a context.Context but not the request can read the values
+too, if the request was passed on as returned by AttachTo():
+
+	r = context.DefaultContext.AttachTo(r)
+	...
+	val, ok := context.DefaultContext.GetStd(ctx, foo.Key1)
+
+Values of requests which were never cleared stay in the context. To limit
+the leak, PurgeOlderThan removes requests older than a given age, and
+StartPurge does so periodically:
+
+	stop := context.DefaultContext.StartPurge(time.Minute, 10*time.Minute)
+	defer stop()
 */
 package context
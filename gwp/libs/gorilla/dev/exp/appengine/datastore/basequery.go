@@ -75,7 +75,8 @@ type BaseQuery struct {
 
 // Clone returns a copy of the query.
 func (q *BaseQuery) Clone() *BaseQuery {
-	return &BaseQuery{pbq: &(*q.pbq), err: q.err}
+	pbq := *q.pbq
+	return &BaseQuery{pbq: &pbq, err: q.err}
 }
 
 // Namespace sets the namespace for the query.
@@ -176,6 +177,37 @@ func (q *BaseQuery) KeysOnly(keysOnly bool) *BaseQuery {
 	return q
 }
 
+// Project configures the query to return only the given properties,
+// read from the indexes instead of loading whole entities. Calling it
+// without names returns whole entities again.
+//
+// Only indexed properties can be projected, and entities without a value
+// for one of them are not returned. An entity is returned once for each
+// combination of values of multi-valued projected properties. A projection
+// can't be combined with KeysOnly.
+func (q *BaseQuery) Project(names ...string) *BaseQuery {
+	if q.err == nil {
+		if len(names) == 0 {
+			q.pbq.PropertyName = nil
+			return q
+		}
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if name == "" || name == "__key__" {
+				q.err = fmt.Errorf("datastore: invalid projected property %q", name)
+				return q
+			}
+			if seen[name] {
+				q.err = fmt.Errorf("datastore: duplicate projected property %q", name)
+				return q
+			}
+			seen[name] = true
+		}
+		q.pbq.PropertyName = append([]string(nil), names...)
+	}
+	return q
+}
+
 // Compile configures the query to produce cursors.
 func (q *BaseQuery) Compile(compile bool) *BaseQuery {
 	if q.err == nil {
@@ -229,6 +261,9 @@ func (q *BaseQuery) toProto(pbq *pb.Query, zeroLimitMeansZero bool) error {
 	if q.err != nil {
 		return q.err
 	}
+	if proto.GetBool(pbq.KeysOnly) && len(pbq.PropertyName) > 0 {
+		return errors.New("datastore: projection queries can't be keys-only")
+	}
 	if !zeroLimitMeansZero && proto.GetInt32(pbq.Limit) == 0 {
 		pbq.Limit = nil
 	}
@@ -240,7 +275,7 @@ func (q *BaseQuery) Run(c appengine.Context) *Iterator {
 	// Make a copy of the query.
 	req := *q.pbq
 	if err := q.toProto(&req, false); err != nil {
-		return &Iterator{err: q.err}
+		return &Iterator{err: err}
 	}
 	req.App = proto.String(c.FullyQualifiedAppID())
 	t := &Iterator{
@@ -272,21 +307,27 @@ func (q *BaseQuery) GetAll(c appengine.Context, dst interface{}) ([]*Key, error)
 		dv       reflect.Value
 		mat      multiArgType
 		elemType reflect.Type
+		err      error
 	)
 	keysOnly := q.pbq.KeysOnly != nil && *q.pbq.KeysOnly
 	if !keysOnly {
-		dv = reflect.ValueOf(dst)
-		if dv.Kind() != reflect.Ptr || dv.IsNil() {
-			return nil, ErrInvalidEntityType
-		}
-		dv = dv.Elem()
-		mat, elemType = checkMultiArg(dv)
-		if mat == multiArgTypeInvalid || mat == multiArgTypeInterface {
-			return nil, ErrInvalidEntityType
+		if dv, mat, elemType, err = getAllDst(dst); err != nil {
+			return nil, err
 		}
 	}
 
 	var keys []*Key
+	if n := int(proto.GetInt32(q.pbq.Limit)); n > 0 {
+		// The limit bounds the number of results, but a large one is
+		// often far from reached: allocate at most a chunk upfront.
+		if n > getAllChunkSize {
+			n = getAllChunkSize
+		}
+		keys = make([]*Key, 0, n)
+		if !keysOnly {
+			growSlice(dv, n)
+		}
+	}
 	for t := q.Run(c); ; {
 		k, e, err := t.next()
 		if err == Done {
@@ -296,36 +337,85 @@ func (q *BaseQuery) GetAll(c appengine.Context, dst interface{}) ([]*Key, error)
 			return keys, err
 		}
 		if !keysOnly {
-			ev := reflect.New(elemType)
-			if elemType.Kind() == reflect.Map {
-				// This is a special case. The zero values of a map type are
-				// not immediately useful; they have to be make'd.
-				//
-				// Funcs and channels are similar, in that a zero value is not useful,
-				// but even a freshly make'd channel isn't useful: there's no fixed
-				// channel buffer size that is always going to be large enough, and
-				// there's no goroutine to drain the other end. Theoretically, these
-				// types could be supported, for example by sniffing for a constructor
-				// method or requiring prior registration, but for now it's not a
-				// frequent enough concern to be worth it. Programmers can work around
-				// it by explicitly using Iterator.Next instead of the Query.GetAll
-				// convenience method.
-				x := reflect.MakeMap(elemType)
-				ev.Elem().Set(x)
-			}
-			if err = loadEntity(ev.Interface(), e); err != nil {
+			if err = appendEntity(dv, mat, elemType, entityLoader(e)); err != nil {
 				return keys, err
 			}
-			if mat != multiArgTypeStructPtr {
-				ev = ev.Elem()
-			}
-			dv.Set(reflect.Append(dv, ev))
 		}
 		keys = append(keys, k)
 	}
 	return keys, nil
 }
 
+// getAllDst checks the GetAll destination, returning the slice it points
+// to, and the type of its elements.
+func getAllDst(dst interface{}) (dv reflect.Value, mat multiArgType, elemType reflect.Type, err error) {
+	dv = reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return dv, mat, nil, ErrInvalidEntityType
+	}
+	dv = dv.Elem()
+	mat, elemType = checkMultiArg(dv)
+	if mat == multiArgTypeInvalid || mat == multiArgTypeInterface {
+		return dv, mat, nil, ErrInvalidEntityType
+	}
+	return dv, mat, elemType, nil
+}
+
+// appendEntity loads an entity with load into a new element of type
+// elemType, appended to the slice dv.
+func appendEntity(dv reflect.Value, mat multiArgType, elemType reflect.Type, load func(dst interface{}) error) error {
+	ev := reflect.New(elemType)
+	if elemType.Kind() == reflect.Map {
+		// This is a special case. The zero values of a map type are
+		// not immediately useful; they have to be make'd.
+		//
+		// Funcs and channels are similar, in that a zero value is not useful,
+		// but even a freshly make'd channel isn't useful: there's no fixed
+		// channel buffer size that is always going to be large enough, and
+		// there's no goroutine to drain the other end. Theoretically, these
+		// types could be supported, for example by sniffing for a constructor
+		// method or requiring prior registration, but for now it's not a
+		// frequent enough concern to be worth it. Programmers can work around
+		// it by explicitly using Iterator.Next instead of the Query.GetAll
+		// convenience method.
+		x := reflect.MakeMap(elemType)
+		ev.Elem().Set(x)
+	}
+	if err := load(ev.Interface()); err != nil {
+		return err
+	}
+	if mat != multiArgTypeStructPtr {
+		ev = ev.Elem()
+	}
+	if dv.Len() == dv.Cap() {
+		growSlice(dv, getAllChunkSize+dv.Len())
+	}
+	dv.Set(reflect.Append(dv, ev))
+	return nil
+}
+
+// entityLoader returns a func loading e, for appendEntity.
+func entityLoader(e *pb.EntityProto) func(dst interface{}) error {
+	return func(dst interface{}) error {
+		return loadEntity(dst, e)
+	}
+}
+
+// getAllChunkSize is the minimum number of elements GetAll grows the
+// destination slice by, and the most it allocates upfront for a limit.
+const getAllChunkSize = 64
+
+// growSlice makes sure the slice pointed to by v has room for n more
+// elements without reallocating.
+func growSlice(v reflect.Value, n int) {
+	if v.Cap()-v.Len() >= n {
+		return
+	}
+	s := reflect.MakeSlice(v.Type(), v.Len(), v.Len()+n)
+	reflect.Copy(s, v)
+	v.Set(s)
+}
+
 // GetPage is the same as GetAll, but it also returns a cursor and a flag
 // indicating if there are more results.
 func (q *BaseQuery) GetPage(c appengine.Context, dst interface{}) (keys []*Key,
@@ -352,11 +442,12 @@ func (q *BaseQuery) Count(c appengine.Context) (int, error) {
 	if q.err != nil {
 		return 0, q.err
 	}
-	// Run a copy of the query, with keysOnly true, and an adjusted offset.
+	// Run a copy of the query, with keysOnly true, no projection and an
+	// adjusted offset.
 	// We also set the limit to zero, as we don't want any actual entity data,
 	// just the number of skipped results.
 	newQ := q.Clone()
-	newQ.KeysOnly(true)
+	newQ.Project().KeysOnly(true)
 	newQ.Limit(0)
 	limit := proto.GetInt32(q.pbq.Limit)
 	offset := proto.GetInt32(q.pbq.Offset)
@@ -372,12 +463,13 @@ func (q *BaseQuery) Count(c appengine.Context) (int, error) {
 		}
 	}
 	newQ.Offset(int(newOffset))
-	req := &pb.Query{}
-	if err := newQ.toProto(req, true); err != nil {
+	req := *newQ.pbq
+	if err := newQ.toProto(&req, true); err != nil {
 		return 0, err
 	}
+	req.App = proto.String(c.FullyQualifiedAppID())
 	res := &pb.QueryResult{}
-	if err := c.Call("datastore_v3", "RunQuery", req, res, nil); err != nil {
+	if err := c.Call("datastore_v3", "RunQuery", &req, res, nil); err != nil {
 		return 0, err
 	}
 
@@ -427,7 +519,7 @@ func (q *BaseQuery) GetCursorAt(c appengine.Context, position int) (*Cursor, err
 	if err := validateInt32(position, "cursor position"); err != nil {
 		return nil, err
 	}
-	q = q.Clone().Limit(0).Offset(position).KeysOnly(true).Compile(true)
+	q = q.Clone().Limit(0).Offset(position).Project().KeysOnly(true).Compile(true)
 	t := q.Run(c)
 	for {
 		if _, err := t.Next(nil); err == Done {
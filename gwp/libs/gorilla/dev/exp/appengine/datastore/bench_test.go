@@ -0,0 +1,49 @@
+package datastore
+
+import (
+	"fmt"
+	"gae-go-testing.googlecode.com/git/appenginetesting"
+	"testing"
+)
+
+type benchEntity struct {
+	Name  string
+	Value int
+}
+
+func benchmarkGetAll(b *testing.B, limit int) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		b.Fatalf("NewContext: %v", err)
+	}
+	defer c.Close()
+
+	const n = 500
+	keys := make([]*Key, n)
+	entities := make([]*benchEntity, n)
+	for i := range keys {
+		keys[i] = NewKey(c, "Bench", fmt.Sprintf("%04d", i), 0, nil)
+		entities[i] = &benchEntity{Name: "entity", Value: i}
+	}
+	if _, err := PutMulti(c, keys, entities); err != nil {
+		b.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	q := NewQuery("Bench").Limit(limit)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst []benchEntity
+		if _, err := q.GetAll(c, &dst); err != nil {
+			b.Fatalf("Error on GetAll(): %v", err)
+		}
+	}
+}
+
+func BenchmarkGetAllLimit(b *testing.B) {
+	benchmarkGetAll(b, 500)
+}
+
+func BenchmarkGetAllNoLimit(b *testing.B) {
+	benchmarkGetAll(b, 0)
+}
@@ -246,7 +246,46 @@ func Get(c appengine.Context, key *Key, dst interface{}) error {
 // As a special case, PropertyList is an invalid type for dst, even though a
 // PropertyList is a slice of structs. It is treated as invalid to avoid being
 // mistakenly passed when []PropertyList was intended.
+//
+// Repeated keys are fetched once, and the entity is loaded into every
+// matching position of dst. Positions with the same key get the same error.
 func GetMulti(c appengine.Context, key []*Key, dst interface{}) error {
+	return GetMultiWithOptions(c, key, dst, nil)
+}
+
+// GetOptions holds options for GetWithOptions and GetMultiWithOptions.
+// A nil *GetOptions uses the default options.
+type GetOptions struct {
+	ignoreFieldMismatch bool
+}
+
+// IgnoreFieldMismatch sets whether ErrFieldMismatch is ignored when loading
+// entities into structs. The default is false.
+//
+// This allows schema evolution: entities stored with properties that were
+// since removed from the struct, or that have changed type, load without
+// error; such properties are skipped. Properties added to the struct are
+// left with their zero value when loading older entities, which never is an
+// error.
+func (o *GetOptions) IgnoreFieldMismatch(ignore bool) *GetOptions {
+	o.ignoreFieldMismatch = ignore
+	return o
+}
+
+// GetWithOptions is the same as Get, using the given options.
+func GetWithOptions(c appengine.Context, key *Key, dst interface{}, opts *GetOptions) error {
+	err := GetMultiWithOptions(c, []*Key{key}, []interface{}{dst}, opts)
+	if me, ok := err.(appengine.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// GetMultiWithOptions is the same as GetMulti, using the given options.
+func GetMultiWithOptions(c appengine.Context, key []*Key, dst interface{}, opts *GetOptions) error {
+	if opts == nil {
+		opts = new(GetOptions)
+	}
 	v := reflect.ValueOf(dst)
 	multiArgType, _ := checkMultiArg(v)
 	if multiArgType == multiArgTypeInvalid {
@@ -261,19 +300,20 @@ func GetMulti(c appengine.Context, key []*Key, dst interface{}) error {
 	if err := multiValid(key); err != nil {
 		return err
 	}
+	unique, index := uniqueKeys(key)
 	req := &pb.GetRequest{
-		Key: multiKeyToProto(key),
+		Key: multiKeyToProto(unique),
 	}
 	res := &pb.GetResponse{}
 	if err := c.Call("datastore_v3", "Get", req, res, nil); err != nil {
 		return err
 	}
-	if len(key) != len(res.Entity) {
+	if len(unique) != len(res.Entity) {
 		return errors.New("datastore: internal error: server returned the wrong number of entities")
 	}
 	multiErr, any := make(appengine.MultiError, len(key)), false
-	for i, e := range res.Entity {
-		if e.Entity == nil {
+	for i := range key {
+		if e := res.Entity[index[i]]; e.Entity == nil {
 			multiErr[i] = ErrNoSuchEntity
 		} else {
 			elem := v.Index(i)
@@ -281,6 +321,9 @@ func GetMulti(c appengine.Context, key []*Key, dst interface{}) error {
 				elem = elem.Addr()
 			}
 			multiErr[i] = loadEntity(elem.Interface(), e.Entity)
+			if _, ok := multiErr[i].(*ErrFieldMismatch); ok && opts.ignoreFieldMismatch {
+				multiErr[i] = nil
+			}
 		}
 		if multiErr[i] != nil {
 			any = true
@@ -292,6 +335,76 @@ func GetMulti(c appengine.Context, key []*Key, dst interface{}) error {
 	return nil
 }
 
+// GetMultiInto is a GetMulti for destinations of different types, e.g. to
+// load a user and its organization in one call. Each element of dst must be
+// a valid dst for Get: a struct pointer or a PropertyLoadSaver.
+//
+// Entities are loaded positionally, and errors are reported the same way,
+// in an appengine.MultiError: elements of dst which are not valid get
+// ErrInvalidEntityType, and their keys are not fetched.
+func GetMultiInto(c appengine.Context, key []*Key, dst []interface{}) error {
+	if len(key) != len(dst) {
+		return errors.New("datastore: key and dst slices have different length")
+	}
+	multiErr, any := make(appengine.MultiError, len(key)), false
+	var (
+		validKey []*Key
+		validDst []interface{}
+		index    []int
+	)
+	for i, d := range dst {
+		if !validEntity(d) {
+			multiErr[i], any = ErrInvalidEntityType, true
+			continue
+		}
+		validKey = append(validKey, key[i])
+		validDst = append(validDst, d)
+		index = append(index, i)
+	}
+	err := GetMulti(c, validKey, validDst)
+	if me, ok := err.(appengine.MultiError); ok {
+		for j, e := range me {
+			multiErr[index[j]] = e
+			any = any || e != nil
+		}
+	} else if err != nil {
+		return err
+	}
+	if any {
+		return multiErr
+	}
+	return nil
+}
+
+// validEntity checks if v can be loaded into: it must be a non-nil struct
+// pointer or a PropertyLoadSaver.
+func validEntity(v interface{}) bool {
+	if _, ok := v.(PropertyLoadSaver); ok {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct
+}
+
+// uniqueKeys returns the keys without repetitions, and for each position of
+// key the position of its key in the returned slice.
+func uniqueKeys(key []*Key) ([]*Key, []int) {
+	unique := make([]*Key, 0, len(key))
+	index := make([]int, len(key))
+	seen := make(map[string]int, len(key))
+	for i, k := range key {
+		enc := k.Encode()
+		j, ok := seen[enc]
+		if !ok {
+			j = len(unique)
+			seen[enc] = j
+			unique = append(unique, k)
+		}
+		index[i] = j
+	}
+	return unique, index
+}
+
 // Put saves the entity src into the datastore with key k. src must be a struct
 // pointer or implement PropertyLoadSaver; if a struct pointer then any
 // unexported fields of that struct will be skipped. If k is an incomplete key,
@@ -310,6 +423,7 @@ func Put(c appengine.Context, key *Key, src interface{}) (*Key, error) {
 // PutMulti is a batch version of Put.
 //
 // src must satisfy the same conditions as the dst argument to GetMulti.
+// Hooks registered with OnWrite are called for the saved entities.
 func PutMulti(c appengine.Context, key []*Key, src interface{}) ([]*Key, error) {
 	v := reflect.ValueOf(src)
 	multiArgType, _ := checkMultiArg(v)
@@ -326,12 +440,14 @@ func PutMulti(c appengine.Context, key []*Key, src interface{}) ([]*Key, error)
 		return nil, err
 	}
 	req := &pb.PutRequest{}
+	entities := make([]interface{}, len(key))
 	for i := range key {
 		elem := v.Index(i)
 		if multiArgType == multiArgTypePropertyLoadSaver || multiArgType == multiArgTypeStruct {
 			elem = elem.Addr()
 		}
-		sProto, err := saveEntity(key[i], elem.Interface())
+		entities[i] = elem.Interface()
+		sProto, err := saveEntity(key[i], entities[i])
 		if err != nil {
 			return nil, err
 		}
@@ -352,10 +468,14 @@ func PutMulti(c appengine.Context, key []*Key, src interface{}) ([]*Key, error)
 			return nil, errors.New("datastore: internal error: server returned an invalid key")
 		}
 	}
+	afterWrite(c, ret, entities)
 	return ret, nil
 }
 
 // Delete deletes the entity for the given key.
+//
+// Delete is idempotent: deleting a key for which no entity is stored is a
+// no-op, not an error. Use DeleteIfExists to learn whether it existed.
 func Delete(c appengine.Context, key *Key) error {
 	err := DeleteMulti(c, []*Key{key})
 	if me, ok := err.(appengine.MultiError); ok {
@@ -365,6 +485,12 @@ func Delete(c appengine.Context, key *Key) error {
 }
 
 // DeleteMulti is a batch version of Delete.
+//
+// Keys without a stored entity are skipped, like in Delete. The datastore
+// doesn't report errors per key: either all the entities are deleted or an
+// error is returned.
+//
+// Hooks registered with OnDelete are called for the keys, see OnWrite.
 func DeleteMulti(c appengine.Context, key []*Key) error {
 	if len(key) == 0 {
 		return nil
@@ -376,7 +502,90 @@ func DeleteMulti(c appengine.Context, key []*Key) error {
 		Key: multiKeyToProto(key),
 	}
 	res := &pb.DeleteResponse{}
-	return c.Call("datastore_v3", "Delete", req, res, nil)
+	if err := c.Call("datastore_v3", "Delete", req, res, nil); err != nil {
+		return err
+	}
+	afterDelete(c, key)
+	return nil
+}
+
+// DeleteIfExists deletes the entity for the given key and returns whether
+// it existed.
+func DeleteIfExists(c appengine.Context, key *Key) (bool, error) {
+	n, err := DeleteMultiCount(c, []*Key{key})
+	return n == 1, err
+}
+
+// DeleteMultiCount is like DeleteMulti, but returns the number of entities
+// actually removed. Repeated keys are counted once.
+//
+// The datastore doesn't return this number, so the keys are first checked
+// with ExistsMulti, which costs one query per unique key. The queries can be
+// run in a transaction; outside of one the count may be off if the entities
+// are concurrently written or deleted.
+func DeleteMultiCount(c appengine.Context, key []*Key) (int, error) {
+	if len(key) == 0 {
+		return 0, nil
+	}
+	if err := multiValid(key); err != nil {
+		return 0, err
+	}
+	unique, _ := uniqueKeys(key)
+	found, err := ExistsMulti(c, unique)
+	if err != nil {
+		return 0, err
+	}
+	var existing []*Key
+	for i, ok := range found {
+		if ok {
+			existing = append(existing, unique[i])
+		}
+	}
+	if err := DeleteMulti(c, existing); err != nil {
+		return 0, err
+	}
+	return len(existing), nil
+}
+
+// Exists returns whether an entity is stored for the given key.
+//
+// It runs a keys-only query filtered by the key, so the entity properties
+// are never transferred. The query has the key as its ancestor, so it can be
+// run in a transaction. A missing entity is reported as false, not as
+// ErrNoSuchEntity.
+func Exists(c appengine.Context, key *Key) (bool, error) {
+	found, err := ExistsMulti(c, []*Key{key})
+	if err != nil {
+		if me, ok := err.(appengine.MultiError); ok {
+			return false, me[0]
+		}
+		return false, err
+	}
+	return found[0], nil
+}
+
+// ExistsMulti is a batch version of Exists.
+//
+// It issues one keys-only query per key, which is cheaper than GetMulti for
+// large entities but costs more round trips for long key lists.
+func ExistsMulti(c appengine.Context, key []*Key) ([]bool, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if err := multiValid(key); err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(key))
+	for i, k := range key {
+		q := NewBaseQuery().Namespace(k.namespace).Kind(k.kind).Ancestor(k).
+			Filter("__key__", QueryOperatorEqual, k).KeysOnly(true).Limit(1)
+		keys, err := q.GetAll(c, nil)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = len(keys) > 0
+	}
+	return found, nil
 }
 
 func init() {
@@ -1,9 +1,20 @@
 package datastore
 
 import (
+	"appengine"
+	"appengine_internal"
+	pb "appengine_internal/datastore"
+	"bytes"
+	"errors"
+	"code.google.com/p/goprotobuf/proto"
 	"fmt"
 	"gae-go-testing.googlecode.com/git/appenginetesting"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func getContext(t *testing.T) *appenginetesting.Context {
@@ -81,6 +92,1035 @@ func TestNamespaceKeyEquality(t *testing.T) {
 
 // ----------------------------------------------------------------------------
 
+func TestExists(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	k1 := NewKey(c, "A", "present", 0, nil)
+	k2 := NewKey(c, "A", "absent", 0, nil)
+	if _, err := Put(c, k1, &struct{}{}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+
+	if ok, err := Exists(c, k1); err != nil || !ok {
+		t.Errorf("Expected %v to exist, got %v, %v", k1, ok, err)
+	}
+	if ok, err := Exists(c, k2); err != nil || ok {
+		t.Errorf("Expected %v to be absent, got %v, %v", k2, ok, err)
+	}
+
+	found, err := ExistsMulti(c, []*Key{k2, k1, k2})
+	if err != nil {
+		t.Fatalf("Error on ExistsMulti(): %v", err)
+	}
+	if len(found) != 3 || found[0] || !found[1] || found[2] {
+		t.Errorf("Expected [false true false], got %v", found)
+	}
+
+	// queries in transactions must have an ancestor
+	tc := &ancestorContext{Context: c}
+	if ok, err := Exists(tc, k1); err != nil || !ok {
+		t.Errorf("Expected %v to exist in a transaction, got %v, %v", k1, ok, err)
+	}
+	if n, err := DeleteMultiCount(tc, []*Key{k1, k2}); err != nil || n != 1 {
+		t.Errorf("Expected 1 entity deleted in a transaction, got %d, %v", n, err)
+	}
+}
+
+// ancestorContext refuses queries without an ancestor, as the datastore does
+// in transactions.
+type ancestorContext struct {
+	appengine.Context
+}
+
+func (c *ancestorContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	if req, ok := in.(*pb.Query); ok && req.Ancestor == nil {
+		return errors.New("queries inside transactions must have ancestors")
+	}
+	return c.Context.Call(service, method, in, out, opts)
+}
+
+// ----------------------------------------------------------------------------
+
+// getKeysContext records the number of keys sent with each Get call.
+type getKeysContext struct {
+	appengine.Context
+	sent []int
+}
+
+func (c *getKeysContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	if req, ok := in.(*pb.GetRequest); ok {
+		c.sent = append(c.sent, len(req.Key))
+	}
+	return c.Context.Call(service, method, in, out, opts)
+}
+
+func TestGetMultiDuplicateKeys(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type T struct{ N int64 }
+	k1 := NewKey(c, "T", "one", 0, nil)
+	k2 := NewKey(c, "T", "two", 0, nil)
+	absent := NewKey(c, "T", "absent", 0, nil)
+	if _, err := PutMulti(c, []*Key{k1, k2}, []T{{1}, {2}}); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	gc := &getKeysContext{Context: c}
+	keys := []*Key{k1, k2, NewKey(c, "T", "one", 0, nil), absent, k2, absent}
+	dst := make([]*T, len(keys))
+	for i := range dst {
+		dst[i] = new(T)
+	}
+	err := GetMulti(gc, keys, dst)
+	if len(gc.sent) != 1 || gc.sent[0] != 3 {
+		t.Errorf("Expected one Get call with 3 keys, got %v", gc.sent)
+	}
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		t.Fatalf("Expected appengine.MultiError, got %v", err)
+	}
+	want := []int64{1, 2, 1, 0, 2, 0}
+	for i, n := range want {
+		if n == 0 {
+			if me[i] != ErrNoSuchEntity {
+				t.Errorf("%d: expected ErrNoSuchEntity, got %v", i, me[i])
+			}
+			continue
+		}
+		if me[i] != nil || dst[i].N != n {
+			t.Errorf("%d: expected N=%d, got %d, %v", i, n, dst[i].N, me[i])
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func TestDeleteMultiCount(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	k1 := NewKey(c, "A", "one", 0, nil)
+	k2 := NewKey(c, "A", "two", 0, nil)
+	absent := NewKey(c, "A", "absent", 0, nil)
+	if _, err := PutMulti(c, []*Key{k1, k2}, []struct{}{{}, {}}); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	n, err := DeleteMultiCount(c, []*Key{k1, absent, k2, k1})
+	if err != nil || n != 2 {
+		t.Errorf("Expected 2 entities deleted, got %d, %v", n, err)
+	}
+	if found, err := ExistsMulti(c, []*Key{k1, k2}); err != nil || found[0] || found[1] {
+		t.Errorf("Expected entities to be deleted, got %v, %v", found, err)
+	}
+
+	if err := DeleteMulti(c, []*Key{k1, absent}); err != nil {
+		t.Errorf("Expected deleting absent keys to be a no-op, got %v", err)
+	}
+	if err := Delete(c, absent); err != nil {
+		t.Errorf("Expected deleting absent key to be a no-op, got %v", err)
+	}
+
+	if _, err := Put(c, k1, &struct{}{}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	if ok, err := DeleteIfExists(c, k1); err != nil || !ok {
+		t.Errorf("Expected %v to be deleted, got %v, %v", k1, ok, err)
+	}
+	if ok, err := DeleteIfExists(c, k1); err != nil || ok {
+		t.Errorf("Expected %v to be absent, got %v, %v", k1, ok, err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func TestSoftDelete(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type Doc struct {
+		Title     string
+		Deleted   bool
+		DeletedAt time.Time
+	}
+	RegisterSoftDelete("Doc")
+	epoch := time.Unix(0, 0)
+
+	k1 := NewKey(c, "Doc", "one", 0, nil)
+	k2 := NewKey(c, "Doc", "two", 0, nil)
+	if _, err := PutMulti(c, []*Key{k1, k2}, []Doc{{"one", false, epoch}, {"two", false, epoch}}); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+	if err := SoftDelete(c, k1); err != nil {
+		t.Fatalf("Error on SoftDelete(): %v", err)
+	}
+
+	var docs []Doc
+	keys, err := NewQuery("Doc").GetAll(c, &docs)
+	if err != nil || len(keys) != 1 || !keys[0].Equal(k2) {
+		t.Errorf("Expected only %v, got %v, %v", k2, keys, err)
+	}
+	if n, err := NewQuery("Doc").Filter("Title =", "one").Count(c); err != nil || n != 0 {
+		t.Errorf("Expected soft-deleted entity to be excluded, got %d, %v", n, err)
+	}
+	if n, err := NewQuery("Doc").IncludeDeleted(true).Count(c); err != nil || n != 2 {
+		t.Errorf("Expected 2 entities including deleted, got %d, %v", n, err)
+	}
+	if n, err := NewQuery("Doc").FilterIn("Title", "one", "two").Count(c); err != nil || n != 1 {
+		t.Errorf("Expected FilterIn to exclude soft-deleted entity, got %d, %v", n, err)
+	}
+
+	var doc Doc
+	if err := Get(c, k1, &doc); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if doc.Title != "one" || !doc.Deleted || !doc.DeletedAt.After(epoch) {
+		t.Errorf("Expected flagged entity to be kept, got %+v", doc)
+	}
+
+	if err := SoftDelete(c, NewKey(c, "Doc", "absent", 0, nil)); err != ErrNoSuchEntity {
+		t.Errorf("Expected ErrNoSuchEntity, got %v", err)
+	}
+	if err := SoftDelete(c, NewKey(c, "Other", "one", 0, nil)); err == nil {
+		t.Errorf("Expected error for kind not registered for soft delete")
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// putContext records Put requests.
+type putContext struct {
+	appengine.Context
+	puts []*pb.PutRequest
+}
+
+func (c *putContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	if req, ok := in.(*pb.PutRequest); ok {
+		c.puts = append(c.puts, req)
+	}
+	return c.Context.Call(service, method, in, out, opts)
+}
+
+// keepEncryptionKey returns a func restoring the current encryption key.
+func keepEncryptionKey() func() {
+	gcm := encryptionAEAD()
+	return func() {
+		encryptionMu.Lock()
+		encryptionGCM = gcm
+		encryptionMu.Unlock()
+	}
+}
+
+func TestEncryptedFields(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+	defer keepEncryptionKey()()
+
+	type Person struct {
+		Name  string
+		Email string `datastore:",encrypt"`
+		Notes []byte `datastore:"notes,encrypt"`
+	}
+	if err := SetEncryptionKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatalf("Error on SetEncryptionKey(): %v", err)
+	}
+
+	pc := &putContext{Context: c}
+	k := NewKey(c, "Person", "bob", 0, nil)
+	src := &Person{"Bob", "bob@example.com", []byte("secret notes")}
+	if _, err := Put(pc, k, src); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	if len(pc.puts) != 1 {
+		t.Fatalf("Expected one Put call, got %d", len(pc.puts))
+	}
+	raw, err := proto.Marshal(pc.puts[0])
+	if err != nil {
+		t.Fatalf("Error marshaling PutRequest: %v", err)
+	}
+	for _, plain := range []string{"bob@example.com", "secret notes"} {
+		if bytes.Contains(raw, []byte(plain)) {
+			t.Errorf("Stored entity contains plaintext %q", plain)
+		}
+	}
+	if !bytes.Contains(raw, []byte("Bob")) {
+		t.Errorf("Expected unencrypted field to be stored as is")
+	}
+
+	var props PropertyList
+	if err := Get(c, k, &props); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	for _, p := range props {
+		if _, ok := p.Value.([]byte); p.Name != "Name" && (!ok || !p.NoIndex) {
+			t.Errorf("Expected %s to be stored as non-indexed []byte, got %#v", p.Name, p)
+		}
+	}
+
+	var dst Person
+	if err := Get(c, k, &dst); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if dst.Name != src.Name || dst.Email != src.Email || string(dst.Notes) != string(src.Notes) {
+		t.Errorf("Expected %+v, got %+v", src, dst)
+	}
+
+	// values are bound to their entity and property
+	k2 := NewKey(c, "Person", "eve", 0, nil)
+	if _, err := Put(c, k2, &props); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	if err := Get(c, k2, &Person{}); err == nil {
+		t.Errorf("Expected error loading values copied to another entity")
+	}
+	for i := range props {
+		if props[i].Name == "notes" {
+			props[i].Name = "Email"
+		} else if props[i].Name == "Email" {
+			props[i].Name = "notes"
+		}
+	}
+	if _, err := Put(c, k, &props); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	if err := Get(c, k, &Person{}); err == nil {
+		t.Errorf("Expected error loading values swapped between properties")
+	}
+	if _, err := Put(c, k, src); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+
+	// entities put with an incomplete key get their id afterwards
+	k3, err := Put(c, NewIncompleteKey(c, "Person", nil), src)
+	if err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	dst = Person{}
+	if err := Get(c, k3, &dst); err != nil || dst.Email != src.Email {
+		t.Errorf("Expected %+v, got %+v, %v", src, dst, err)
+	}
+
+	// a different key can't decrypt
+	if err := SetEncryptionKey([]byte("fedcba9876543210")); err != nil {
+		t.Fatalf("Error on SetEncryptionKey(): %v", err)
+	}
+	if err := Get(c, k, &Person{}); err == nil {
+		t.Errorf("Expected error loading with a different key")
+	}
+
+	type Bad struct {
+		N int `datastore:",encrypt"`
+	}
+	if _, err := Put(c, NewKey(c, "Bad", "x", 0, nil), &Bad{1}); err == nil {
+		t.Errorf("Expected error encrypting int field")
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func TestIgnoreFieldMismatch(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type v1 struct {
+		Name  string
+		Email string
+	}
+	type added struct {
+		Name  string
+		Email string
+		Age   int64
+	}
+	type removed struct {
+		Name string
+	}
+	k := NewKey(c, "Person", "bob", 0, nil)
+	if _, err := Put(c, k, &v1{"Bob", "bob@example.com"}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	ignore := new(GetOptions).IgnoreFieldMismatch(true)
+
+	// Adding a field never fails.
+	var a added
+	if err := Get(c, k, &a); err != nil || a.Name != "Bob" || a.Age != 0 {
+		t.Errorf("Expected Bob with zero age, got %v, %v", a, err)
+	}
+
+	// Removing a field fails by default, unless mismatches are ignored.
+	var r removed
+	if err := Get(c, k, &r); err == nil {
+		t.Errorf("Expected ErrFieldMismatch, got nil")
+	} else if _, ok := err.(*ErrFieldMismatch); !ok {
+		t.Errorf("Expected ErrFieldMismatch, got %v", err)
+	}
+	r = removed{}
+	if err := GetWithOptions(c, k, &r, ignore); err != nil || r.Name != "Bob" {
+		t.Errorf("Expected Bob, got %v, %v", r, err)
+	}
+
+	dst := make([]removed, 2)
+	err := GetMultiWithOptions(c, []*Key{k, NewKey(c, "Person", "none", 0, nil)}, dst, ignore)
+	me, ok := err.(appengine.MultiError)
+	if !ok || me[0] != nil || me[1] != ErrNoSuchEntity || dst[0].Name != "Bob" {
+		t.Errorf("Expected [nil ErrNoSuchEntity], got %v", err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func TestTransactionEntityGroups(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	e := &struct{}{}
+	root := NewKey(c, "G", "root", 0, nil)
+	err := RunInTransaction(c, func(tc appengine.Context) error {
+		// Same group: the root and its children.
+		keys := []*Key{root, NewKey(c, "G", "child", 0, root)}
+		_, err := PutMulti(tc, keys, []interface{}{e, e})
+		return err
+	}, nil)
+	if err != nil {
+		t.Errorf("Expected single group transaction to succeed, got %v", err)
+	}
+
+	err = RunInTransaction(c, func(tc appengine.Context) error {
+		if _, err := Put(tc, root, e); err != nil {
+			return err
+		}
+		_, err := Put(tc, NewKey(c, "G", "other", 0, nil), e)
+		return err
+	}, nil)
+	if err != ErrTooManyEntityGroups {
+		t.Errorf("Expected ErrTooManyEntityGroups without XG, got %v", err)
+	}
+
+	// With XG, exceeding the limit fails on the first key past it.
+	put := 0
+	err = RunInTransaction(c, func(tc appengine.Context) error {
+		for i := 0; i <= MaxEntityGroups; i++ {
+			if _, err := Put(tc, NewKey(c, "G", fmt.Sprintf("g%d", i), 0, nil), e); err != nil {
+				return err
+			}
+			put++
+		}
+		return nil
+	}, &TransactionOptions{XG: true})
+	if err != ErrTooManyEntityGroups || put != MaxEntityGroups {
+		t.Errorf("Expected ErrTooManyEntityGroups after %d groups, got %v after %d",
+			MaxEntityGroups, err, put)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func TestFilterIn(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type tagged struct {
+		Tags []string
+		Rank int64
+	}
+	keys := []*Key{
+		NewKey(c, "T", "a", 0, nil),
+		NewKey(c, "T", "b", 0, nil),
+		NewKey(c, "T", "c", 0, nil),
+		NewKey(c, "T", "d", 0, nil),
+	}
+	entities := []interface{}{
+		&tagged{[]string{"x"}, 3},
+		&tagged{[]string{"x", "y"}, 1},
+		&tagged{[]string{"y"}, 2},
+		&tagged{[]string{"z"}, 0},
+	}
+	if _, err := PutMulti(c, keys, entities); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	// "b" matches both sub-queries, but must be returned once.
+	var dst []tagged
+	got, err := NewQuery("T").FilterIn("Tags", "x", "y").Order("Rank").GetAll(c, &dst)
+	if err != nil {
+		t.Fatalf("Error on GetAll(): %v", err)
+	}
+	want := []string{"b", "c", "a"}
+	if len(got) != len(want) || len(dst) != len(want) {
+		t.Fatalf("Expected %d results, got %d keys and %d entities", len(want), len(got), len(dst))
+	}
+	for i, id := range want {
+		if got[i].StringID() != id {
+			t.Errorf("Expected key %q at %d, got %q", id, i, got[i].StringID())
+		}
+	}
+	if dst[0].Rank != 1 || dst[1].Rank != 2 || dst[2].Rank != 3 {
+		t.Errorf("Entities not loaded in order: %v", dst)
+	}
+
+	q := NewQuery("T").FilterIn("Tags", "y", "x").Order("-Rank").Offset(1).Limit(1).KeysOnly(true)
+	if got, err = q.GetAll(c, nil); err != nil {
+		t.Fatalf("Error on GetAll(): %v", err)
+	}
+	if len(got) != 1 || got[0].StringID() != "c" {
+		t.Errorf("Expected [c], got %v", got)
+	}
+
+	if n, err := NewQuery("T").FilterIn("Tags", "x", "y", "z").Count(c); err != nil || n != 4 {
+		t.Errorf("Expected count 4, got %v, %v", n, err)
+	}
+
+	values := make([]interface{}, MaxFilterInValues+1)
+	if _, err = NewQuery("T").FilterIn("Tags", values...).GetAll(c, &dst); err == nil {
+		t.Errorf("Expected error for %d values", len(values))
+	}
+}
+
+func TestFilterInOperator(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type order struct {
+		Status string
+	}
+	statuses := []string{"new", "paid", "sent", "done", "paid"}
+	keys := make([]*Key, len(statuses))
+	entities := make([]order, len(statuses))
+	for i, status := range statuses {
+		keys[i] = NewKey(c, "Order", "", int64(i+1), nil)
+		entities[i] = order{status}
+	}
+	if _, err := PutMulti(c, keys, entities); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	var dst []order
+	got, err := NewQuery("Order").Filter("Status IN", []string{"new", "paid", "sent"}).
+		Order("__key__").GetAll(c, &dst)
+	if err != nil {
+		t.Fatalf("Error on GetAll(): %v", err)
+	}
+	want := []int64{1, 2, 3, 5}
+	if len(got) != len(want) || len(dst) != len(want) {
+		t.Fatalf("Expected %d results, got %d keys and %d entities", len(want), len(got), len(dst))
+	}
+	for i, id := range want {
+		if got[i].IntID() != id || dst[i].Status != statuses[id-1] {
+			t.Errorf("Expected %d at %d, got %d %v", id, i, got[i].IntID(), dst[i])
+		}
+	}
+
+	if n, err := NewQuery("Order").Filter("Status in", []interface{}{"done"}).Count(c); err != nil || n != 1 {
+		t.Errorf("Expected count 1, got %v, %v", n, err)
+	}
+	if _, err := NewQuery("Order").Filter("Status IN", "new").GetAll(c, &dst); err == nil {
+		t.Errorf("Expected error for IN filter without a slice")
+	}
+}
+
+func TestProjection(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type item struct {
+		Name string
+		Rank int64
+		Note string
+	}
+	keys := []*Key{
+		NewKey(c, "P", "a", 0, nil),
+		NewKey(c, "P", "b", 0, nil),
+	}
+	entities := []item{{"a", 2, "note a"}, {"b", 1, "note b"}}
+	if _, err := PutMulti(c, keys, entities); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	var dst []item
+	q := NewQuery("P").Project("Name", "Rank").Order("Rank")
+	if _, err := q.GetAll(c, &dst); err != nil {
+		t.Fatalf("Error on GetAll(): %v", err)
+	}
+	want := []item{{"b", 1, ""}, {"a", 2, ""}}
+	if len(dst) != len(want) || dst[0] != want[0] || dst[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, dst)
+	}
+
+	// aliases and iterators
+	var e item
+	q = NewQuery("P").SetPropertyAliases(map[string]string{"n": "Note"}).
+		Project("n").Filter("Name =", "a")
+	if _, err := q.Run(c).Next(&e); err != nil || e != (item{Note: "note a"}) {
+		t.Errorf("Expected only Note loaded, got %v, %v", e, err)
+	}
+	if n, err := q.Count(c); err != nil || n != 1 {
+		t.Errorf("Expected count 1, got %v, %v", n, err)
+	}
+
+	dst = nil
+	q = NewQuery("P").FilterIn("Name", "a", "b").Project("Rank").Order("-Rank")
+	if _, err := q.GetAll(c, &dst); err != nil {
+		t.Fatalf("Error on GetAll(): %v", err)
+	}
+	if len(dst) != 2 || dst[0] != (item{Rank: 2}) || dst[1] != (item{Rank: 1}) {
+		t.Errorf("Expected only Rank loaded with FilterIn, got %v", dst)
+	}
+
+	if _, err := NewQuery("P").Project("Name").KeysOnly(true).GetAll(c, nil); err == nil {
+		t.Errorf("Expected error for keys-only projection")
+	}
+	if _, err := NewQuery("P").Project("Name", "Name").GetAll(c, &dst); err == nil {
+		t.Errorf("Expected error for duplicate projected property")
+	}
+}
+
+// warnContext counts the warnings logged through it.
+type warnContext struct {
+	appengine.Context
+	warnings int
+}
+
+func (c *warnContext) Warningf(format string, args ...interface{}) {
+	c.warnings++
+}
+
+func TestDefaultQueryLimit(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type item struct {
+		N int64
+	}
+	keys := make([]*Key, 5)
+	entities := make([]interface{}, len(keys))
+	for i := range keys {
+		keys[i] = NewKey(c, "L", "", int64(i+1), nil)
+		entities[i] = &item{int64(i)}
+	}
+	if _, err := PutMulti(c, keys, entities); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	if err := SetDefaultQueryLimit(2); err != nil {
+		t.Fatalf("Error on SetDefaultQueryLimit(): %v", err)
+	}
+	defer SetDefaultQueryLimit(0)
+
+	wc := &warnContext{Context: c}
+	var dst []item
+	if got, err := NewQuery("L").GetAll(wc, &dst); err != nil || len(got) != 2 {
+		t.Errorf("Expected 2 results with the default limit, got %d, %v", len(got), err)
+	}
+	if wc.warnings != 1 {
+		t.Errorf("Expected a warning when the default limit is applied, got %d", wc.warnings)
+	}
+	n := 0
+	for it := NewQuery("L").KeysOnly(true).Run(wc); ; n++ {
+		if _, err := it.Next(nil); err == Done {
+			break
+		} else if err != nil {
+			t.Fatalf("Error on Run(): %v", err)
+		}
+	}
+	if n != 2 {
+		t.Errorf("Expected Run to return 2 results with the default limit, got %d", n)
+	}
+
+	wc.warnings = 0
+	if got, err := NewQuery("L").Limit(3).KeysOnly(true).GetAll(wc, nil); err != nil || len(got) != 3 {
+		t.Errorf("Expected 3 results with an explicit limit, got %d, %v", len(got), err)
+	}
+	if got, err := NewQuery("L").Unlimited().KeysOnly(true).GetAll(wc, nil); err != nil || len(got) != 5 {
+		t.Errorf("Expected 5 results for an unlimited query, got %d, %v", len(got), err)
+	}
+	if wc.warnings != 0 {
+		t.Errorf("Expected no warnings for limited and unlimited queries, got %d", wc.warnings)
+	}
+
+	// a large limit isn't allocated upfront
+	dst = nil
+	if got, err := NewQuery("L").Limit(1<<20).GetAll(c, &dst); err != nil || len(got) != 5 {
+		t.Errorf("Expected 5 results with a large limit, got %d, %v", len(got), err)
+	}
+	if cap(dst) > getAllChunkSize {
+		t.Errorf("Expected at most %d elements allocated, got %d", getAllChunkSize, cap(dst))
+	}
+
+	if err := SetDefaultQueryLimit(-1); err == nil {
+		t.Errorf("Expected error for a negative default limit")
+	}
+}
+
+// mapCache is a QueryCache counting hits and misses.
+type mapCache struct {
+	values       map[string][]byte
+	hits, misses int
+}
+
+func (m *mapCache) Get(c appengine.Context, key string) ([]byte, error) {
+	if v, ok := m.values[key]; ok {
+		m.hits++
+		return v, nil
+	}
+	m.misses++
+	return nil, ErrCacheMiss
+}
+
+func (m *mapCache) Set(c appengine.Context, key string, value []byte, ttl time.Duration) error {
+	m.values[key] = value
+	return nil
+}
+
+func (m *mapCache) Delete(c appengine.Context, key string) error {
+	delete(m.values, key)
+	return nil
+}
+
+func TestRunCached(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type category struct {
+		Name string
+	}
+	keys := []*Key{NewKey(c, "Category", "a", 0, nil), NewKey(c, "Category", "b", 0, nil)}
+	if _, err := PutMulti(c, keys, []category{{"A"}, {"B"}}); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+
+	cache := &mapCache{values: make(map[string][]byte)}
+	run := func(q *Query) ([]*Key, []category) {
+		var dst []category
+		got, err := q.RunCached(c, cache, time.Minute, &dst)
+		if err != nil {
+			t.Fatalf("Error on RunCached(): %v", err)
+		}
+		return got, dst
+	}
+	q := NewQuery("Category").Order("Name")
+	if got, dst := run(q); len(got) != 2 || len(dst) != 2 || dst[0].Name != "A" || dst[1].Name != "B" {
+		t.Fatalf("Expected [A B] on miss, got %v, %v", got, dst)
+	}
+	if cache.misses != 1 || cache.hits != 0 {
+		t.Errorf("Expected 1 miss, got %d misses and %d hits", cache.misses, cache.hits)
+	}
+
+	// Writes don't invalidate: an equal query is served stale results.
+	if _, err := Put(c, keys[0], &category{"C"}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	got, dst := run(NewQuery("Category").Order("Name"))
+	if cache.hits != 1 {
+		t.Errorf("Expected a cache hit, got %d", cache.hits)
+	}
+	if len(got) != 2 || !got[0].Equal(keys[0]) || len(dst) != 2 || dst[0].Name != "A" {
+		t.Errorf("Expected cached [A B], got %v, %v", got, dst)
+	}
+
+	// A different query has its own results.
+	if got, err := NewQuery("Category").KeysOnly(true).RunCached(c, cache, time.Minute, nil); err != nil || len(got) != 2 {
+		t.Errorf("Expected 2 keys, got %v, %v", got, err)
+	}
+	if cache.misses != 2 {
+		t.Errorf("Expected a cache miss for keys-only query, got %d misses", cache.misses)
+	}
+
+	if err := q.InvalidateCached(c, cache); err != nil {
+		t.Fatalf("Error on InvalidateCached(): %v", err)
+	}
+	if _, dst := run(q); len(dst) != 2 || dst[0].Name != "B" || dst[1].Name != "C" {
+		t.Errorf("Expected [B C] after invalidation, got %v", dst)
+	}
+}
+
+func TestGetMultiInto(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type user struct {
+		Name string
+	}
+	type org struct {
+		Title string
+		Size  int64
+	}
+	userKey := NewKey(c, "User", "u", 0, nil)
+	orgKey := NewKey(c, "Org", "o", 0, nil)
+	if _, err := Put(c, userKey, &user{"bob"}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	if _, err := Put(c, orgKey, &org{"acme", 3}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+
+	u, o, props := new(user), new(org), new(PropertyList)
+	keys := []*Key{userKey, orgKey, orgKey, NewKey(c, "User", "missing", 0, nil), userKey}
+	dst := []interface{}{u, o, props, new(user), user{}}
+	err := GetMultiInto(c, keys, dst)
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		t.Fatalf("Expected MultiError, got %v", err)
+	}
+	for i, want := range []error{nil, nil, nil, ErrNoSuchEntity, ErrInvalidEntityType} {
+		if me[i] != want {
+			t.Errorf("Expected error %v at %d, got %v", want, i, me[i])
+		}
+	}
+	if u.Name != "bob" || o.Title != "acme" || o.Size != 3 || len(*props) != 2 {
+		t.Errorf("Entities not loaded: %v, %v, %v", u, o, props)
+	}
+
+	if err := GetMultiInto(c, keys[:2], []interface{}{new(user), new(org)}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	defer keepEncryptionKey()()
+	load := func(conf string) error {
+		f, err := ioutil.TempFile("", "server.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(conf)
+		f.Close()
+		return LoadEncryptionKey(f.Name())
+	}
+
+	if err := SetEncryptionKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatalf("Error on SetEncryptionKey(): %v", err)
+	}
+	gcm := encryptionAEAD()
+	if err := load("[default]\naddr = :8000\n"); err != nil || encryptionAEAD() != gcm {
+		t.Errorf("Expected key to be kept without encryption-key, got %v", err)
+	}
+	ciphertext, _ := encryptValue(nil, "Secret", []byte("secret"))
+
+	if err := load("[datastore]\nencryption-key = fedcba9876543210\n"); err != nil || encryptionAEAD() == gcm {
+		t.Fatalf("Expected key to be set from config, got %v", err)
+	}
+	if _, err := decryptValue(nil, "Secret", ciphertext); err != errCiphertext {
+		t.Errorf("Expected the configured key to be used, got %v", err)
+	}
+	if err := load("[datastore]\nencryption-key = short\n"); err == nil {
+		t.Errorf("Expected error for invalid key length")
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+	defer keepEncryptionKey()()
+
+	type item struct {
+		Name string
+		Qty  int
+	}
+	type Order struct {
+		Ref   string
+		Attrs map[string]int `datastore:",json"`
+		Items []item         `datastore:"items,json"`
+		Notes []string       `datastore:",json,encrypt"`
+	}
+	if err := SetEncryptionKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatalf("Error on SetEncryptionKey(): %v", err)
+	}
+
+	pc := &putContext{Context: c}
+	k := NewKey(c, "Order", "o1", 0, nil)
+	src := &Order{
+		Ref:   "o1",
+		Attrs: map[string]int{"a": 1, "b": 2},
+		Items: []item{{"apple", 3}, {"pear", 1}},
+		Notes: []string{"leave at door"},
+	}
+	if _, err := Put(pc, k, src); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	raw, err := proto.Marshal(pc.puts[0])
+	if err != nil {
+		t.Fatalf("Error marshaling PutRequest: %v", err)
+	}
+	if !bytes.Contains(raw, []byte(`{"a":1,"b":2}`)) {
+		t.Errorf("Expected Attrs stored as JSON")
+	}
+	if bytes.Contains(raw, []byte("leave at door")) {
+		t.Errorf("Stored entity contains plaintext of encrypted JSON field")
+	}
+
+	// loading replaces, instead of merging into, existing values
+	dst := &Order{Attrs: map[string]int{"stale": 1}}
+	if err := Get(c, k, dst); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if dst.Ref != "o1" || len(dst.Attrs) != 2 || dst.Attrs["a"] != 1 || dst.Attrs["b"] != 2 {
+		t.Errorf("Expected map round-trip, got %v", dst.Attrs)
+	}
+	if len(dst.Items) != 2 || dst.Items[0] != (item{"apple", 3}) || dst.Items[1] != (item{"pear", 1}) {
+		t.Errorf("Expected slice of structs round-trip, got %v", dst.Items)
+	}
+	if len(dst.Notes) != 1 || dst.Notes[0] != "leave at door" {
+		t.Errorf("Expected encrypted JSON round-trip, got %v", dst.Notes)
+	}
+}
+
+func TestStringSliceFields(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type Post struct {
+		Tags  []string
+		Notes []string `datastore:",noindex"`
+	}
+	k := NewKey(c, "Post", "p1", 0, nil)
+	src := &Post{Tags: []string{"go", "appengine", "datastore"}, Notes: []string{"b", "a"}}
+	if _, err := Put(c, k, src); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	// loading replaces, instead of appending to, existing values
+	dst := &Post{Tags: []string{"stale"}}
+	if err := Get(c, k, dst); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("Expected %v, got %v", src, dst)
+	}
+
+	k = NewKey(c, "Post", "p2", 0, nil)
+	if _, err := Put(c, k, &Post{Tags: []string{}}); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	dst = new(Post)
+	if err := Get(c, k, dst); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if len(dst.Tags) != 0 || len(dst.Notes) != 0 {
+		t.Errorf("Expected empty slices, got %v", dst)
+	}
+}
+
+func TestUnsignedFields(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type Counter struct {
+		ID    uint64
+		Count uint
+		Small uint8
+	}
+	k := NewKey(c, "Counter", "c1", 0, nil)
+	src := &Counter{math.MaxInt64, math.MaxInt64 - 1, math.MaxUint8}
+	if _, err := Put(c, k, src); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	dst := new(Counter)
+	if err := Get(c, k, dst); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if *dst != *src {
+		t.Errorf("Expected %v, got %v", src, dst)
+	}
+	var found []Counter
+	if _, err := NewQuery("Counter").Filter("ID =", uint64(math.MaxInt64)).GetAll(c, &found); err != nil || len(found) != 1 {
+		t.Errorf("Expected to filter on an unsigned value, got %v, %v", found, err)
+	}
+
+	if _, err := Put(c, k, &Counter{ID: math.MaxInt64 + 1}); err == nil {
+		t.Errorf("Expected error saving a value over math.MaxInt64")
+	}
+
+	for _, props := range []PropertyList{
+		{{Name: "ID", Value: int64(-1)}},
+		{{Name: "Small", Value: int64(math.MaxUint8 + 1)}},
+	} {
+		if _, err := Put(c, k, &props); err != nil {
+			t.Fatalf("Error on Put(): %v", err)
+		}
+		if err := Get(c, k, dst); err == nil {
+			t.Errorf("Expected overflow loading %v", props)
+		} else if _, ok := err.(*ErrFieldMismatch); !ok {
+			t.Errorf("Expected ErrFieldMismatch loading %v, got %v", props, err)
+		}
+	}
+}
+
+func TestNestedStructs(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type Point struct {
+		Lat, Lng float64
+	}
+	type Address struct {
+		Street string `datastore:"street,noindex"`
+		City   string
+		Geo    Point
+		Tags   []string
+	}
+	type Person struct {
+		Name string
+		Home Address `datastore:"home"`
+		Work Address `datastore:",noindex"`
+	}
+
+	k := NewKey(c, "Person", "p1", 0, nil)
+	src := &Person{
+		Name: "Ann",
+		Home: Address{"1 Main St", "Springfield", Point{1.5, -2.5}, []string{"a", "b"}},
+		Work: Address{City: "Shelbyville", Geo: Point{3, 4}},
+	}
+	if _, err := Put(c, k, src); err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	dst := new(Person)
+	if err := Get(c, k, dst); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	if dst.Name != src.Name || dst.Home.Street != src.Home.Street ||
+		dst.Home.City != src.Home.City || dst.Home.Geo != src.Home.Geo ||
+		len(dst.Home.Tags) != 2 || dst.Home.Tags[1] != "b" || dst.Work.City != src.Work.City ||
+		dst.Work.Geo != src.Work.Geo {
+		t.Errorf("Expected %+v, got %+v", src, dst)
+	}
+
+	var props PropertyList
+	if err := Get(c, k, &props); err != nil {
+		t.Fatalf("Error on Get(): %v", err)
+	}
+	noIndex := map[string]bool{
+		"Name":         false,
+		"home.street":  true,
+		"home.City":    false,
+		"home.Geo.Lat": false,
+		"home.Geo.Lng": false,
+		"home.Tags":    false,
+		"Work.street":  true,
+		"Work.City":    true,
+		"Work.Geo.Lat": true,
+		"Work.Geo.Lng": true,
+	}
+	for _, p := range props {
+		want, ok := noIndex[p.Name]
+		if !ok {
+			t.Errorf("Unexpected property %q", p.Name)
+		} else if p.NoIndex != want {
+			t.Errorf("Expected NoIndex %v for %q, got %v", want, p.Name, p.NoIndex)
+		}
+	}
+
+	var people []Person
+	if _, err := NewQuery("Person").Filter("home.City =", "Springfield").GetAll(c, &people); err != nil {
+		t.Fatalf("Error on GetAll(): %v", err)
+	}
+	if len(people) != 1 || people[0].Home.Geo.Lng != -2.5 {
+		t.Errorf("Expected to query on a nested field, got %+v", people)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
 func getKeyMap(t *testing.T, iter *Iterator) map[string]*Key {
 	m := make(map[string]*Key)
 	for {
@@ -250,3 +1290,179 @@ func TestCursor(t *testing.T) {
 	}
 }
 */
+
+func TestKeyPath(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	org := NewKey(c, "Org", "acme", 0, nil)
+	team := NewKey(c, "Team", "", 42, org)
+	project := NewKey(c, "Project", "gwp", 0, team)
+	k := KeyPath(c).Add("Org", "acme", 0).Add("Team", "", 42).Add("Project", "gwp", 0).Build()
+	if k == nil || k.Encode() != project.Encode() {
+		t.Errorf("Expected %v, got %v", project, k)
+	}
+
+	// the leaf may be incomplete
+	k = KeyPath(c).Add("Org", "acme", 0).Add("Team", "", 0).Build()
+	if k == nil || k.Encode() != NewIncompleteKey(c, "Team", org).Encode() {
+		t.Errorf("Expected incomplete leaf to be built, got %v", k)
+	}
+
+	// ancestors may not
+	if k = KeyPath(c).Add("Org", "", 0).Add("Team", "", 42).Build(); k != nil {
+		t.Errorf("Expected nil key for incomplete ancestor, got %v", k)
+	}
+	if k = KeyPath(c).Build(); k != nil {
+		t.Errorf("Expected nil key for empty path, got %v", k)
+	}
+	if k = KeyPath(c).Add("", "acme", 0).Build(); k != nil {
+		t.Errorf("Expected nil key for empty kind, got %v", k)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+
+	type T struct{ N int64 }
+	keys := make([]*Key, 5)
+	for i := range keys {
+		keys[i] = NewKey(c, "Loader", "", int64(i+1), nil)
+	}
+	if _, err := PutMulti(c, keys, []T{{1}, {2}, {3}, {4}, {5}}); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+	absent := NewKey(c, "Loader", "absent", 0, nil)
+
+	// explicit flush, with a repeated and an absent key
+	gc := &getKeysContext{Context: c}
+	l := NewLoader(gc, 0)
+	load := append(keys, keys[0], absent)
+	dst := make([]T, len(load))
+	results := make([]*LoadResult, len(load))
+	for i, k := range load {
+		results[i] = l.Load(k, &dst[i])
+	}
+	if len(gc.sent) != 0 {
+		t.Errorf("Expected no Get call before Wait, got %v", gc.sent)
+	}
+	for i, r := range results {
+		err := r.Wait()
+		if load[i] == absent {
+			if err != ErrNoSuchEntity {
+				t.Errorf("%d: expected ErrNoSuchEntity, got %v", i, err)
+			}
+		} else if err != nil || dst[i].N != load[i].IntID() {
+			t.Errorf("%d: expected N=%d, got %d, %v", i, load[i].IntID(), dst[i].N, err)
+		}
+	}
+	if len(gc.sent) != 1 || gc.sent[0] != 6 {
+		t.Errorf("Expected one Get call with 6 keys, got %v", gc.sent)
+	}
+
+	// time window, waited for from concurrent goroutines
+	gc = &getKeysContext{Context: c}
+	l = NewLoader(gc, 20*time.Millisecond)
+	dst = make([]T, len(keys))
+	errc := make(chan error, len(keys))
+	for i := range keys {
+		go func(r *LoadResult) {
+			errc <- r.Wait()
+		}(l.Load(keys[i], &dst[i]))
+	}
+	for _ = range keys {
+		if err := <-errc; err != nil {
+			t.Errorf("Error on Wait(): %v", err)
+		}
+	}
+	for i := range keys {
+		if dst[i].N != int64(i+1) {
+			t.Errorf("%d: expected N=%d, got %d", i, i+1, dst[i].N)
+		}
+	}
+	if len(gc.sent) != 1 || gc.sent[0] != len(keys) {
+		t.Errorf("Expected one Get call with %d keys, got %v", len(keys), gc.sent)
+	}
+
+	// Flush sends early, later Loads go in a new batch
+	l.Load(keys[0], new(T))
+	l.Flush()
+	if len(gc.sent) != 2 {
+		t.Errorf("Expected Flush to send the batch, got %v", gc.sent)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	c := getContext(t)
+	defer c.Close()
+	defer func() {
+		writeHooks, deleteHooks, hooksAsync = nil, nil, false
+	}()
+
+	type T struct{ N int64 }
+	var written []int64
+	deleted := 0
+	OnWrite(func(key *Key, entity interface{}) {
+		if key.Incomplete() {
+			t.Errorf("Expected complete key in write hook, got %v", key)
+		}
+		written = append(written, entity.(*T).N)
+	})
+	OnDelete(func(key *Key) {
+		deleted++
+	})
+
+	k, err := Put(c, NewIncompleteKey(c, "Hook", nil), &T{1})
+	if err != nil {
+		t.Fatalf("Error on Put(): %v", err)
+	}
+	keys := []*Key{NewKey(c, "Hook", "a", 0, nil), NewKey(c, "Hook", "b", 0, nil)}
+	if _, err := PutMulti(c, keys, []T{{2}, {3}}); err != nil {
+		t.Fatalf("Error on PutMulti(): %v", err)
+	}
+	if len(written) != 3 || written[0] != 1 || written[2] != 3 {
+		t.Errorf("Expected write hook called for 3 entities, got %v", written)
+	}
+	Delete(c, k)
+	DeleteMulti(c, keys)
+	if deleted != 3 {
+		t.Errorf("Expected delete hook called 3 times, got %d", deleted)
+	}
+
+	// transactions report writes after commit only
+	written = nil
+	RunInTransaction(c, func(tc appengine.Context) error {
+		Put(tc, keys[0], &T{4})
+		return errors.New("rollback")
+	}, nil)
+	if len(written) != 0 {
+		t.Errorf("Expected no write hook for rolled back transaction, got %v", written)
+	}
+	err = RunInTransaction(c, func(tc appengine.Context) error {
+		_, err := Put(tc, keys[0], &T{5})
+		if len(written) != 0 {
+			t.Errorf("Expected write hook to wait for commit, got %v", written)
+		}
+		return err
+	}, nil)
+	if err != nil || len(written) != 1 || written[0] != 5 {
+		t.Errorf("Expected write hook after commit, got %v, %v", written, err)
+	}
+
+	// async hooks
+	SetHooksAsync(true)
+	done := make(chan *Key, 1)
+	OnDelete(func(key *Key) {
+		done <- key
+	})
+	Delete(c, keys[0])
+	select {
+	case key := <-done:
+		if !key.Equal(keys[0]) {
+			t.Errorf("Expected %v in async hook, got %v", keys[0], key)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected async delete hook to be called")
+	}
+}
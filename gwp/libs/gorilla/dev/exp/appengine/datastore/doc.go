@@ -18,9 +18,14 @@ called an incomplete key, and does not refer to any saved entity. Putting an
 entity into the datastore under an incomplete key will cause a unique key
 to be generated for that entity, with a non-zero IntID.
 
+KeyPath builds a key with a chain of ancestors, adding them from the root
+down, instead of nesting NewKey calls.
+
 An entity's contents are a mapping from case-sensitive field names to values.
 Valid value types are:
   - signed integers (int, int8, int16, int32 and int64),
+  - unsigned integers (uint, uint8, uint16, uint32 and uint64), stored as
+    int64, so values over math.MaxInt64 can't be saved,
   - bool,
   - string,
   - float32 and float64,
@@ -29,7 +34,8 @@ Valid value types are:
   - time.Time,
   - appengine.BlobKey,
   - []byte (up to 1 megabyte in length),
-  - slices of any of the above.
+  - slices of any of the above,
+  - structs whose fields are all valid value types.
 
 The Get and Put functions load and save an entity's contents. An entity's
 contents are typically represented by a struct pointer.
@@ -66,6 +72,22 @@ GetMulti, PutMulti and DeleteMulti are batch versions of the Get, Put and
 Delete functions. They take a []*Key instead of a *Key, and may return an
 appengine.MultiError when encountering partial failure.
 
+A Loader collects Gets made one at a time, e.g. while assembling template
+data, and sends them as a single GetMulti.
+
+Deletes are idempotent: deleting a key with no stored entity is a no-op.
+DeleteIfExists and DeleteMultiCount also report what was actually removed.
+
+OnWrite and OnDelete register hooks called after entities are written or
+deleted, e.g. to keep a search index or a cache in sync. Only writes made
+with this package are reported: sessions saved by the appengine sessions
+DatastoreStore, which uses the App Engine datastore package, don't call
+the hooks.
+
+Kinds registered with RegisterSoftDelete can be soft-deleted with SoftDelete,
+which flags the entity instead of removing it. Queries for those kinds skip
+flagged entities unless Query.IncludeDeleted is set.
+
 
 Properties
 
@@ -85,7 +107,9 @@ Conceptually, any entity is saved as a sequence of properties, and is loaded
 into the destination value on a property-by-property basis. When loading into
 a struct pointer, an entity that cannot be completely represented (such as a
 missing field) will result in an ErrFieldMismatch error but it is up to the
-caller whether this error is fatal, recoverable or ignorable.
+caller whether this error is fatal, recoverable or ignorable. Repeated
+properties are loaded into slice fields in order, replacing the values the
+field had before; fields with no property in the entity are left untouched.
 
 By default, for struct pointers, all properties are potentially indexed, and
 the property name is the same as the field name (and hence must start with an
@@ -93,7 +117,12 @@ upper case letter). Fields may have a `datastore:"name,options"` tag. The tag
 name is the property name, which may start with a lower case letter. An empty
 tag name means to just use the field name. A "-" tag name means that the
 datastore will ignore that field. If options is "noindex" then the field will
-not be indexed. If the options is "" then the comma may be omitted. There are
+not be indexed. If options is "encrypt" then the field, which must be a string
+or []byte, is stored encrypted and not indexed; see SetEncryptionKey and
+LoadEncryptionKey. If options is "json" then the field, which may be of any
+type encoding/json handles, such as a map or a slice of structs, is stored as
+its JSON encoding and not indexed; combined as "json,encrypt" the encoding is
+also encrypted. If the options is "" then the comma may be omitted. There are
 no other recognized options.
 
 Example code:
@@ -113,6 +142,13 @@ Example code:
 		J int `datastore:",noindex" json:"j"`
 	}
 
+Struct fields which are themselves structs, other than time.Time, are
+flattened: a field Addr of a struct type with fields Street and City is
+saved as the properties "Addr.Street" and "Addr.City". The nested fields'
+tags are respected, and a "noindex" option on the parent field applies to
+all of them. Slices of structs are not supported, except with the "json"
+option.
+
 An entity's contents can also be represented by any type that implements the
 PropertyLoadSaver interface. This type may be a struct pointer, but it does
 not have to be. The datastore package will call LoadProperties when getting
@@ -169,6 +205,13 @@ methods. Running a query yields an iterator of results: either an iterator of
 keys or of (key, entity) pairs. Once initialized, a query can be re-used, and
 it is safe to call Query.Run from concurrent goroutines.
 
+SetDefaultQueryLimit caps queries run without a limit, to catch unbounded
+scans; queries which need every result opt out with Query.Unlimited.
+
+Query.RunCached caches results of queries which change rarely, in memcache
+or another QueryCache. Cached results are not invalidated by writes, so
+they can be stale until they expire or Query.InvalidateCached is called.
+
 Example code:
 
 	type Widget struct {
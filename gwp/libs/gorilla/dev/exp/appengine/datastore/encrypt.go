@@ -0,0 +1,174 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/scyth/go-webproject/gwp/libs/goconf"
+)
+
+var (
+	errNoEncryptionKey = errors.New("datastore: no encryption key set for encrypted field")
+	errCiphertext      = errors.New("datastore: encrypted value is invalid or the key is wrong")
+)
+
+var (
+	encryptionMu  sync.RWMutex
+	encryptionGCM cipher.AEAD
+)
+
+// SetEncryptionKey sets the key used for struct fields tagged with the
+// "encrypt" option, e.g.
+//
+//	Email string `datastore:",encrypt"`
+//
+// Such fields are encrypted with AES-GCM on save and stored as non-indexed
+// []byte properties, so they can't be used in query filters or orders. They
+// are decrypted on load. Only string and []byte fields can be encrypted.
+// Values are bound to the entity key and the property name: copied to
+// another entity or property, they fail to decrypt. Entities put with an
+// incomplete key get their ID after being encrypted, so their values are
+// bound to the kind and the parent of the key only.
+//
+// The key must be 16, 24 or 32 bytes long, to select AES-128, AES-192 or
+// AES-256. It should come from configuration or a key management service,
+// never from the source code. Entities stored with a key can only be loaded
+// with the same key.
+func SetEncryptionKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	encryptionMu.Lock()
+	defer encryptionMu.Unlock()
+	encryptionGCM = gcm
+	return nil
+}
+
+// LoadEncryptionKey sets the key for encrypted fields from the encryption-key
+// option in the [datastore] section of the config file, usually server.conf:
+//
+//	[datastore]
+//	encryption-key = replace-with-32-random-bytes-now
+//
+// If the option is not set, the key is left as it is, e.g. to be set with
+// SetEncryptionKey from a key management service.
+func LoadEncryptionKey(configPath string) error {
+	c, err := goconf.ReadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	if !c.HasOption("datastore", "encryption-key") {
+		return nil
+	}
+	key, err := c.GetString("datastore", "encryption-key")
+	if err != nil {
+		return err
+	}
+	if err := SetEncryptionKey([]byte(key)); err != nil {
+		return errors.New("datastore: invalid encryption-key: " + err.Error())
+	}
+	return nil
+}
+
+// encryptionAEAD returns the cipher set with SetEncryptionKey, or nil.
+func encryptionAEAD() cipher.AEAD {
+	encryptionMu.RLock()
+	defer encryptionMu.RUnlock()
+	return encryptionGCM
+}
+
+// sealed is the plaintext of an encrypted property. It is encrypted when
+// the entity is saved, as the entity key is needed.
+type sealed []byte
+
+// sealValue returns the plaintext of a string or []byte field value, to be
+// encrypted.
+func sealValue(v reflect.Value) (sealed, error) {
+	switch {
+	case v.Kind() == reflect.String:
+		return sealed(v.String()), nil
+	case v.Type() == typeOfByteSlice:
+		return sealed(v.Bytes()), nil
+	}
+	return nil, errors.New("datastore: encrypt option is not supported for field type " + v.Type().String())
+}
+
+// encryptionAAD returns the data authenticated along with an encrypted
+// value, so that the value can't be moved to another entity or property.
+func encryptionAAD(key *Key, name string) []byte {
+	return []byte(key.String() + "\x00" + name)
+}
+
+// encryptValue encrypts the value of the property name of the entity key.
+// The random nonce is prepended to the result.
+func encryptValue(key *Key, name string, plain []byte) ([]byte, error) {
+	gcm := encryptionAEAD()
+	if gcm == nil {
+		return nil, errNoEncryptionKey
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, encryptionAAD(key, name)), nil
+}
+
+// decryptValue decrypts a value produced by encryptValue. Entities put
+// with an incomplete key got their ID only after being encrypted, so their
+// values are bound to the kind and the parent only.
+func decryptValue(key *Key, name string, ciphertext []byte) ([]byte, error) {
+	gcm := encryptionAEAD()
+	if gcm == nil {
+		return nil, errNoEncryptionKey
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errCiphertext
+	}
+	n := gcm.NonceSize()
+	plain, err := gcm.Open(nil, ciphertext[:n], ciphertext[n:], encryptionAAD(key, name))
+	if err != nil && key != nil && !key.Incomplete() {
+		incomplete := *key
+		incomplete.stringID, incomplete.intID = "", 0
+		plain, err = gcm.Open(nil, ciphertext[:n], ciphertext[n:], encryptionAAD(&incomplete, name))
+	}
+	if err != nil {
+		return nil, errCiphertext
+	}
+	return plain, nil
+}
+
+// loadEncrypted decrypts p into the string or []byte field v. It returns a
+// reason string on failure, like loadProperty.
+func loadEncrypted(p Property, v reflect.Value) string {
+	x, ok := p.Value.([]byte)
+	if !ok {
+		return typeMismatchReason(p, v)
+	}
+	plain, err := decryptValue(p.key, p.Name, x)
+	if err != nil {
+		return err.Error()
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(plain))
+	case v.Type() == typeOfByteSlice:
+		v.SetBytes(plain)
+	default:
+		return "encrypt option is not supported for field type " + v.Type().String()
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"sync"
+
+	"appengine"
+)
+
+var (
+	hooksMu     sync.RWMutex
+	writeHooks  []func(key *Key, entity interface{})
+	deleteHooks []func(key *Key)
+	hooksAsync  bool
+)
+
+// OnWrite registers fn to be called for every entity saved with Put or
+// PutMulti, e.g. to update a search index or invalidate a cache. fn gets
+// the complete key and the src the entity was saved from.
+//
+// Hooks are called after the write succeeded. Writes made in a transaction
+// are reported after the transaction is committed, and not at all if it
+// is rolled back.
+func OnWrite(fn func(key *Key, entity interface{})) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	writeHooks = append(writeHooks, fn)
+}
+
+// OnDelete registers fn to be called for every key deleted with Delete or
+// DeleteMulti, like OnWrite. Keys without a stored entity are reported too,
+// as the datastore doesn't tell them apart.
+func OnDelete(fn func(key *Key)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	deleteHooks = append(deleteHooks, fn)
+}
+
+// SetHooksAsync sets whether hooks run in a separate goroutine, so slow
+// hooks don't delay the caller. By default they run before Put, Delete or
+// RunInTransaction returns.
+func SetHooksAsync(async bool) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooksAsync = async
+}
+
+// afterWrite calls write hooks for the saved entities, or queues the calls
+// until commit when c is a transaction.
+func afterWrite(c appengine.Context, key []*Key, src []interface{}) {
+	hooksMu.RLock()
+	hooks := writeHooks
+	hooksMu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	runHooks(c, func() {
+		for i, k := range key {
+			for _, fn := range hooks {
+				fn(k, src[i])
+			}
+		}
+	})
+}
+
+// afterDelete calls delete hooks for the deleted keys, like afterWrite.
+func afterDelete(c appengine.Context, key []*Key) {
+	hooksMu.RLock()
+	hooks := deleteHooks
+	hooksMu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	runHooks(c, func() {
+		for _, k := range key {
+			for _, fn := range hooks {
+				fn(k)
+			}
+		}
+	})
+}
+
+// runHooks runs call now, or after commit when c is a transaction.
+func runHooks(c appengine.Context, call func()) {
+	if t, ok := c.(*transaction); ok {
+		t.hooks = append(t.hooks, call)
+		return
+	}
+	hooksMu.RLock()
+	async := hooksAsync
+	hooksMu.RUnlock()
+	if async {
+		go call()
+	} else {
+		call()
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// saveJSON returns the JSON encoding of the struct field v, for fields
+// tagged with the "json" option. It is sealed to be encrypted if encrypt is
+// set.
+func saveJSON(v reflect.Value, encrypt bool) (interface{}, error) {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if encrypt {
+		return sealed(data), nil
+	}
+	return data, nil
+}
+
+// loadJSON decodes the property p into the struct field v, for fields
+// tagged with the "json" option. It returns the reason for failure, or an
+// empty string.
+func loadJSON(p Property, v reflect.Value, encrypted bool) string {
+	data, ok := p.Value.([]byte)
+	if !ok {
+		return typeMismatchReason(p, v)
+	}
+	if encrypted {
+		var err error
+		if data, err = decryptValue(p.key, p.Name, data); err != nil {
+			return err.Error()
+		}
+	}
+	// Start from the zero value, so maps and slices are not merged into.
+	x := reflect.New(v.Type())
+	if err := json.Unmarshal(data, x.Interface()); err != nil {
+		return err.Error()
+	}
+	v.Set(x.Elem())
+	return ""
+}
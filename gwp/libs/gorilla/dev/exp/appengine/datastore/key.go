@@ -285,3 +285,43 @@ func NewNamespaceKey(c appengine.Context, kind, stringID string, intID int64, pa
 	k.namespace = namespace
 	return k
 }
+
+// KeyPathBuilder builds a key from its ancestor path, see KeyPath.
+type KeyPathBuilder struct {
+	c       appengine.Context
+	key     *Key
+	invalid bool
+}
+
+// KeyPath returns a builder for a key with a chain of ancestors, added from
+// the root down:
+//
+//	k := datastore.KeyPath(c).
+//		Add("Org", "acme", 0).
+//		Add("Team", "", 42).
+//		Add("Project", "", 0).
+//		Build()
+//
+// is the same as nesting NewKey calls, with the root key innermost.
+func KeyPath(c appengine.Context) *KeyPathBuilder {
+	return &KeyPathBuilder{c: c}
+}
+
+// Add appends an element to the path. The arguments are the same as for
+// NewKey. Only the last element may be incomplete.
+func (b *KeyPathBuilder) Add(kind, stringID string, intID int64) *KeyPathBuilder {
+	if b.key != nil && b.key.Incomplete() {
+		b.invalid = true
+	}
+	b.key = NewKey(b.c, kind, stringID, intID, b.key)
+	return b
+}
+
+// Build returns the key for the path, or nil if the path is empty or
+// invalid: an ancestor is incomplete, or a kind is empty.
+func (b *KeyPathBuilder) Build() *Key {
+	if b.invalid || !b.key.valid() {
+		return nil
+	}
+	return b.key
+}
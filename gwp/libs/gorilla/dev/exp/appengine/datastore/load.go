@@ -7,6 +7,7 @@ package datastore
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	"appengine"
@@ -15,7 +16,10 @@ import (
 	pb "appengine_internal/datastore"
 )
 
-var typeOfByteSlice = reflect.TypeOf([]byte(nil))
+var (
+	typeOfByteSlice = reflect.TypeOf([]byte(nil))
+	typeOfTime      = reflect.TypeOf(time.Time{})
+)
 
 // typeMismatchReason returns a string explaining why the property p could not
 // be stored in an entity field of type v.Type().
@@ -42,10 +46,23 @@ func typeMismatchReason(p Property, v reflect.Value) string {
 	return fmt.Sprintf("type mismatch: %s versus %v", entityType, v.Type())
 }
 
-func loadProperty(codec *structCodec, structValue reflect.Value, p Property, requireSlice bool) string {
-	index, ok := codec.byName[p.Name]
+// loadProperty loads p into the struct field named name, which is p.Name
+// without the names of the structs it is nested in. Slice fields are reset
+// the first time one of their values is loaded, and then appended to:
+// loaded holds pointers to the slice fields already reset.
+func loadProperty(codec *structCodec, structValue reflect.Value, name string, p Property,
+	requireSlice bool, loaded map[interface{}]bool) string {
+	index, ok := codec.byName[name]
 	if !ok {
-		return "no such struct field"
+		// Nested struct fields are flattened as "Field.SubField".
+		i := strings.Index(name, ".")
+		if i == -1 {
+			return "no such struct field"
+		}
+		index, ok = codec.byName[name[:i]]
+		if !ok || codec.byIndex[index].substruct == nil {
+			return "no such struct field"
+		}
 	}
 	v := structValue.Field(index)
 	if !v.IsValid() {
@@ -54,9 +71,28 @@ func loadProperty(codec *structCodec, structValue reflect.Value, p Property, req
 	if !v.CanSet() {
 		return "cannot set struct field"
 	}
+	if sub := codec.byIndex[index].substruct; sub != nil {
+		if len(name) == len(codec.byIndex[index].name) {
+			return "nested struct field requires a flattened property name"
+		}
+		name = name[len(codec.byIndex[index].name)+1:]
+		return loadProperty(sub, v, name, p, requireSlice, loaded)
+	}
+	if codec.byIndex[index].json {
+		return loadJSON(p, v, codec.byIndex[index].encrypt)
+	}
+	if codec.byIndex[index].encrypt {
+		return loadEncrypted(p, v)
+	}
 	var slice reflect.Value
 	if v.Kind() == reflect.Slice && v.Type() != typeOfByteSlice {
 		slice = v
+		// Replace, instead of appending to, the values the field had
+		// before loading.
+		if ptr := slice.Addr().Interface(); !loaded[ptr] {
+			slice.Set(reflect.Zero(slice.Type()))
+			loaded[ptr] = true
+		}
 		v = reflect.New(v.Type().Elem()).Elem()
 	} else if requireSlice {
 		return "multiple-valued property requires a slice field type"
@@ -71,6 +107,15 @@ func loadProperty(codec *structCodec, structValue reflect.Value, p Property, req
 			return fmt.Sprintf("value %v overflows struct field of type %v", x, v.Type())
 		}
 		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, ok := p.Value.(int64)
+		if !ok {
+			return typeMismatchReason(p, v)
+		}
+		if x < 0 || v.OverflowUint(uint64(x)) {
+			return fmt.Sprintf("value %v overflows struct field of type %v", x, v.Type())
+		}
+		v.SetUint(uint64(x))
 	case reflect.Bool:
 		x, ok := p.Value.(bool)
 		if !ok {
@@ -150,8 +195,9 @@ func loadEntity(dst interface{}, src *pb.EntityProto) (err error) {
 
 func (s structPLS) Load(c <-chan Property) error {
 	var fieldName, reason string
+	loaded := make(map[interface{}]bool)
 	for p := range c {
-		if errStr := loadProperty(&s.codec, s.v, p, p.Multiple); errStr != "" {
+		if errStr := loadProperty(&s.codec, s.v, p.Name, p, p.Multiple, loaded); errStr != "" {
 			// We don't return early, as we try to load as many properties as possible.
 			// It is valid to load an entity into a struct that cannot fully represent it.
 			// That case returns an error, but the caller is free to ignore it.
@@ -170,6 +216,14 @@ func (s structPLS) Load(c <-chan Property) error {
 
 func protoToProperties(dst chan<- Property, errc chan<- error, src *pb.EntityProto) {
 	defer close(dst)
+	var entityKey *Key
+	if src.Key != nil {
+		var err error
+		if entityKey, err = protoToKey(src.Key); err != nil {
+			errc <- err
+			return
+		}
+	}
 	props, rawProps := src.Property, src.RawProperty
 	for {
 		var (
@@ -218,6 +272,7 @@ func protoToProperties(dst chan<- Property, errc chan<- error, src *pb.EntityPro
 			Value:    value,
 			NoIndex:  noIndex,
 			Multiple: proto.GetBool(x.Multiple),
+			key:      entityKey,
 		}
 	}
 	errc <- nil
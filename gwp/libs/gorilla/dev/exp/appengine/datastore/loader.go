@@ -0,0 +1,130 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"sync"
+	"time"
+
+	"appengine"
+)
+
+// Loader collects Gets and issues them as a single GetMulti, to avoid one
+// RPC per entity when e.g. every row of a list needs a related entity.
+// Repeated keys are fetched once.
+//
+// Without a window, a batch is sent when Flush is called, or when the
+// result of a Load is waited for. With a window, a batch is also sent when
+// the window has passed since its first Load.
+//
+// Example code:
+//
+//	l := datastore.NewLoader(c, 0)
+//	authors := make([]Author, len(posts))
+//	results := make([]*datastore.LoadResult, len(posts))
+//	for i, p := range posts {
+//		results[i] = l.Load(p.Author, &authors[i])
+//	}
+//	for _, r := range results {
+//		if err := r.Wait(); err != nil {
+//			// handle error
+//		}
+//	}
+//
+// A Loader is safe to use from concurrent goroutines.
+type Loader struct {
+	c      appengine.Context
+	window time.Duration
+	mu     sync.Mutex
+	batch  *loaderBatch
+}
+
+// loaderBatch holds the Loads sent in a single GetMulti.
+type loaderBatch struct {
+	key   []*Key
+	dst   []interface{}
+	err   []error
+	timer *time.Timer
+	done  chan struct{}
+}
+
+// LoadResult is the pending result of Loader.Load.
+type LoadResult struct {
+	l     *Loader
+	batch *loaderBatch
+	i     int
+}
+
+// NewLoader returns a Loader sending batches with c. A window of zero means
+// batches are sent only on Flush or Wait.
+func NewLoader(c appengine.Context, window time.Duration) *Loader {
+	return &Loader{c: c, window: window}
+}
+
+// Load adds the entity for key to the current batch. dst must be a struct
+// pointer or a PropertyLoadSaver, as for Get, and is loaded when the batch
+// is sent.
+func (l *Loader) Load(key *Key, dst interface{}) *LoadResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.batch
+	if b == nil {
+		b = &loaderBatch{done: make(chan struct{})}
+		if l.window > 0 {
+			b.timer = time.AfterFunc(l.window, func() { l.send(b) })
+		}
+		l.batch = b
+	}
+	b.key = append(b.key, key)
+	b.dst = append(b.dst, dst)
+	return &LoadResult{l: l, batch: b, i: len(b.key) - 1}
+}
+
+// Flush sends the current batch, if any, and waits for it to complete.
+func (l *Loader) Flush() {
+	l.mu.Lock()
+	b := l.batch
+	l.mu.Unlock()
+	if b != nil {
+		l.send(b)
+	}
+}
+
+// send issues the GetMulti for b, unless it was already sent.
+func (l *Loader) send(b *loaderBatch) {
+	l.mu.Lock()
+	if l.batch != b {
+		l.mu.Unlock()
+		<-b.done
+		return
+	}
+	l.batch = nil
+	l.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	b.err = make([]error, len(b.key))
+	err := GetMultiInto(l.c, b.key, b.dst)
+	if me, ok := err.(appengine.MultiError); ok {
+		copy(b.err, me)
+	} else if err != nil {
+		for i := range b.err {
+			b.err[i] = err
+		}
+	}
+	close(b.done)
+}
+
+// Wait waits until the entity is loaded into dst, and returns the error of
+// loading it, e.g. ErrNoSuchEntity. Without a window, it sends the batch
+// if it wasn't sent yet.
+func (r *LoadResult) Wait() error {
+	if r.l.window <= 0 {
+		r.l.send(r.batch)
+	}
+	<-r.batch.done
+	return r.batch.err[r.i]
+}
@@ -0,0 +1,372 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"appengine"
+	pb "appengine_internal/datastore"
+)
+
+// MaxFilterInValues is the maximum number of values accepted by
+// Query.FilterIn. Each value costs a separate query.
+const MaxFilterInValues = 30
+
+var errFilterInUnsupported = errors.New(
+	"datastore: queries with FilterIn only support GetAll and Count")
+
+// inFilter is an "equal to any of the values" filter, run as one query
+// per value.
+type inFilter struct {
+	property string
+	values   []interface{}
+}
+
+// FilterIn adds a filter matching entities where the field is equal to any
+// of the given values, as in "Status = A OR Status = B". It is the same as
+// Filter("Status IN", values).
+//
+// The datastore doesn't support OR, so the query runs one keys-only
+// sub-query per value, merges and de-duplicates the resulting keys and
+// loads the union with a single batch get: N values cost N+1 RPCs.
+// Orders, offset and limit are applied to the union in memory, so every
+// sub-query may fetch up to offset+limit keys. When ordering by a
+// property with multiple values, the first value is used.
+//
+// At most MaxFilterInValues values are accepted, and only one FilterIn
+// can be used per query. Queries with FilterIn only support GetAll and
+// Count.
+func (q *Query) FilterIn(field string, values ...interface{}) *Query {
+	switch {
+	case q.base.err != nil:
+	case q.in != nil:
+		q.base.err = errors.New("datastore: only one FilterIn allowed per query")
+	case len(values) == 0:
+		q.base.err = errors.New("datastore: FilterIn needs at least one value")
+	case len(values) > MaxFilterInValues:
+		q.base.err = fmt.Errorf("datastore: FilterIn accepts at most %d values, got %d",
+			MaxFilterInValues, len(values))
+	default:
+		q.in = &inFilter{property: q.propertyName(field), values: values}
+	}
+	return q
+}
+
+// inFilterProperty returns the field name of an "IN" filter, such as
+// "Status IN", and whether filter is one.
+func inFilterProperty(filter string) (string, bool) {
+	f := strings.TrimSpace(filter)
+	if i := strings.LastIndexAny(f, " \t"); i > 0 && strings.EqualFold(f[i+1:], "IN") {
+		return strings.TrimSpace(f[:i]), true
+	}
+	return "", false
+}
+
+// inFilterValues returns the elements of the slice value of an "IN"
+// filter.
+func inFilterValues(value interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, fmt.Errorf("datastore: IN filter needs a slice value, got %T", value)
+	}
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values, nil
+}
+
+// subQuery returns a keys-only copy of the query filtering on a single
+// value of the FilterIn property. Offset is dropped and limit is raised to
+// cover it, as both are applied after merging. A projection is applied to
+// the loaded entities instead.
+func (q *Query) subQuery(value interface{}) *BaseQuery {
+	pbq := *q.runnable().pbq
+	pbq.Filter = append([]*pb.Query_Filter(nil), pbq.Filter...)
+	pbq.Offset, pbq.Limit, pbq.PropertyName = nil, nil, nil
+	sub := &BaseQuery{pbq: &pbq}
+	sub.KeysOnly(true).Filter(q.in.property, QueryOperatorEqual, value)
+	if limit := proto.GetInt32(q.base.pbq.Limit); limit > 0 {
+		sub.Limit(int(proto.GetInt32(q.base.pbq.Offset) + limit))
+	}
+	return sub
+}
+
+// getAllIn is GetAll for queries with FilterIn. If keysOnly is true, dst
+// is ignored and only the keys are returned.
+func (q *Query) getAllIn(c appengine.Context, dst interface{}, keysOnly bool) ([]*Key, error) {
+	if q.base.err != nil {
+		return nil, q.base.err
+	}
+	var (
+		dv       reflect.Value
+		mat      multiArgType
+		elemType reflect.Type
+		err      error
+	)
+	if !keysOnly {
+		if dv, mat, elemType, err = getAllDst(dst); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []*Key
+	seen := make(map[string]bool)
+	for _, v := range q.in.values {
+		subKeys, err := q.subQuery(v).GetAll(c, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range subKeys {
+			if enc := k.Encode(); !seen[enc] {
+				seen[enc] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	// Entities are needed to sort the union, or to be returned.
+	orders := q.base.pbq.Order
+	var props []PropertyList
+	if !keysOnly || len(orders) > 0 {
+		props = make([]PropertyList, len(keys))
+		if err := GetMulti(c, keys, props); err != nil {
+			return nil, err
+		}
+		if len(orders) > 0 {
+			sort.Stable(&unionSorter{keys, props, orders})
+		}
+	}
+
+	lo, hi := int(proto.GetInt32(q.base.pbq.Offset)), len(keys)
+	if lo > hi {
+		lo = hi
+	}
+	if limit := int(proto.GetInt32(q.base.pbq.Limit)); limit > 0 && lo+limit < hi {
+		hi = lo + limit
+	}
+	keys = keys[lo:hi]
+	if keysOnly {
+		return keys, nil
+	}
+
+	growSlice(dv, len(keys))
+	for _, p := range props[lo:hi] {
+		if names := q.base.pbq.PropertyName; len(names) > 0 {
+			p = projectProperties(p, names)
+		}
+		load := func(dst interface{}) error {
+			return loadProperties(dst, p)
+		}
+		if err := appendEntity(dv, mat, elemType, load); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+// projectProperties returns the properties of l with one of the given
+// names.
+func projectProperties(l PropertyList, names []string) PropertyList {
+	var p PropertyList
+	for _, prop := range l {
+		for _, name := range names {
+			if prop.Name == name {
+				p = append(p, prop)
+				break
+			}
+		}
+	}
+	return p
+}
+
+// loadProperties loads a PropertyList into PropertyLoadSaver or struct
+// pointer.
+func loadProperties(dst interface{}, src PropertyList) error {
+	c := make(chan Property, 32)
+	go src.Save(c)
+	if e, ok := dst.(PropertyLoadSaver); ok {
+		return e.Load(c)
+	}
+	return LoadStruct(dst, c)
+}
+
+// ----------------------------------------------------------------------------
+// In-memory ordering
+// ----------------------------------------------------------------------------
+
+// unionSorter sorts keys and their entities by query orders.
+type unionSorter struct {
+	keys   []*Key
+	props  []PropertyList
+	orders []*pb.Query_Order
+}
+
+func (s *unionSorter) Len() int {
+	return len(s.keys)
+}
+
+func (s *unionSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.props[i], s.props[j] = s.props[j], s.props[i]
+}
+
+func (s *unionSorter) Less(i, j int) bool {
+	for _, o := range s.orders {
+		var r int
+		if name := proto.GetString(o.Property); name == "__key__" {
+			r = compareKeys(s.keys[i], s.keys[j])
+		} else {
+			r = compareValues(firstValue(s.props[i], name), firstValue(s.props[j], name))
+		}
+		if r != 0 {
+			if o.Direction != nil && *o.Direction == pb.Query_Order_DESCENDING {
+				return r > 0
+			}
+			return r < 0
+		}
+	}
+	return false
+}
+
+// firstValue returns the first value of the named property, or nil.
+func firstValue(l PropertyList, name string) interface{} {
+	for _, p := range l {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return nil
+}
+
+// valueRank returns the position of the value's type in the datastore
+// ordering: null, integers and dates, booleans, strings, floats and keys.
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, time.Time:
+		return 1
+	case bool:
+		return 2
+	case string, []byte, appengine.BlobKey:
+		return 3
+	case float64:
+		return 4
+	case *Key:
+		return 5
+	}
+	return 6
+}
+
+// compareValues returns -1, 0 or 1 if a is less than, equal to or greater
+// than b, using the datastore ordering.
+func compareValues(a, b interface{}) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		return compareInt64(int64(ra), int64(rb))
+	}
+	switch ra {
+	case 1:
+		return compareInt64(int64Value(a), int64Value(b))
+	case 2:
+		x, y := a.(bool), b.(bool)
+		if x == y {
+			return 0
+		} else if x {
+			return 1
+		}
+		return -1
+	case 3:
+		return compareString(stringValue(a), stringValue(b))
+	case 4:
+		x, y := a.(float64), b.(float64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case 5:
+		return compareKeys(a.(*Key), b.(*Key))
+	}
+	return 0
+}
+
+// compareKeys compares keys element by element from the root. Within the
+// same kind, integer IDs sort before string IDs.
+func compareKeys(a, b *Key) int {
+	pa, pb := keyPath(a), keyPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, y := pa[i], pb[i]
+		if r := compareString(x.kind, y.kind); r != 0 {
+			return r
+		}
+		switch {
+		case x.stringID == "" && y.stringID != "":
+			return -1
+		case x.stringID != "" && y.stringID == "":
+			return 1
+		}
+		if r := compareString(x.stringID, y.stringID); r != 0 {
+			return r
+		}
+		if r := compareInt64(x.intID, y.intID); r != 0 {
+			return r
+		}
+	}
+	return compareInt64(int64(len(pa)), int64(len(pb)))
+}
+
+// keyPath returns the key and its ancestors, starting from the root.
+func keyPath(k *Key) []*Key {
+	var path []*Key
+	for ; k != nil; k = k.parent {
+		path = append([]*Key{k}, path...)
+	}
+	return path
+}
+
+func int64Value(v interface{}) int64 {
+	if t, ok := v.(time.Time); ok {
+		return t.UnixNano() / 1e3
+	}
+	return v.(int64)
+}
+
+func stringValue(v interface{}) string {
+	switch x := v.(type) {
+	case []byte:
+		return string(x)
+	case appengine.BlobKey:
+		return string(x)
+	}
+	return v.(string)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareString(a, b string) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
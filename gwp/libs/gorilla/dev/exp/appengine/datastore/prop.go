@@ -50,6 +50,9 @@ type Property struct {
 	// a certain name, Multiple should be true if a struct would best represent
 	// it as a field of type []T instead of type T.
 	Multiple bool
+	// key is the key of the entity the property was loaded from, which
+	// encrypted values are authenticated with.
+	key *Key
 }
 
 // PropertyLoadSaver can be converted from and to a sequence of Properties.
@@ -114,6 +117,11 @@ func validPropertyName(s string) bool {
 type structTag struct {
 	name    string
 	noIndex bool
+	encrypt bool
+	json    bool
+	// substruct is the codec for a nested struct field, whose fields are
+	// flattened into properties named "name.FieldName".
+	substruct *structCodec
 }
 
 // structCodec describes how to convert a struct to and from a sequence of
@@ -135,6 +143,12 @@ var (
 func getStructCodec(t reflect.Type) (structCodec, error) {
 	structCodecsMutex.Lock()
 	defer structCodecsMutex.Unlock()
+	return getStructCodecLocked(t)
+}
+
+// getStructCodecLocked implements getStructCodec. The structCodecsMutex
+// must be held when calling this function.
+func getStructCodecLocked(t reflect.Type) (structCodec, error) {
 	c, ok := structCodecs[t]
 	if ok {
 		return c, nil
@@ -157,9 +171,23 @@ func getStructCodec(t reflect.Type) (structCodec, error) {
 		} else if _, ok := c.byName[name]; ok {
 			return structCodec{}, fmt.Errorf("datastore: struct tag has repeated property name: %q", name)
 		}
-		c.byIndex[i] = structTag{
-			name:    name,
-			noIndex: opts == "noindex",
+		c.byIndex[i] = structTag{name: name}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "noindex":
+				c.byIndex[i].noIndex = true
+			case "encrypt":
+				c.byIndex[i].encrypt = true
+			case "json":
+				c.byIndex[i].json = true
+			}
+		}
+		if f.PkgPath == "" && isSubstruct(f.Type) && !c.byIndex[i].json && !c.byIndex[i].encrypt {
+			sub, err := getStructCodecLocked(f.Type)
+			if err != nil {
+				return structCodec{}, err
+			}
+			c.byIndex[i].substruct = &sub
 		}
 		c.byName[name] = i
 	}
@@ -167,6 +195,12 @@ func getStructCodec(t reflect.Type) (structCodec, error) {
 	return c, nil
 }
 
+// isSubstruct returns whether fields of type t are saved as nested structs:
+// struct types other than time.Time.
+func isSubstruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != typeOfTime
+}
+
 // structPLS adapts a struct to be a PropertyLoadSaver.
 type structPLS struct {
 	v     reflect.Value
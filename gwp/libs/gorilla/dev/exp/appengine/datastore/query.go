@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"strings"
 
+	"code.google.com/p/goprotobuf/proto"
+
 	"appengine"
 )
 
@@ -20,11 +22,16 @@ func NewQuery(kind string) *Query {
 type Query struct {
 	base    *BaseQuery
 	aliases map[string]string
+	in      *inFilter
+
+	includeDeleted bool
+	unlimited      bool
 }
 
 // Clone returns a copy of the query.
 func (q *Query) Clone() *Query {
-	return &Query{base: q.base.Clone(), aliases: q.aliases}
+	return &Query{base: q.base.Clone(), aliases: q.aliases, in: q.in,
+		includeDeleted: q.includeDeleted, unlimited: q.unlimited}
 }
 
 // SetPropertyAliases sets a map of aliases for properties used in filters
@@ -67,10 +74,22 @@ func (q *Query) Kind(kind string) *Query {
 
 // Filter adds a field-based filter to the query.
 // The filterStr argument must be a field name followed by optional space,
-// followed by an operator, one of ">", "<", ">=", "<=", or "=".
+// followed by an operator, one of ">", "<", ">=", "<=", "=" or "IN".
 // Fields are compared against the provided value using the operator.
 // Multiple filters are AND'ed together.
+//
+// The "IN" operator takes a slice value, and matches entities where the
+// field is equal to any of its elements. It is the same as FilterIn: see
+// it for how these queries run and their restrictions.
 func (q *Query) Filter(filter string, value interface{}) *Query {
+	if property, ok := inFilterProperty(filter); ok {
+		values, err := inFilterValues(value)
+		if err != nil {
+			q.base.err = err
+			return q
+		}
+		return q.FilterIn(property, values...)
+	}
 	property := strings.TrimRight(filter, " ><=")
 	var operator queryOperator
 	switch strings.TrimSpace(filter[len(property):]) {
@@ -109,7 +128,8 @@ func (q *Query) Order(order string) *Query {
 }
 
 // Limit sets the maximum number of keys/entities to return.
-// A zero value means unlimited. A negative value is invalid.
+// A zero value means unlimited, unless a default limit is set with
+// SetDefaultQueryLimit. A negative value is invalid.
 func (q *Query) Limit(limit int) *Query {
 	q.base.Limit(limit)
 	return q
@@ -128,6 +148,21 @@ func (q *Query) KeysOnly(keysOnly bool) *Query {
 	return q
 }
 
+// Project configures the query to return only the given fields, read from
+// the indexes instead of loading whole entities; other fields of the
+// destination are left untouched. Calling it without names returns whole
+// entities again.
+//
+// See BaseQuery.Project for the restrictions on projections.
+func (q *Query) Project(fieldNames ...string) *Query {
+	names := make([]string, len(fieldNames))
+	for i, name := range fieldNames {
+		names[i] = q.propertyName(name)
+	}
+	q.base.Project(names...)
+	return q
+}
+
 // Compile configures the query to produce cursors.
 func (q *Query) Compile(compile bool) *Query {
 	q.base.Compile(compile)
@@ -144,7 +179,10 @@ func (q *Query) Cursor(cursor *Cursor) *Query {
 
 // Run runs the query in the given context.
 func (q *Query) Run(c appengine.Context) *Iterator {
-	return q.base.Run(c)
+	if q.in != nil {
+		return &Iterator{err: errFilterInUnsupported}
+	}
+	return q.withDefaultLimit(c, q.runnable()).Run(c)
 }
 
 // GetAll runs the query in the given context and returns all keys that match
@@ -159,22 +197,37 @@ func (q *Query) Run(c appengine.Context) *Iterator {
 //
 // If q is a ``keys-only'' query, GetAll ignores dst and only returns the keys.
 func (q *Query) GetAll(c appengine.Context, dst interface{}) ([]*Key, error) {
-	return q.base.GetAll(c, dst)
+	if q.in != nil {
+		limited := *q
+		limited.base = q.withDefaultLimit(c, q.base)
+		return limited.getAllIn(c, dst, proto.GetBool(q.base.pbq.KeysOnly))
+	}
+	return q.withDefaultLimit(c, q.runnable()).GetAll(c, dst)
 }
 
 // GetPage is the same as GetAll, but it also returns a cursor and a flag
 // indicating if there are more results.
 func (q *Query) GetPage(c appengine.Context, dst interface{}) (keys []*Key,
 	cursor *Cursor, hasMore bool, err error) {
-	return q.base.GetPage(c, dst)
+	if q.in != nil {
+		return nil, nil, false, errFilterInUnsupported
+	}
+	return q.runnable().GetPage(c, dst)
 }
 
 // Count returns the number of results for the query.
 func (q *Query) Count(c appengine.Context) (int, error) {
-	return q.base.Count(c)
+	if q.in != nil {
+		keys, err := q.getAllIn(c, nil, true)
+		return len(keys), err
+	}
+	return q.runnable().Count(c)
 }
 
 // GetCursorAt returns a cursor at the given position for this query.
 func (q *Query) GetCursorAt(c appengine.Context, position int) (*Cursor, error) {
-	return q.base.GetCursorAt(c, position)
+	if q.in != nil {
+		return nil, errFilterInUnsupported
+	}
+	return q.runnable().GetCursorAt(c, position)
 }
@@ -0,0 +1,201 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"appengine"
+	"appengine/memcache"
+	pb "appengine_internal/datastore"
+)
+
+// ErrCacheMiss is returned by QueryCache.Get when the key is not cached.
+var ErrCacheMiss = errors.New("datastore: cache miss")
+
+// QueryCache stores serialized query results for Query.RunCached.
+type QueryCache interface {
+	// Get returns the value stored for key, or ErrCacheMiss.
+	Get(c appengine.Context, key string) ([]byte, error)
+	// Set stores value for key, expiring after ttl. A zero ttl means no
+	// expiration.
+	Set(c appengine.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(c appengine.Context, key string) error
+}
+
+// MemcacheQueryCache is a QueryCache backed by memcache.
+type MemcacheQueryCache struct{}
+
+func (MemcacheQueryCache) Get(c appengine.Context, key string) ([]byte, error) {
+	item, err := memcache.Get(c, key)
+	if err == memcache.ErrCacheMiss {
+		return nil, ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+func (MemcacheQueryCache) Set(c appengine.Context, key string, value []byte, ttl time.Duration) error {
+	return memcache.Set(c, &memcache.Item{Key: key, Value: value, Expiration: ttl})
+}
+
+func (MemcacheQueryCache) Delete(c appengine.Context, key string) error {
+	if err := memcache.Delete(c, key); err != memcache.ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+// cachedResults is the serialized form of query results.
+type cachedResults struct {
+	Keys     []string // encoded keys
+	Entities [][]byte // marshaled entity protos, nil for keys-only queries
+}
+
+// RunCached is like GetAll, but results are cached for ttl. The cache key
+// is derived from the query itself, so equal queries share results.
+//
+// Cached results are not invalidated when entities of the kind are put or
+// deleted: they can be stale for up to ttl. It's meant for data which
+// changes rarely, like a list of categories, or when a stale list is
+// acceptable. InvalidateCached drops the results right away, e.g. after
+// writes the query should see. Errors talking to the cache are logged,
+// and the query is run as if nothing was cached.
+//
+// Queries with FilterIn can't be cached.
+func (q *Query) RunCached(c appengine.Context, cache QueryCache, ttl time.Duration,
+	dst interface{}) ([]*Key, error) {
+	if q.in != nil {
+		return nil, errFilterInUnsupported
+	}
+	b := q.withDefaultLimit(c, q.runnable())
+	key, err := b.cacheKey(c)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cache.Get(c, key)
+	if err == nil {
+		keys, err := loadCachedResults(data, b, dst)
+		if err == nil {
+			return keys, nil
+		}
+		c.Warningf("datastore: ignoring invalid cached query results: %v", err)
+	} else if err != ErrCacheMiss {
+		c.Warningf("datastore: query cache get failed: %v", err)
+	}
+
+	var res cachedResults
+	var keys []*Key
+	keysOnly := proto.GetBool(b.pbq.KeysOnly)
+	for t := b.Run(c); ; {
+		k, e, err := t.next()
+		if err == Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		res.Keys = append(res.Keys, k.Encode())
+		if !keysOnly {
+			data, err := proto.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			res.Entities = append(res.Entities, data)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&res); err != nil {
+		return nil, err
+	}
+	if err := cache.Set(c, key, buf.Bytes(), ttl); err != nil {
+		c.Warningf("datastore: query cache set failed: %v", err)
+	}
+	if _, err := loadCachedResults(buf.Bytes(), b, dst); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// InvalidateCached removes the results cached by RunCached for this query.
+func (q *Query) InvalidateCached(c appengine.Context, cache QueryCache) error {
+	if q.in != nil {
+		return errFilterInUnsupported
+	}
+	key, err := q.withDefaultLimit(c, q.runnable()).cacheKey(c)
+	if err != nil {
+		return err
+	}
+	return cache.Delete(c, key)
+}
+
+// cacheKey returns the key query results are cached under: a hash of the
+// marshaled query.
+func (q *BaseQuery) cacheKey(c appengine.Context) (string, error) {
+	req := *q.pbq
+	if err := q.toProto(&req, false); err != nil {
+		return "", err
+	}
+	req.App = proto.String(c.FullyQualifiedAppID())
+	data, err := proto.Marshal(&req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(data)
+	return "datastore:query:" + hex.EncodeToString(sum[:]), nil
+}
+
+// loadCachedResults decodes results cached by RunCached, appending the
+// entities to dst, unless q is keys-only.
+func loadCachedResults(data []byte, q *BaseQuery, dst interface{}) ([]*Key, error) {
+	var res cachedResults
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&res); err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, len(res.Keys))
+	for i, enc := range res.Keys {
+		k, err := DecodeKey(enc)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	if proto.GetBool(q.pbq.KeysOnly) {
+		return keys, nil
+	}
+	if len(res.Entities) != len(keys) {
+		return nil, errors.New("datastore: cached query results don't match their keys")
+	}
+
+	dv, mat, elemType, err := getAllDst(dst)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing is appended to dst unless all the entities load.
+	loaded := reflect.New(dv.Type()).Elem()
+	for _, data := range res.Entities {
+		e := new(pb.EntityProto)
+		if err := proto.Unmarshal(data, e); err != nil {
+			return nil, err
+		}
+		if err := appendEntity(loaded, mat, elemType, entityLoader(e)); err != nil {
+			return nil, err
+		}
+	}
+	dv.Set(reflect.AppendSlice(dv, loaded))
+	return keys, nil
+}
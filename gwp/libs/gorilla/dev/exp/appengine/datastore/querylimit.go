@@ -0,0 +1,52 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"sync/atomic"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"appengine"
+)
+
+var defaultQueryLimit int32
+
+// SetDefaultQueryLimit sets the limit Query.Run and Query.GetAll apply
+// when the query has no limit, to avoid unbounded scans of a kind. A
+// warning is logged every time it is applied, so missing limits get
+// noticed. Queries which really need every result must call
+// Query.Unlimited. A zero value, the default, disables it.
+//
+// Count, GetCursorAt and GetPage are not affected.
+func SetDefaultQueryLimit(limit int) error {
+	if err := validateInt32(limit, "default query limit"); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&defaultQueryLimit, int32(limit))
+	return nil
+}
+
+// Unlimited marks the query as intentionally unbounded, so the limit set
+// with SetDefaultQueryLimit is not applied to it. An explicit limit set
+// with Limit still applies.
+func (q *Query) Unlimited() *Query {
+	q.unlimited = true
+	return q
+}
+
+// withDefaultLimit returns b with the default query limit applied, if the
+// query has no limit and is not marked unlimited. b is left untouched.
+func (q *Query) withDefaultLimit(c appengine.Context, b *BaseQuery) *BaseQuery {
+	limit := atomic.LoadInt32(&defaultQueryLimit)
+	if limit == 0 || q.unlimited || b.err != nil || proto.GetInt32(b.pbq.Limit) > 0 {
+		return b
+	}
+	c.Warningf("datastore: %q query has no limit, applying default limit of %d",
+		proto.GetString(b.pbq.Kind), limit)
+	pbq := *b.pbq
+	pbq.Limit = proto.Int32(limit)
+	return &BaseQuery{pbq: &pbq}
+}
@@ -35,6 +35,11 @@ func valueToProto(name string, value interface{}, multiple bool) (p *pb.Property
 		// No-op.
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		pv.Int64Value = proto.Int64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("value %v overflows int64", v.Uint())
+		}
+		pv.Int64Value = proto.Int64(int64(v.Uint()))
 	case reflect.Bool:
 		pv.BooleanValue = proto.Bool(v.Bool())
 	case reflect.String:
@@ -132,6 +137,13 @@ func saveStructProperty(c chan<- Property, name string, noIndex, multiple bool,
 		switch v.Kind() {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			p.Value = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			// Unsigned values are stored as int64.
+			if v.Uint() > math.MaxInt64 {
+				return fmt.Errorf("datastore: value %v of struct field of type %v overflows int64",
+					v.Uint(), v.Type())
+			}
+			p.Value = int64(v.Uint())
 		case reflect.Bool:
 			p.Value = v.Bool()
 		case reflect.String:
@@ -150,6 +162,13 @@ func saveStructProperty(c chan<- Property, name string, noIndex, multiple bool,
 
 func (s structPLS) Save(c chan<- Property) error {
 	defer close(c)
+	return s.save(c, "", false)
+}
+
+// save saves the struct fields to c. Property names are prefixed with
+// prefix, and are not indexed if noIndex is true: nested struct fields
+// are saved as the fields of the parent struct.
+func (s structPLS) save(c chan<- Property, prefix string, noIndex bool) error {
 	for i, t := range s.codec.byIndex {
 		if t.name == "-" {
 			continue
@@ -158,6 +177,35 @@ func (s structPLS) Save(c chan<- Property) error {
 		if !v.IsValid() || !v.CanSet() {
 			continue
 		}
+		t.name = prefix + t.name
+		t.noIndex = t.noIndex || noIndex
+		// Nested struct fields are flattened, as "Field.SubField".
+		if t.substruct != nil {
+			sub := structPLS{v, *t.substruct}
+			if err := sub.save(c, t.name+".", t.noIndex); err != nil {
+				return err
+			}
+			continue
+		}
+		// JSON fields are saved as non-indexed []byte, encrypted if needed.
+		if t.json {
+			x, err := saveJSON(v, t.encrypt)
+			if err != nil {
+				return err
+			}
+			c <- Property{Name: t.name, Value: x, NoIndex: true}
+			continue
+		}
+		// Encrypted fields are saved as non-indexed []byte, encrypted
+		// along with the entity key by propertiesToProto.
+		if t.encrypt {
+			x, err := sealValue(v)
+			if err != nil {
+				return err
+			}
+			c <- Property{Name: t.name, Value: x, NoIndex: true}
+			continue
+		}
 		// For slice fields that aren't []byte, save each element.
 		if v.Kind() == reflect.Slice && v.Type() != typeOfByteSlice {
 			for j := 0; j < v.Len(); j++ {
@@ -233,6 +281,13 @@ func propertiesToProto(key *Key, src <-chan Property) (*pb.EntityProto, error) {
 			if !p.NoIndex {
 				return nil, fmt.Errorf("datastore: cannot index a []byte valued Property with Name %q", p.Name)
 			}
+		case sealed:
+			b, err := encryptValue(key, p.Name, v)
+			if err != nil {
+				return nil, err
+			}
+			x.Value.StringValue = proto.String(string(b))
+			x.Meaning = pb.NewProperty_Meaning(pb.Property_BLOB)
 		default:
 			return nil, fmt.Errorf("datastore: invalid Value type for a Property with Name %q", p.Name)
 		}
@@ -0,0 +1,121 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"appengine"
+	pb "appengine_internal/datastore"
+)
+
+// Soft-deleted entities are kept in the datastore, flagged with these
+// properties. Structs of soft-delete kinds should declare them as
+//
+//	Deleted   bool
+//	DeletedAt time.Time
+//
+// Times before the Unix epoch can't be stored, so live entities should
+// set DeletedAt to time.Unix(0, 0) rather than leave it zero.
+const (
+	SoftDeleteProperty     = "Deleted"
+	SoftDeleteTimeProperty = "DeletedAt"
+)
+
+var (
+	softDeleteMu    sync.RWMutex
+	softDeleteKinds = make(map[string]bool)
+)
+
+// RegisterSoftDelete registers an entity kind using the soft-delete
+// convention. Queries for the kind exclude soft-deleted entities, unless
+// Query.IncludeDeleted is set.
+//
+// The exclusion is done with an added "Deleted =" false filter, so:
+//
+//   - entities must be stored with the Deleted property (a false value for
+//     live entities), or they won't match;
+//   - queries which would be served by built-in indexes alone, like a
+//     filter on another property combined with a sort order, or an
+//     inequality filter, need a composite index including Deleted;
+//   - the filter is not added to queries without a kind.
+//
+// It is meant to be called at initialization time.
+func RegisterSoftDelete(kind string) {
+	softDeleteMu.Lock()
+	defer softDeleteMu.Unlock()
+	softDeleteKinds[kind] = true
+}
+
+// isSoftDeleteKind checks if kind was registered with RegisterSoftDelete.
+func isSoftDeleteKind(kind string) bool {
+	softDeleteMu.RLock()
+	defer softDeleteMu.RUnlock()
+	return softDeleteKinds[kind]
+}
+
+// IncludeDeleted sets whether the query returns soft-deleted entities of a
+// kind registered with RegisterSoftDelete. The default is false.
+func (q *Query) IncludeDeleted(include bool) *Query {
+	q.includeDeleted = include
+	return q
+}
+
+// runnable returns the base query to run, with the soft-delete filter
+// added when needed. q.base is left untouched.
+func (q *Query) runnable() *BaseQuery {
+	if q.includeDeleted || q.base.err != nil ||
+		!isSoftDeleteKind(proto.GetString(q.base.pbq.Kind)) {
+		return q.base
+	}
+	pbq := *q.base.pbq
+	pbq.Filter = append([]*pb.Query_Filter(nil), pbq.Filter...)
+	b := &BaseQuery{pbq: &pbq}
+	return b.Filter(SoftDeleteProperty, QueryOperatorEqual, false)
+}
+
+// SoftDelete flags the entity for the given key as deleted, setting its
+// Deleted property to true and DeletedAt to the current time, instead of
+// removing it. The entity is updated in a transaction, or in c if it
+// already is one. The kind must be registered with RegisterSoftDelete.
+//
+// It returns ErrNoSuchEntity if the entity doesn't exist.
+func SoftDelete(c appengine.Context, key *Key) error {
+	if !key.valid() {
+		return ErrInvalidKey
+	}
+	if !isSoftDeleteKind(key.kind) {
+		return errors.New("datastore: kind " + key.kind + " is not registered for soft delete")
+	}
+	f := func(tc appengine.Context) error {
+		var props PropertyList
+		if err := Get(tc, key, &props); err != nil {
+			return err
+		}
+		props = setProperty(props, SoftDeleteProperty, true)
+		props = setProperty(props, SoftDeleteTimeProperty, time.Now())
+		_, err := Put(tc, key, &props)
+		return err
+	}
+	if _, ok := c.(*transaction); ok {
+		return f(c)
+	}
+	return RunInTransaction(c, f, nil)
+}
+
+// setProperty replaces all the values of the named property with value.
+func setProperty(l PropertyList, name string, value interface{}) PropertyList {
+	out := l[:0]
+	for _, p := range l {
+		if p.Name != name {
+			out = append(out, p)
+		}
+	}
+	return append(out, Property{Name: name, Value: value})
+}
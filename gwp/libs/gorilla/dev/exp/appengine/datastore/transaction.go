@@ -6,6 +6,7 @@ package datastore
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 
 	"appengine"
@@ -19,10 +20,54 @@ import (
 // to a conflict with a concurrent transaction.
 var ErrConcurrentTransaction = errors.New("datastore: concurrent transaction")
 
+// MaxEntityGroups is the maximum number of entity groups a cross group (XG)
+// transaction can touch. Transactions without XG are limited to one group.
+const MaxEntityGroups = 25
+
+// ErrTooManyEntityGroups is returned when an operation in a transaction
+// would touch more entity groups than allowed; see MaxEntityGroups.
+var ErrTooManyEntityGroups = errors.New(
+	"datastore: too many entity groups in transaction (more than one requires XG)")
+
 type transaction struct {
 	appengine.Context
 	transaction pb.Transaction
 	finished    bool
+	maxGroups   int
+	groups      map[string]bool // root keys touched so far
+	newGroups   int             // roots created from incomplete keys
+	hooks       []func()        // hook calls to run after commit
+}
+
+// useGroups records the entity groups of the given keys, failing if that
+// exceeds the transaction limit. Nothing is recorded in that case.
+func (t *transaction) useGroups(refs []*pb.Reference) error {
+	added := make(map[string]bool)
+	newGroups := 0
+	for _, ref := range refs {
+		if ref == nil || ref.Path == nil || len(ref.Path.Element) == 0 {
+			continue
+		}
+		root := ref.Path.Element[0]
+		if len(ref.Path.Element) == 1 && proto.GetInt64(root.Id) == 0 && proto.GetString(root.Name) == "" {
+			// An incomplete root key creates a new entity group.
+			newGroups++
+			continue
+		}
+		g := fmt.Sprintf("%s/%s,%d,%s", proto.GetString(ref.NameSpace),
+			proto.GetString(root.Type), proto.GetInt64(root.Id), proto.GetString(root.Name))
+		if !t.groups[g] {
+			added[g] = true
+		}
+	}
+	if len(t.groups)+t.newGroups+len(added)+newGroups > t.maxGroups {
+		return ErrTooManyEntityGroups
+	}
+	for g := range added {
+		t.groups[g] = true
+	}
+	t.newGroups += newGroups
+	return nil
 }
 
 var errBadTransactionField = errors.New("datastore: Call parameter has an incompatible Transaction field")
@@ -82,15 +127,27 @@ func (t *transaction) Call(service, method string, in, out interface{}, opts *ap
 	}
 	switch service {
 	case "datastore_v3":
+		var err error
 		switch x := in.(type) {
 		case *pb.Query:
 			x.Transaction = &t.transaction
+			err = t.useGroups([]*pb.Reference{x.Ancestor})
 		case *pb.GetRequest:
 			x.Transaction = &t.transaction
+			err = t.useGroups(x.Key)
 		case *pb.PutRequest:
 			x.Transaction = &t.transaction
+			refs := make([]*pb.Reference, len(x.Entity))
+			for i, e := range x.Entity {
+				refs[i] = e.Key
+			}
+			err = t.useGroups(refs)
 		case *pb.DeleteRequest:
 			x.Transaction = &t.transaction
+			err = t.useGroups(x.Key)
+		}
+		if err != nil {
+			return err
 		}
 	case "taskqueue":
 		if err := t.setTransactionField(in); err != nil {
@@ -102,12 +159,13 @@ func (t *transaction) Call(service, method string, in, out interface{}, opts *ap
 
 func runOnce(c appengine.Context, f func(appengine.Context) error, opts *TransactionOptions) error {
 	// Begin the transaction.
-	t := &transaction{Context: c}
+	t := &transaction{Context: c, maxGroups: 1, groups: make(map[string]bool)}
 	req := &pb.BeginTransactionRequest{
 		App: proto.String(c.FullyQualifiedAppID()),
 	}
 	if opts != nil && opts.XG {
 		req.AllowMultipleEg = proto.Bool(true)
+		t.maxGroups = MaxEntityGroups
 	}
 	if err := t.Context.Call("datastore_v3", "BeginTransaction", req, &t.transaction, nil); err != nil {
 		return err
@@ -132,6 +190,11 @@ func runOnce(c appengine.Context, f func(appengine.Context) error, opts *Transac
 	// Commit the transaction.
 	res := &pb.CommitResponse{}
 	err := c.Call("datastore_v3", "Commit", &t.transaction, res, nil)
+	if err == nil {
+		for _, call := range t.hooks {
+			runHooks(c, call)
+		}
+	}
 	if ae, ok := err.(*appengine_internal.APIError); ok {
 		if appengine.IsDevAppServer() {
 			// The Python Dev AppServer raises an ApplicationError with error code 2 (which is
@@ -186,5 +249,9 @@ type TransactionOptions struct {
 	// entity groups, in global queries.
 	// It is valid to set XG to true even if the transaction is within a
 	// single entity group.
+	//
+	// Operations touching more groups than allowed (one without XG, or
+	// MaxEntityGroups with XG) fail with ErrTooManyEntityGroups before
+	// reaching the datastore, and the transaction is rolled back.
 	XG bool
 }
@@ -5,11 +5,14 @@
 package schema
 
 import (
+	"encoding"
 	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var invalidPath = errors.New("schema: invalid path")
@@ -23,18 +26,20 @@ func newCache() *cache {
 	for k, v := range converters {
 		c.conv[k] = v
 	}
+	c.conv[timeType] = TimeConverter(time.RFC3339)
 	return &c
 }
 
 // cache caches meta-data about a struct.
 type cache struct {
-	l    sync.Mutex
-	m    map[reflect.Type]*structInfo
-	conv map[reflect.Type]Converter
+	l          sync.Mutex
+	m          map[reflect.Type]*structInfo
+	conv       map[reflect.Type]Converter
+	ignoreCase bool // match path keys to aliases case-insensitively.
 }
 
-// parsePath parses a path in dotted notation verifying that it is a valid
-// path to a struct field.
+// parsePath parses a path in dotted or bracket notation, e.g. "a.0.b" or
+// "a[0][b]", verifying that it is a valid path to a struct field.
 //
 // It returns "path parts" which contain indices to fields to be used by
 // reflect.Value.FieldByIndex(). Multiple parts are required for slices of
@@ -46,17 +51,39 @@ func (c *cache) parsePath(p string, t reflect.Type) ([]pathPart, error) {
 	var err error
 	parts := make([]pathPart, 0)
 	path := make([]int, 0)
-	keys := strings.Split(p, ".")
+	mapKey, isMapKey := "", false
+	keys, ok := splitPath(p)
+	if !ok {
+		return nil, invalidPath
+	}
 	for i := 0; i < len(keys); i++ {
 		if struc = c.get(t); struc == nil {
 			return nil, invalidPath
 		}
-		if field = struc.get(keys[i]); field == nil {
+		if field = struc.get(keys[i]); field == nil && c.ignoreCase {
+			if field, err = struc.getFold(keys[i]); err != nil {
+				return nil, err
+			}
+		}
+		if field == nil {
 			return nil, invalidPath
 		}
-		// Valid field. Append index.
+		// Valid field. Append index, after the embedded structs holding it.
+		path = append(path, field.embed...)
 		path = append(path, field.idx)
-		if field.ss {
+		if isMap(field.typ) {
+			// The next key is the map key, and must be the last one.
+			if i+2 != len(keys) {
+				return nil, invalidPath
+			}
+			mapKey, isMapKey = keys[i+1], true
+			break
+		} else if c.converted(field.typ) {
+			// Converted as a whole, so it must be the last key.
+			if i+1 < len(keys) {
+				return nil, invalidPath
+			}
+		} else if field.ss {
 			// Parse a special case: slices of structs.
 			// i+1 must be the slice index, and i+2 must exist.
 			i++
@@ -89,24 +116,64 @@ func (c *cache) parsePath(p string, t reflect.Type) ([]pathPart, error) {
 			t = field.typ
 		} else if field.typ.Kind() == reflect.Ptr && field.typ.Elem().Kind() == reflect.Struct {
 			t = field.typ.Elem()
+		} else if i+1 < len(keys) {
+			// Not a struct, so it must be the last key.
+			return nil, invalidPath
 		}
 	}
+	// Maps are only filled by key.
+	if isMap(field.typ) != isMapKey {
+		return nil, invalidPath
+	}
 	// Add the remaining.
 	parts = append(parts, pathPart{
 		path:  path,
 		field: field,
 		index: -1,
+		key:   mapKey,
 	})
 	return parts, nil
 }
 
+// splitPath splits a path in dotted or bracket notation into its keys, so
+// "a.0.b", "a[0][b]" and "a[0].b" all give ["a", "0", "b"]. Keys in
+// brackets may contain dots. It returns false if the path is malformed.
+func splitPath(p string) ([]string, bool) {
+	var keys []string
+	for p != "" {
+		var key string
+		if p[0] == '[' {
+			end := strings.IndexByte(p, ']')
+			if end == -1 {
+				return nil, false
+			}
+			key, p = p[1:end], p[end+1:]
+		} else {
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			key, p = p[:end], p[end:]
+		}
+		keys = append(keys, key)
+		if p != "" && p[0] == '.' {
+			if p = p[1:]; p == "" {
+				return nil, false
+			}
+		} else if p != "" && p[0] != '[' {
+			return nil, false
+		}
+	}
+	return keys, len(keys) > 0
+}
+
 // get returns a cached structInfo, creating it if necessary.
 func (c *cache) get(t reflect.Type) *structInfo {
 	c.l.Lock()
 	info := c.m[t]
 	c.l.Unlock()
 	if info == nil {
-		info = c.create(t)
+		info = c.create(t, nil)
 		c.l.Lock()
 		c.m[t] = info
 		c.l.Unlock()
@@ -114,82 +181,254 @@ func (c *cache) get(t reflect.Type) *structInfo {
 	return info
 }
 
-// creat creates a structInfo with meta-data about a struct.
-func (c *cache) create(t reflect.Type) *structInfo {
+// create creates a structInfo with meta-data about a struct. building holds
+// the structs whose fields are being promoted into t, which embed t through
+// pointers, so their fields are not promoted again.
+func (c *cache) create(t reflect.Type, building map[reflect.Type]bool) *structInfo {
 	info := &structInfo{fields: make(map[string]*fieldInfo)}
+	var embedded []int
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		alias := fieldAlias(field)
+		alias, options := fieldAlias(field)
 		if alias == "-" {
 			// Ignore this field.
 			continue
 		}
+		if field.PkgPath != "" && field.Type.Kind() == reflect.Ptr {
+			// An unexported pointer can't be allocated when it's nil.
+			continue
+		}
 		// Check if the type is supported and don't cache it if not.
 		// First let's get the basic type.
-		isSlice, isStruct := false, false
+		isSlice, isStruct, isMapField := false, false, isMap(field.Type)
 		ft := field.Type
 		if ft.Kind() == reflect.Ptr {
 			ft = ft.Elem()
 		}
-		if isSlice = ft.Kind() == reflect.Slice; isSlice {
+		if isMapField {
+			// Maps with string keys; values are converted.
+			ft = ft.Elem()
+		} else if isSlice = ft.Kind() == reflect.Slice; isSlice {
 			ft = ft.Elem()
 			if ft.Kind() == reflect.Ptr {
 				ft = ft.Elem()
 			}
 		}
-		if isStruct = ft.Kind() == reflect.Struct; !isStruct {
-			if conv := c.conv[ft]; conv == nil {
+		// Structs with a converter, such as time.Time, are not nested.
+		isStruct = ft.Kind() == reflect.Struct && c.converter(ft) == nil && !isMapField
+		if !isStruct {
+			if conv := c.converter(ft); conv == nil {
 				// Type is not supported.
 				continue
 			}
 		}
+		anon := field.Anonymous && isStruct && !isSlice && ft != t &&
+			field.Tag.Get("schema") == ""
+		if anon {
+			embedded = append(embedded, i)
+		}
+		def, hasDef := options.Value("default")
 		info.fields[alias] = &fieldInfo{
-			idx: i,
-			typ: field.Type,
-			ss:  isSlice && isStruct,
+			idx:      i,
+			typ:      field.Type,
+			ss:       isSlice && isStruct,
+			anon:     anon,
+			required: options.Contains("required"),
+			def:      def,
+			hasDef:   hasDef,
 		}
 	}
+	c.promote(t, info, embedded, building)
+	info.folded = make(map[string][]*fieldInfo, len(info.fields))
+	for alias, field := range info.fields {
+		key := strings.ToLower(alias)
+		info.folded[key] = append(info.folded[key], field)
+	}
 	return info
 }
 
+// promote adds the fields of embedded structs to info, following the Go
+// rules: fields of the outer struct win over promoted ones, and a shallower
+// field wins over a deeper one. Ambiguous fields are not promoted.
+func (c *cache) promote(t reflect.Type, info *structInfo, embedded []int, building map[reflect.Type]bool) {
+	type candidate struct {
+		field *fieldInfo
+		count int
+	}
+	if len(embedded) == 0 {
+		return
+	}
+	inner := map[reflect.Type]bool{t: true}
+	for bt := range building {
+		inner[bt] = true
+	}
+	promoted := make(map[string]*candidate)
+	for _, i := range embedded {
+		ft := t.Field(i).Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if inner[ft] {
+			// Embedded in a cycle: its fields are already there, shallower.
+			continue
+		}
+		c.l.Lock()
+		fi := c.m[ft]
+		c.l.Unlock()
+		if fi == nil {
+			// Not cached, as it's only complete for t when built for t.
+			fi = c.create(ft, inner)
+		}
+		for alias, field := range fi.fields {
+			if _, ok := info.fields[alias]; ok {
+				continue
+			}
+			f := &fieldInfo{
+				typ:      field.typ,
+				idx:      field.idx,
+				ss:       field.ss,
+				anon:     field.anon,
+				required: field.required,
+				def:      field.def,
+				hasDef:   field.hasDef,
+				embed:    append([]int{i}, field.embed...),
+			}
+			p := promoted[alias]
+			switch {
+			case p == nil || len(f.embed) < len(p.field.embed):
+				promoted[alias] = &candidate{field: f, count: 1}
+			case len(f.embed) == len(p.field.embed):
+				p.count++
+			}
+		}
+	}
+	for alias, p := range promoted {
+		if p.count == 1 {
+			info.fields[alias] = p.field
+		}
+	}
+}
+
+// isMap returns true if t is a map, or a pointer to a map, with string keys.
+func isMap(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+}
+
+// converted returns true if a field of type t is converted as a whole.
+func (c *cache) converted(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return c.converter(t) != nil
+}
+
+// converter returns the converter for t: the registered one, or else one
+// calling UnmarshalText if *t implements encoding.TextUnmarshaler. It
+// returns nil if t is not supported.
+func (c *cache) converter(t reflect.Type) Converter {
+	if conv := c.conv[t]; conv != nil {
+		return conv
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return func(value string) reflect.Value {
+			v := reflect.New(t)
+			u := v.Interface().(encoding.TextUnmarshaler)
+			if err := u.UnmarshalText([]byte(value)); err != nil {
+				return invalidValue
+			}
+			return v.Elem()
+		}
+	}
+	return nil
+}
+
 // ----------------------------------------------------------------------------
 
 type structInfo struct {
 	fields map[string]*fieldInfo
+	folded map[string][]*fieldInfo // fields by lower case alias.
 }
 
 func (i *structInfo) get(alias string) *fieldInfo {
 	return i.fields[alias]
 }
 
+// getFold returns the field matching alias case-insensitively. It returns
+// an error if several fields match.
+func (i *structInfo) getFold(alias string) (*fieldInfo, error) {
+	fields := i.folded[strings.ToLower(alias)]
+	if len(fields) > 1 {
+		return nil, fmt.Errorf("schema: %q matches several fields", alias)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields[0], nil
+}
+
 type fieldInfo struct {
-	typ reflect.Type
-	idx int  // field index in the struct.
-	ss  bool // true if this is a slice of structs.
+	typ      reflect.Type
+	idx      int    // field index in the struct.
+	ss       bool   // true if this is a slice of structs.
+	anon     bool   // true if this is an embedded struct with promoted fields.
+	required bool   // true if the field has the "required" tag option.
+	def      string // value of the "default" tag option.
+	hasDef   bool   // true if the field has a "default" tag option.
+	embed    []int  // indices of the embedded structs holding a promoted field.
 }
 
 type pathPart struct {
 	field *fieldInfo
-	path  []int // path to the field: walks structs using field indices.
-	index int   // struct index in slices of structs.
+	path  []int  // path to the field: walks structs using field indices.
+	index int    // struct index in slices of structs.
+	key   string // key in maps.
 }
 
 // ----------------------------------------------------------------------------
 
-// fieldAlias parses a field tag to get a field alias.
-func fieldAlias(field reflect.StructField) string {
+// fieldAlias parses a field tag to get a field alias and the tag options
+// following it.
+func fieldAlias(field reflect.StructField) (string, tagOptions) {
 	var alias string
+	var options tagOptions
 	if tag := field.Tag.Get("schema"); tag != "" {
-		// For now tags only support the name but let's folow the
-		// comma convention from encoding/json and others.
+		// Options follow the comma convention from encoding/json and others.
 		if idx := strings.Index(tag, ","); idx == -1 {
 			alias = tag
 		} else {
 			alias = tag[:idx]
+			options = strings.Split(tag[idx+1:], ",")
 		}
 	}
 	if alias == "" {
 		alias = field.Name
 	}
-	return alias
+	return alias, options
+}
+
+// tagOptions are the options of a field tag, e.g. "required" or
+// "default=value".
+type tagOptions []string
+
+// Contains returns true if option is one of the options.
+func (o tagOptions) Contains(option string) bool {
+	for _, s := range o {
+		if s == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the value of a "name=value" option, and whether it is set.
+func (o tagOptions) Value(name string) (string, bool) {
+	for _, s := range o {
+		if strings.HasPrefix(s, name+"=") {
+			return s[len(name)+1:], true
+		}
+	}
+	return "", false
 }
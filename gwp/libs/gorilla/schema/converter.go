@@ -5,8 +5,13 @@
 package schema
 
 import (
+	"encoding"
+	"net"
+	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Converter func(string) reflect.Value
@@ -27,6 +32,12 @@ var (
 	uint16Type   = reflect.TypeOf(uint16(0))
 	uint32Type   = reflect.TypeOf(uint32(0))
 	uint64Type   = reflect.TypeOf(uint64(0))
+	ipType       = reflect.TypeOf(net.IP{})
+	urlType      = reflect.TypeOf(url.URL{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
 
 // Default converters for basic types.
@@ -47,7 +58,66 @@ var converters = map[reflect.Type]Converter{
 	uint64Type:  convertUint64,
 }
 
+// DefaultConverters returns converters for common types beyond the basic
+// ones: net.IP, url.URL and time.Duration. They are not used unless
+// registered:
+//
+//	decoder.RegisterConverters(schema.DefaultConverters())
+func DefaultConverters() map[reflect.Type]Converter {
+	return map[reflect.Type]Converter{
+		ipType:       ConvertIP,
+		urlType:      ConvertURL,
+		durationType: ConvertDuration,
+	}
+}
+
+// ConvertIP converts an IPv4 or IPv6 address to net.IP.
+func ConvertIP(value string) reflect.Value {
+	if v := net.ParseIP(value); v != nil {
+		return reflect.ValueOf(v)
+	}
+	return invalidValue
+}
+
+// ConvertURL converts an absolute URL to url.URL.
+func ConvertURL(value string) reflect.Value {
+	if v, err := url.Parse(value); err == nil && v.IsAbs() {
+		return reflect.ValueOf(*v)
+	}
+	return invalidValue
+}
+
+// ConvertDuration converts a duration string such as "1h30m" to
+// time.Duration, using time.ParseDuration.
+func ConvertDuration(value string) reflect.Value {
+	if v, err := time.ParseDuration(value); err == nil {
+		return reflect.ValueOf(v)
+	}
+	return invalidValue
+}
+
+// TimeConverter returns a converter parsing time.Time with the given
+// layout, as used by time.Parse. Decoders parse RFC3339 by default; see
+// Decoder.SetTimeLayout.
+func TimeConverter(layout string) Converter {
+	return func(value string) reflect.Value {
+		if v, err := time.Parse(layout, value); err == nil {
+			return reflect.ValueOf(v)
+		}
+		return invalidValue
+	}
+}
+
+// convertBool converts HTML checkbox values ("on") and the usual
+// true/false tokens, case-insensitively, besides what strconv.ParseBool
+// accepts.
 func convertBool(value string) reflect.Value {
+	switch strings.ToLower(value) {
+	case "on", "1", "true", "yes":
+		return reflect.ValueOf(true)
+	case "off", "0", "false", "", "no":
+		return reflect.ValueOf(false)
+	}
 	if v, err := strconv.ParseBool(value); err == nil {
 		return reflect.ValueOf(v)
 	}
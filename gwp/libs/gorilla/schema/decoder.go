@@ -6,17 +6,21 @@ package schema
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 )
 
 // NewDecoder returns a new Decoder.
 func NewDecoder() *Decoder {
-	return &Decoder{cache: newCache()}
+	return &Decoder{cache: newCache(), ignoreUnknownKeys: true}
 }
 
 // Decoder decodes values from a map[string][]string to a struct.
 type Decoder struct {
-	cache *cache
+	cache             *cache
+	ignoreUnknownKeys bool
+	zeroEmpty         bool
 }
 
 // RegisterConverter registers a converter function for a custom type.
@@ -24,6 +28,46 @@ func (d *Decoder) RegisterConverter(value interface{}, converterFunc Converter)
 	d.cache.conv[reflect.TypeOf(value)] = converterFunc
 }
 
+// RegisterConverters registers a set of converter functions, such as the
+// ones returned by DefaultConverters().
+//
+// Converters must be registered before decoding into a struct for the
+// first time, as supported fields are cached.
+func (d *Decoder) RegisterConverters(converters map[reflect.Type]Converter) {
+	for t, conv := range converters {
+		d.cache.conv[t] = conv
+	}
+}
+
+// SetTimeLayout sets the layout used to parse time.Time fields, as used by
+// time.Parse, e.g. "2006-01-02" for dates. The default is time.RFC3339.
+func (d *Decoder) SetTimeLayout(layout string) {
+	d.cache.conv[timeType] = TimeConverter(layout)
+}
+
+// IgnoreCase sets whether keys match field names case-insensitively, e.g.
+// "email" or "EMAIL" for a field named Email. An exact match is used first;
+// a key matching several fields only by case is an error for that key.
+// It is off by default.
+func (d *Decoder) IgnoreCase(ignore bool) {
+	d.cache.ignoreCase = ignore
+}
+
+// IgnoreUnknownKeys sets whether keys which are not a path to a supported
+// field are ignored, which is the default. If not, Decode returns an
+// UnknownKeyError for each of them in the MultiError.
+func (d *Decoder) IgnoreUnknownKeys(ignore bool) {
+	d.ignoreUnknownKeys = ignore
+}
+
+// ZeroEmpty sets whether empty values, such as a cleared text input, set
+// their field to the zero value, e.g. for update forms where an empty field
+// means "clear". By default empty values are skipped, leaving the field
+// unchanged.
+func (d *Decoder) ZeroEmpty(zero bool) {
+	d.zeroEmpty = zero
+}
+
 // Decode decodes a map[string][]string to a struct.
 //
 // The first parameter must be a pointer to a struct.
@@ -32,6 +76,11 @@ func (d *Decoder) RegisterConverter(value interface{}, converterFunc Converter)
 // Keys are "paths" in dotted notation to the struct fields and nested structs.
 //
 // See the package documentation for a full explanation of the mechanics.
+//
+// Values which can't be converted don't stop decoding: the other fields are
+// still filled, and a MultiError is returned with an error for each path
+// that failed. Keys which are not a path to a supported field are ignored,
+// unless set otherwise with IgnoreUnknownKeys.
 func (d *Decoder) Decode(dst interface{}, src map[string][]string) error {
 	v := reflect.ValueOf(dst)
 	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
@@ -39,16 +88,118 @@ func (d *Decoder) Decode(dst interface{}, src map[string][]string) error {
 	}
 	v = v.Elem()
 	t := v.Type()
+	errs := MultiError{}
+	// Values by path in dotted notation, to find the missing ones.
+	dotted := make(map[string][]string, len(src))
 	for path, values := range src {
-		if parts, err := d.cache.parsePath(path, t); err == nil {
-			d.decode(v, parts, values)
+		if keys, ok := splitPath(path); ok {
+			dotted[strings.Join(keys, ".")] = values
+		}
+		parts, err := d.cache.parsePath(path, t)
+		if err == nil {
+			err = d.decode(v, parts, values)
+		} else if err == invalidPath {
+			if d.ignoreUnknownKeys {
+				continue
+			}
+			err = &UnknownKeyError{Key: path}
+		}
+		if err != nil {
+			errs[path] = err
+		}
+	}
+	d.fillMissing(v, t, "", dotted, errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// fillMissing handles the fields of struct t, at prefix in dst, which have
+// no value in src, keyed by dotted paths: it sets the ones with a default value, and adds an
+// EmptyFieldError to errs for the required ones. Nested structs are handled
+// too, but pointers to structs only when src has a value for one of their
+// fields, and slices of structs not at all.
+func (d *Decoder) fillMissing(dst reflect.Value, t reflect.Type, prefix string, src map[string][]string, errs MultiError) {
+	for alias, field := range d.cache.get(t).fields {
+		if field.anon || field.ss {
+			continue
+		}
+		path := prefix + alias
+		ft := field.typ
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if isMap(ft) {
+			// Keys are not known, so there is nothing to check.
+			continue
+		}
+		if d.cache.converted(ft) || ft.Kind() != reflect.Struct {
+			if !isEmpty(d.lookup(src, path)) {
+				continue
+			}
+			// An empty value may have failed to convert.
+			delete(errs, path)
+			if field.hasDef {
+				parts, err := d.cache.parsePath(path, dst.Type())
+				if err == nil {
+					err = d.decode(dst, parts, []string{field.def})
+				}
+				if err != nil {
+					errs[path] = err
+				}
+			} else if field.required {
+				errs[path] = &EmptyFieldError{Key: path}
+			}
+			continue
+		}
+		if field.typ.Kind() == reflect.Ptr && !d.hasPrefix(src, path+".") {
+			continue
+		}
+		d.fillMissing(dst, ft, path+".", src, errs)
+	}
+}
+
+// lookup returns the values for path in src, honoring IgnoreCase.
+func (d *Decoder) lookup(src map[string][]string, path string) []string {
+	if values, ok := src[path]; ok || !d.cache.ignoreCase {
+		return values
+	}
+	for key, values := range src {
+		if strings.EqualFold(key, path) {
+			return values
 		}
 	}
 	return nil
 }
 
+// hasPrefix returns true if a key of src starts with prefix, honoring
+// IgnoreCase.
+func (d *Decoder) hasPrefix(src map[string][]string, prefix string) bool {
+	for key := range src {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+		if d.cache.ignoreCase && len(key) >= len(prefix) &&
+			strings.EqualFold(key[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty returns true if values has no value other than "".
+func isEmpty(values []string) bool {
+	for _, v := range values {
+		if v != "" {
+			return false
+		}
+	}
+	return true
+}
+
 // decode fills a struct field using a parsed path.
-func (d *Decoder) decode(v reflect.Value, parts []pathPart, values []string) {
+func (d *Decoder) decode(v reflect.Value, parts []pathPart, values []string) error {
 	// Get the field walking the struct fields by index.
 	for _, idx := range parts[0].path {
 		if v.Type().Kind() == reflect.Ptr {
@@ -60,10 +211,17 @@ func (d *Decoder) decode(v reflect.Value, parts []pathPart, values []string) {
 		v = v.Field(idx)
 	}
 
-	// Dereference if needed.
+	// Dereference if needed. Pointers to values are allocated only for a
+	// value, so a nil pointer tells a missing value from a zero one.
 	t := v.Type()
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
+		if len(parts) == 1 && t.Kind() != reflect.Map && isEmpty(values) {
+			if d.zeroEmpty {
+				v.Set(reflect.Zero(v.Type()))
+			}
+			return nil
+		}
 		if v.IsNil() {
 			v.Set(reflect.New(t))
 		}
@@ -81,43 +239,131 @@ func (d *Decoder) decode(v reflect.Value, parts []pathPart, values []string) {
 			}
 			v.Set(value)
 		}
-		d.decode(v.Index(idx), parts[1:], values)
-		return
+		return d.decode(v.Index(idx), parts[1:], values)
 	}
 
-	// Simple case.
-	if t.Kind() == reflect.Slice {
-		items := make([]reflect.Value, len(values))
+	// Simple case. A type with a converter is converted as a whole, even
+	// if it is a slice (e.g. net.IP). Empty values are skipped, or set to
+	// the zero value with ZeroEmpty.
+	if t.Kind() == reflect.Map {
+		var value reflect.Value
+		if values[0] == "" {
+			if !d.zeroEmpty {
+				return nil
+			}
+			value = reflect.Zero(t.Elem())
+		} else if value = d.cache.converter(t.Elem())(values[0]); !value.IsValid() {
+			return &ConversionError{Type: t.Elem(), Value: values[0], Index: -1}
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		v.SetMapIndex(reflect.ValueOf(parts[0].key).Convert(t.Key()), value)
+	} else if conv := d.cache.converter(t); conv != nil {
+		if values[0] == "" {
+			if d.zeroEmpty {
+				v.Set(reflect.Zero(t))
+			}
+			return nil
+		}
+		value := conv(values[0])
+		if !value.IsValid() {
+			return &ConversionError{Type: t, Value: values[0], Index: -1}
+		}
+		v.Set(value)
+	} else if t.Kind() == reflect.Slice {
+		items := make([]reflect.Value, 0, len(values))
 		elemT := t.Elem()
 		isPtrElem := elemT.Kind() == reflect.Ptr
 		if isPtrElem {
 			elemT = elemT.Elem()
 		}
-		conv := d.cache.conv[elemT]
+		conv := d.cache.converter(elemT)
 		if conv == nil {
-			return
+			return fmt.Errorf("schema: converter not found for %v", elemT)
 		}
 		for key, value := range values {
-			if item := conv(value); item.IsValid() {
-				if isPtrElem {
-					ptr := reflect.New(elemT)
-					ptr.Elem().Set(item)
-					item = ptr
+			var item reflect.Value
+			if value == "" {
+				if !d.zeroEmpty {
+					continue
 				}
-				items[key] = item
-			} else {
-				// If a single value is invalid should we give up
-				// or set a zero value?
-				return
+				item = reflect.Zero(elemT)
+			} else if item = conv(value); !item.IsValid() {
+				// The slice is left unchanged.
+				return &ConversionError{Type: elemT, Value: value, Index: key}
+			}
+			if isPtrElem {
+				ptr := reflect.New(elemT)
+				ptr.Elem().Set(item)
+				item = ptr
 			}
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			return nil
 		}
 		value := reflect.Append(reflect.MakeSlice(t, 0, 0), items...)
 		v.Set(value)
-	} else {
-		if conv := d.cache.conv[t]; conv != nil {
-			if value := conv(values[0]); value.IsValid() {
-				v.Set(value)
-			}
-		}
 	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// ConversionError is the error for a value which could not be converted to
+// the type of its field.
+type ConversionError struct {
+	Type  reflect.Type // the type the value was converted to.
+	Value string       // the value from the source map.
+	Index int          // the position of the value in a slice, or -1.
+}
+
+func (e *ConversionError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("schema: error converting %q to %v", e.Value, e.Type)
+	}
+	return fmt.Sprintf("schema: error converting %q to %v at index %d",
+		e.Value, e.Type, e.Index)
+}
+
+// EmptyFieldError is the error for a required field without a value.
+type EmptyFieldError struct {
+	Key string // the path of the field.
+}
+
+func (e *EmptyFieldError) Error() string {
+	return "schema: " + e.Key + " is required"
+}
+
+// UnknownKeyError is the error for a key which is not a path to a
+// supported field, when unknown keys are not ignored.
+type UnknownKeyError struct {
+	Key string // the key from the source map.
+}
+
+func (e *UnknownKeyError) Error() string {
+	return fmt.Sprintf("schema: invalid path %q", e.Key)
+}
+
+// MultiError maps the paths which failed to decode to their errors.
+//
+// It can be passed on as per field errors, e.g. to gwp_core.FieldErrors.
+type MultiError map[string]error
+
+func (e MultiError) Error() string {
+	s := ""
+	for _, err := range e {
+		s = err.Error()
+		break
+	}
+	switch len(e) {
+	case 0:
+		return "(0 errors)"
+	case 1:
+		return s
+	case 2:
+		return s + " (and 1 other error)"
+	}
+	return fmt.Sprintf("%s (and %d other errors)", s, len(e)-1)
 }
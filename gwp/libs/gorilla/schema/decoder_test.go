@@ -6,7 +6,11 @@ package schema
 
 import (
 	//"reflect"
+	"fmt"
+	"net"
+	"net/url"
 	"testing"
+	"time"
 )
 
 // All cases we want to cover, in a nutshell.
@@ -493,3 +497,701 @@ func TestSimpleExample(t *testing.T) {
 		}
 	}
 }
+
+// ----------------------------------------------------------------------------
+
+type S4 struct {
+	Addr    net.IP
+	Home    *url.URL
+	Timeout time.Duration
+	Delays  []time.Duration
+}
+
+func TestDefaultConvertersBundle(t *testing.T) {
+	v := map[string][]string{
+		"Addr":    {"192.168.0.1"},
+		"Home":    {"http://example.com/path?q=1"},
+		"Timeout": {"1m30s"},
+		"Delays":  {"1s", "250ms"},
+	}
+
+	// The bundle is opt-in. net.IP implements encoding.TextUnmarshaler, so
+	// it is decoded anyway.
+	s := &S4{}
+	_ = NewDecoder().Decode(s, v)
+	if s.Timeout != 0 || s.Delays != nil {
+		t.Errorf("Expected fields to be ignored without converters, got %+v", s)
+	}
+	if !s.Addr.Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Errorf("Addr: expected 192.168.0.1, got %v", s.Addr)
+	}
+
+	decoder := NewDecoder()
+	decoder.RegisterConverters(DefaultConverters())
+	s = &S4{}
+	_ = decoder.Decode(s, v)
+	if !s.Addr.Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Errorf("Addr: expected 192.168.0.1, got %v", s.Addr)
+	}
+	if s.Home == nil || s.Home.Host != "example.com" || s.Home.Path != "/path" {
+		t.Errorf("Home: expected http://example.com/path?q=1, got %v", s.Home)
+	}
+	if s.Timeout != 90*time.Second {
+		t.Errorf("Timeout: expected %v, got %v", 90*time.Second, s.Timeout)
+	}
+	if len(s.Delays) != 2 || s.Delays[0] != time.Second || s.Delays[1] != 250*time.Millisecond {
+		t.Errorf("Delays: expected [1s 250ms], got %v", s.Delays)
+	}
+
+	// Invalid values are not converted.
+	s = &S4{}
+	_ = decoder.Decode(s, map[string][]string{
+		"Addr":    {"999.0.0.1"},
+		"Home":    {"not a url"},
+		"Timeout": {"soon"},
+	})
+	if s.Addr != nil || (s.Home != nil && s.Home.Host != "") || s.Timeout != 0 {
+		t.Errorf("Expected invalid values to be skipped, got %+v", s)
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	type S5 struct {
+		A  int
+		B  int
+		C  int
+		Ns []int
+		S  string
+	}
+	s := &S5{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"A":       {"1"},
+		"B":       {"two"},
+		"C":       {"3.5"},
+		"Ns":      {"4", "five"},
+		"S":       {"six"},
+		"Unknown": {"7"},
+	})
+	errs, ok := err.(MultiError)
+	if !ok {
+		t.Fatalf("Expected MultiError, got %v", err)
+	}
+	if len(errs) != 3 || errs["B"] == nil || errs["C"] == nil || errs["Ns"] == nil {
+		t.Fatalf("Expected errors for B, C and Ns, got %v", errs)
+	}
+	if e, ok := errs["Ns"].(*ConversionError); !ok || e.Index != 1 || e.Value != "five" {
+		t.Errorf("Ns: expected conversion error at index 1, got %v", errs["Ns"])
+	}
+	// The valid fields are decoded anyway.
+	if s.A != 1 || s.S != "six" || s.B != 0 || s.Ns != nil {
+		t.Errorf("Expected only valid fields to be set, got %+v", s)
+	}
+
+	if err := NewDecoder().Decode(s, map[string][]string{"A": {"2"}}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+type Base struct {
+	ID int
+}
+
+type User struct {
+	Base
+	Name string
+}
+
+type Admin struct {
+	*User
+	Name  string
+	Level int
+}
+
+type base struct {
+	ID int
+}
+
+type Hidden struct {
+	*base
+	Name string
+}
+
+type Left struct {
+	*Right
+	L int
+}
+
+type Right struct {
+	*Left
+	R int
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	u := &User{}
+	err := NewDecoder().Decode(u, map[string][]string{
+		"ID":   {"5"},
+		"Name": {"x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 5 || u.Name != "x" {
+		t.Errorf("Expected {ID:5 Name:x}, got %+v", u)
+	}
+
+	// The dotted form still works.
+	u = &User{}
+	_ = NewDecoder().Decode(u, map[string][]string{"Base.ID": {"6"}})
+	if u.ID != 6 {
+		t.Errorf("Base.ID: expected 6, got %v", u.ID)
+	}
+
+	// Embedded pointers are allocated, and outer fields shadow promoted ones.
+	a := &Admin{}
+	_ = NewDecoder().Decode(a, map[string][]string{
+		"ID":    {"7"},
+		"Name":  {"root"},
+		"Level": {"2"},
+	})
+	if a.User == nil || a.ID != 7 || a.Name != "root" || a.User.Name != "" || a.Level != 2 {
+		t.Errorf("Expected {ID:7 Name:root Level:2}, got %+v %+v", a, a.User)
+	}
+
+	// Unexported embedded pointers can't be allocated, so their fields
+	// are unknown.
+	h := &Hidden{}
+	err = NewDecoder().Decode(h, map[string][]string{
+		"ID":      {"8"},
+		"base.ID": {"8"},
+		"Name":    {"x"},
+	})
+	if err != nil || h.base != nil || h.Name != "x" {
+		t.Errorf("Expected {Name:x}, got %+v, %v", h, err)
+	}
+
+	// Structs embedding pointers to each other.
+	l := &Left{}
+	err = NewDecoder().Decode(l, map[string][]string{
+		"L":       {"1"},
+		"R":       {"2"},
+		"Right.L": {"3"},
+	})
+	if err != nil || l.L != 1 || l.Right == nil || l.R != 2 || l.Right.Left == nil || l.Right.L != 3 {
+		t.Errorf("Expected {L:1 R:2 Right.L:3}, got %+v %+v, %v", l, l.Right, err)
+	}
+	r := &Right{}
+	if err = NewDecoder().Decode(r, map[string][]string{"L": {"4"}, "R": {"5"}}); err != nil ||
+		r.Left == nil || r.L != 4 || r.R != 5 {
+		t.Errorf("Expected {L:4 R:5}, got %+v, %v", r, err)
+	}
+
+	values := map[string][]string{}
+	if err := NewEncoder().Encode(a, values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values["ID"][0] != "7" || values["Name"][0] != "root" {
+		t.Errorf("Expected promoted fields to be encoded, got %v", values)
+	}
+}
+
+func TestTimeConverter(t *testing.T) {
+	type S6 struct {
+		At    time.Time
+		On    *time.Time
+		Dates []time.Time
+	}
+	s := &S6{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"At": {"2012-11-01T22:08:41+00:00"},
+		"On": {"2012-11-01"},
+	})
+	if s.At.Year() != 2012 || s.At.Hour() != 22 || s.At.Minute() != 8 {
+		t.Errorf("At: expected 2012-11-01T22:08:41Z, got %v", s.At)
+	}
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 || errs["On"] == nil {
+		t.Errorf("Expected an error for On, got %v", err)
+	}
+
+	d := NewDecoder()
+	d.SetTimeLayout("2006-01-02")
+	s = &S6{}
+	err = d.Decode(s, map[string][]string{
+		"On":    {"2012-11-01"},
+		"Dates": {"2012-11-02", "2012-11-03"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.On == nil || s.On.Day() != 1 || s.On.Month() != time.November {
+		t.Errorf("On: expected 2012-11-01, got %v", s.On)
+	}
+	if len(s.Dates) != 2 || s.Dates[1].Day() != 3 {
+		t.Errorf("Dates: expected [2012-11-02 2012-11-03], got %v", s.Dates)
+	}
+
+	values := map[string][]string{}
+	_ = NewEncoder().Encode(&S6{At: s.Dates[0]}, values)
+	if v := values["At"]; len(v) != 1 || v[0] != "2012-11-02T00:00:00Z" {
+		t.Errorf("Expected At to be encoded as RFC3339, got %v", v)
+	}
+}
+
+func TestRequired(t *testing.T) {
+	type Address struct {
+		City string `schema:"city,required"`
+	}
+	type S7 struct {
+		Name string   `schema:"name,required"`
+		Age  int      `schema:"age"`
+		Tags []string `schema:"tags,required"`
+		Home Address  `schema:"home"`
+		Work *Address `schema:"work"`
+	}
+	s := &S7{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"name":      {"John"},
+		"tags":      {"a"},
+		"home.city": {"Lisbon"},
+	})
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// Absent, and present but empty. Work is not checked as it is not sent.
+	err = NewDecoder().Decode(&S7{}, map[string][]string{
+		"age":  {"1"},
+		"tags": {""},
+	})
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("Expected errors for name, tags and home.city, got %v", err)
+	}
+	for _, path := range []string{"name", "tags", "home.city"} {
+		if _, ok := errs[path].(*EmptyFieldError); !ok {
+			t.Errorf("%s: expected EmptyFieldError, got %v", path, errs[path])
+		}
+	}
+
+	err = NewDecoder().Decode(&S7{}, map[string][]string{
+		"name":      {""},
+		"tags":      {"a"},
+		"home.city": {"Lisbon"},
+		"work.city": {""},
+	})
+	errs, ok = err.(MultiError)
+	if !ok || len(errs) != 2 || errs["name"] == nil || errs["work.city"] == nil {
+		t.Errorf("Expected errors for name and work.city, got %v", err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	type S8 struct {
+		Page  int      `schema:"page,default=1"`
+		Sort  string   `schema:"sort,default=name"`
+		Limit *int     `schema:"limit,default=20"`
+		Tags  []string `schema:"tags,default=all"`
+		Query string   `schema:"q"`
+	}
+	s := &S8{}
+	if err := NewDecoder().Decode(s, map[string][]string{"q": {"go"}}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Page != 1 || s.Sort != "name" || s.Limit == nil || *s.Limit != 20 ||
+		len(s.Tags) != 1 || s.Tags[0] != "all" || s.Query != "go" {
+		t.Errorf("Expected defaults to be set, got %+v", s)
+	}
+
+	// Explicit values override the defaults; empty ones don't.
+	s = &S8{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"page": {"3"},
+		"sort": {"date"},
+		"tags": {""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Page != 3 || s.Sort != "date" || *s.Limit != 20 || s.Tags[0] != "all" {
+		t.Errorf("Expected page 3 and sort date, got %+v", s)
+	}
+
+	// Invalid defaults are reported like invalid values.
+	type S9 struct {
+		N int `schema:"n,default=x"`
+	}
+	err = NewDecoder().Decode(&S9{}, map[string][]string{})
+	if errs, ok := err.(MultiError); !ok || errs["n"] == nil {
+		t.Errorf("Expected an error for n, got %v", err)
+	}
+}
+
+func TestFieldAlias(t *testing.T) {
+	type S10 struct {
+		UserName string `schema:"user_name"`
+		Password string `schema:"-"`
+		Email    string
+	}
+	s := &S10{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"user_name": {"john"},
+		"UserName":  {"not the alias"},
+		"Password":  {"secret"},
+		"-":         {"secret"},
+		"Email":     {"john@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserName != "john" {
+		t.Errorf("UserName: expected john, got %q", s.UserName)
+	}
+	if s.Password != "" {
+		t.Errorf("Password: expected to be ignored, got %q", s.Password)
+	}
+	if s.Email != "john@example.com" {
+		t.Errorf("Email: expected john@example.com, got %q", s.Email)
+	}
+}
+
+func TestIgnoreCase(t *testing.T) {
+	type Address struct {
+		City string `schema:"city,required"`
+	}
+	type S11 struct {
+		Email string
+		Name  string `schema:"user_name"`
+		Home  Address
+	}
+	src := map[string][]string{
+		"EMAIL":     {"john@example.com"},
+		"User_Name": {"john"},
+		"home.CITY": {"Lisbon"},
+	}
+	s := &S11{}
+	if err := NewDecoder().Decode(s, src); err == nil || s.Email != "" {
+		t.Errorf("Expected keys to be case-sensitive by default, got %+v", s)
+	}
+
+	d := NewDecoder()
+	d.IgnoreCase(true)
+	s = &S11{}
+	if err := d.Decode(s, src); err != nil {
+		t.Fatal(err)
+	}
+	if s.Email != "john@example.com" || s.Name != "john" || s.Home.City != "Lisbon" {
+		t.Errorf("Expected mixed-case keys to match, got %+v", s)
+	}
+
+	// Keys matching several fields by case only are an error.
+	type S12 struct {
+		ID int
+		Id int
+	}
+	s12 := &S12{}
+	err := d.Decode(s12, map[string][]string{"Id": {"1"}, "id": {"2"}})
+	if errs, ok := err.(MultiError); !ok || len(errs) != 1 || errs["id"] == nil {
+		t.Errorf("Expected an error for id, got %v", err)
+	}
+	if s12.Id != 1 || s12.ID != 0 {
+		t.Errorf("Expected the exact match to be used, got %+v", s12)
+	}
+}
+
+func TestMapField(t *testing.T) {
+	type Item struct {
+		Qty map[string]int
+	}
+	type S13 struct {
+		Attrs map[string]string `schema:"attrs"`
+		Items []Item
+		Sizes *map[string]int
+	}
+	s := &S13{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"attrs[color]":      {"red"},
+		"attrs[size]":       {"L"},
+		"attrs[a.b]":        {"dotted"},
+		"Items.0.Qty[pear]": {"2"},
+		"Sizes[S]":          {"1"},
+		"Sizes[M]":          {"x"},
+		"attrs":             {"ignored"},
+	})
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 1 || errs["Sizes[M]"] == nil {
+		t.Errorf("Expected an error for Sizes[M], got %v", err)
+	}
+	if len(s.Attrs) != 3 || s.Attrs["color"] != "red" || s.Attrs["size"] != "L" || s.Attrs["a.b"] != "dotted" {
+		t.Errorf("Attrs: expected map[color:red size:L a.b:dotted], got %v", s.Attrs)
+	}
+	if len(s.Items) != 1 || s.Items[0].Qty["pear"] != 2 {
+		t.Errorf("Items: expected [{map[pear:2]}], got %v", s.Items)
+	}
+	if s.Sizes == nil || len(*s.Sizes) != 1 || (*s.Sizes)["S"] != 1 {
+		t.Errorf("Sizes: expected map[S:1], got %v", s.Sizes)
+	}
+
+	values := map[string][]string{}
+	_ = NewEncoder().Encode(s, values)
+	if v := values["attrs[color]"]; len(v) != 1 || v[0] != "red" {
+		t.Errorf("Expected attrs[color] to be encoded, got %v", values)
+	}
+	if v := values["Sizes[S]"]; len(v) != 1 || v[0] != "1" {
+		t.Errorf("Expected Sizes[S] to be encoded, got %v", values)
+	}
+}
+
+func TestIgnoreUnknownKeys(t *testing.T) {
+	type S14 struct {
+		Name string
+		Tags []string
+	}
+	src := map[string][]string{
+		"Name":    {"john"},
+		"Tags":    {"a", "b"},
+		"csrf":    {"token"},
+		"Name.Of": {"x"},
+	}
+	s := &S14{}
+	if err := NewDecoder().Decode(s, src); err != nil {
+		t.Errorf("Expected unknown keys to be ignored by default, got %v", err)
+	}
+
+	d := NewDecoder()
+	d.IgnoreUnknownKeys(false)
+	s = &S14{}
+	err := d.Decode(s, src)
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected errors for csrf and Name.Of, got %v", err)
+	}
+	for _, key := range []string{"csrf", "Name.Of"} {
+		if e, ok := errs[key].(*UnknownKeyError); !ok || e.Key != key {
+			t.Errorf("%s: expected UnknownKeyError, got %v", key, errs[key])
+		}
+	}
+	if s.Name != "john" || len(s.Tags) != 2 {
+		t.Errorf("Expected known keys to be decoded, got %+v", s)
+	}
+}
+
+func TestZeroEmpty(t *testing.T) {
+	type S15 struct {
+		Name  string
+		Age   int
+		Score *float64
+		Tags  []int
+	}
+	score := 1.5
+	src := map[string][]string{
+		"Name":  {"john"},
+		"Age":   {""},
+		"Score": {""},
+		"Tags":  {"1", "", "3"},
+	}
+	s := &S15{Name: "jane", Age: 30, Score: &score}
+	if err := NewDecoder().Decode(s, src); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "john" || s.Age != 30 || *s.Score != 1.5 {
+		t.Errorf("Expected empty values to be skipped, got %+v", s)
+	}
+	if len(s.Tags) != 2 || s.Tags[1] != 3 {
+		t.Errorf("Tags: expected [1 3], got %v", s.Tags)
+	}
+
+	d := NewDecoder()
+	d.ZeroEmpty(true)
+	s = &S15{Name: "jane", Age: 30, Score: &score}
+	if err := d.Decode(s, src); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "john" || s.Age != 0 || s.Score != nil {
+		t.Errorf("Expected empty values to be zeroed, got %+v", s)
+	}
+	if len(s.Tags) != 3 || s.Tags[1] != 0 {
+		t.Errorf("Tags: expected [1 0 3], got %v", s.Tags)
+	}
+}
+
+func TestConvertBool(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"on", true},
+		{"ON", true},
+		{"1", true},
+		{"true", true},
+		{"True", true},
+		{"yes", true},
+		{"Yes", true},
+		{"off", false},
+		{"Off", false},
+		{"0", false},
+		{"false", false},
+		{"FALSE", false},
+		{"", false},
+		{"no", false},
+		{"NO", false},
+	}
+	for _, test := range tests {
+		v := convertBool(test.value)
+		if !v.IsValid() || v.Bool() != test.want {
+			t.Errorf("%q: expected %v, got %v", test.value, test.want, v)
+		}
+	}
+	if v := convertBool("maybe"); v.IsValid() {
+		t.Errorf("\"maybe\": expected an invalid value, got %v", v)
+	}
+
+	s := &struct{ Subscribe bool }{}
+	_ = NewDecoder().Decode(s, map[string][]string{"Subscribe": {"on"}})
+	if !s.Subscribe {
+		t.Errorf("Subscribe: expected a checked checkbox to be true")
+	}
+}
+
+func TestPointerField(t *testing.T) {
+	type S16 struct {
+		Age  *int
+		Name *string
+		Tags *[]string
+	}
+	tests := []struct {
+		src  map[string][]string
+		age  *int
+		zero bool // with ZeroEmpty.
+	}{
+		{map[string][]string{"Age": {"0"}}, new(int), false},
+		{map[string][]string{"Age": {"42"}}, func() *int { i := 42; return &i }(), false},
+		{map[string][]string{}, nil, false},
+		{map[string][]string{"Age": {""}}, nil, false},
+		{map[string][]string{"Age": {""}}, nil, true},
+	}
+	for i, test := range tests {
+		d := NewDecoder()
+		d.ZeroEmpty(test.zero)
+		s := &S16{}
+		if err := d.Decode(s, test.src); err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if (s.Age == nil) != (test.age == nil) || (s.Age != nil && *s.Age != *test.age) {
+			t.Errorf("%d: expected Age %v, got %v", i, test.age, s.Age)
+		}
+		if s.Name != nil || s.Tags != nil {
+			t.Errorf("%d: expected Name and Tags to stay nil, got %+v", i, s)
+		}
+	}
+
+	// ZeroEmpty resets a set pointer to nil.
+	age := 42
+	s := &S16{Age: &age}
+	d := NewDecoder()
+	d.ZeroEmpty(true)
+	_ = d.Decode(s, map[string][]string{"Age": {""}, "Tags": {"", ""}})
+	if s.Age != nil || s.Tags != nil || age != 42 {
+		t.Errorf("Expected Age to be reset to nil, got %v", s.Age)
+	}
+}
+
+func TestBracketNotation(t *testing.T) {
+	type Phone struct {
+		Label  string
+		Number string `schema:"number,required"`
+	}
+	type Person struct {
+		Name   string
+		Home   *Phone
+		Phones []Phone
+		Attrs  map[string]string
+	}
+	dotted := map[string][]string{
+		"Name":            {"John"},
+		"Home.number":     {"123"},
+		"Phones.0.Label":  {"work"},
+		"Phones.0.number": {"456"},
+		"Phones.1.number": {"789"},
+		"Attrs.color":     {"red"},
+	}
+	brackets := map[string][]string{
+		"Name":              {"John"},
+		"Home[number]":      {"123"},
+		"Phones[0][Label]":  {"work"},
+		"Phones[0].number":  {"456"},
+		"Phones[1][number]": {"789"},
+		"Attrs[color]":      {"red"},
+	}
+	for i, src := range []map[string][]string{dotted, brackets} {
+		p := &Person{}
+		if err := NewDecoder().Decode(p, src); err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if p.Name != "John" || p.Home == nil || p.Home.Number != "123" ||
+			len(p.Phones) != 2 || p.Phones[0].Label != "work" ||
+			p.Phones[0].Number != "456" || p.Phones[1].Number != "789" ||
+			p.Attrs["color"] != "red" {
+			t.Errorf("%d: got %+v", i, p)
+		}
+	}
+
+	d := NewDecoder()
+	d.IgnoreUnknownKeys(false)
+	for _, key := range []string{"Phones[0", "Phones[0]Label", "Name.", "Home[number]x", ""} {
+		err := d.Decode(&Person{}, map[string][]string{key: {"x"}})
+		if errs, ok := err.(MultiError); !ok || errs[key] == nil {
+			t.Errorf("%q: expected an error, got %v", key, err)
+		}
+	}
+}
+
+// Level implements encoding.TextUnmarshaler and encoding.TextMarshaler.
+type Level int
+
+var levels = []string{"debug", "info", "error"}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	for i, s := range levels {
+		if s == string(text) {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %q", text)
+}
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(levels[l]), nil
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	type S17 struct {
+		Level  Level
+		Min    *Level
+		Levels []Level
+	}
+	s := &S17{}
+	err := NewDecoder().Decode(s, map[string][]string{
+		"Level":  {"error"},
+		"Min":    {"info"},
+		"Levels": {"debug", "error"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Level != 2 || s.Min == nil || *s.Min != 1 || len(s.Levels) != 2 || s.Levels[1] != 2 {
+		t.Errorf("Expected {Level:2 Min:1 Levels:[0 2]}, got %+v", s)
+	}
+
+	err = NewDecoder().Decode(s, map[string][]string{"Level": {"fatal"}})
+	if errs, ok := err.(MultiError); !ok || errs["Level"] == nil {
+		t.Errorf("Expected an error for Level, got %v", err)
+	}
+
+	values := map[string][]string{}
+	_ = NewEncoder().Encode(s, values)
+	if v := values["Levels"]; len(v) != 2 || v[0] != "debug" || v[1] != "error" {
+		t.Errorf("Expected Levels to be encoded with MarshalText, got %v", values)
+	}
+}
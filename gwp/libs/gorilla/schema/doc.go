@@ -40,19 +40,38 @@ certain fields, use a dash as the name and it will be ignored:
 		Admin bool   `schema:"-"`     // this field is never set
 	}
 
+Options follow the name after a comma. A "required" field without a value,
+or with an empty one, makes Decode return an error for its path in the
+MultiError:
+
+	Email string `schema:"email,required"`
+
+A field with a "default" option is set to the default when it has no value,
+or an empty one. The default is converted like a value from the map, and
+can't contain a comma:
+
+	Page int `schema:"page,default=1"`
+
 The supported field types in the destination struct are:
 
-	* bool
+	* bool, from "on" (a checked checkbox), "true", "yes" or "1", and "off",
+	  "false", "no" or "0", in any case
 	* float variants (float32, float64)
 	* int variants (int, int8, int16, int32, int64)
 	* string
 	* uint variants (uint, uint8, uint16, uint32, uint64)
+	* time.Time, parsed as RFC3339 unless set with Decoder.SetTimeLayout()
 	* struct
+	* a map with string keys and values of one of the above types, except
+	  struct
 	* a pointer to one of the above types
 	* a slice or a pointer to a slice of one of the above types
 
 Non-supported types are simply ignored, however custom types can be registered
-to be converted.
+to be converted. Types implementing encoding.TextUnmarshaler, like many ID and
+enum types, need no converter: UnmarshalText is called instead. Converters for net.IP, url.URL and time.Duration are provided
+by DefaultConverters(), and can be registered with
+Decoder.RegisterConverters().
 
 To fill nested structs, keys must use a dotted notation as the "path" for the
 field. So for example, to fill the struct Person below:
@@ -100,5 +119,39 @@ a Person with multiple Phone values, like:
 Notice that only for slices of structs the slice index is required.
 This is needed for disambiguation: if the nested struct also has a slice
 field, we could not represent it.
+
+Keys can also use the bracket notation some form libraries emit, such as
+"Phones[0][Label]", or mix both, as in "Phones[0].Label".
+
+Maps with string keys are filled one key at a time, with the key in
+brackets after the field name. So to fill the Attrs field of
+
+	type Product struct {
+		Attrs map[string]string
+	}
+
+...keys look like "Attrs[color]" and "Attrs[size]", or "Attrs.color" in
+dotted notation when the map key has no dots.
+
+Fields of embedded structs are promoted, as in Go, so they are filled
+without the dotted prefix:
+
+	type Base struct {
+		ID int
+	}
+
+	type User struct {
+		Base
+		Name string
+	}
+
+...is filled from the keys "ID" and "Name". The dotted form "Base.ID" works
+too. An embedded struct with a name in its tag is treated as a named field.
+
+The Encoder does the inverse, writing a struct into a map[string][]string
+with the same keys, e.g. to build a query string from a form struct:
+
+	values := url.Values{}
+	err := schema.NewEncoder().Encode(person, values)
 */
 package schema
@@ -0,0 +1,182 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// EncoderFunc returns the string representation of a value of a custom type.
+type EncoderFunc func(reflect.Value) string
+
+// NewEncoder returns a new Encoder.
+//
+// Besides the basic types, it encodes the types of DefaultConverters(),
+// so they can be decoded again once registered with the Decoder.
+func NewEncoder() *Encoder {
+	e := &Encoder{cache: newCache(), enc: make(map[reflect.Type]EncoderFunc)}
+	for t, conv := range DefaultConverters() {
+		e.cache.conv[t] = conv
+	}
+	e.SetTimeLayout(time.RFC3339)
+	return e
+}
+
+// Encoder encodes a struct into a map[string][]string, the inverse of
+// Decoder.
+type Encoder struct {
+	cache *cache
+	enc   map[reflect.Type]EncoderFunc
+}
+
+// RegisterEncoder registers an encoder function for a custom type. Types
+// implementing fmt.Stringer don't need one if the Decoder converter parses
+// the String() output.
+func (e *Encoder) RegisterEncoder(value interface{}, encoderFunc EncoderFunc) {
+	t := reflect.TypeOf(value)
+	e.enc[t] = encoderFunc
+	// The cache skips fields of types without a converter.
+	e.cache.conv[t] = func(string) reflect.Value { return invalidValue }
+}
+
+// SetTimeLayout sets the layout used to format time.Time fields, as used by
+// time.Time.Format. The default is time.RFC3339, matching the Decoder.
+func (e *Encoder) SetTimeLayout(layout string) {
+	e.enc[timeType] = func(v reflect.Value) string {
+		return v.Interface().(time.Time).Format(layout)
+	}
+}
+
+// Encode encodes a struct into a map[string][]string, typically url.Values
+// for a query string.
+//
+// The first parameter must be a struct or a pointer to struct.
+//
+// Keys are written in the dotted notation Decode reads, one entry per
+// element for slices and per key for maps. Nil pointers are skipped.
+func (e *Encoder) Encode(src interface{}, dst map[string][]string) error {
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return errors.New("schema: interface must be a struct or a pointer to struct")
+	}
+	e.encode(v, "", dst)
+	return nil
+}
+
+// encode writes the fields of struct v, prefixing the keys.
+func (e *Encoder) encode(v reflect.Value, prefix string, dst map[string][]string) {
+	for alias, field := range e.cache.get(v.Type()).fields {
+		if field.anon {
+			// Encoded through the promoted fields.
+			continue
+		}
+		fv, ok := embeddedField(v, field)
+		if !ok {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		key := prefix + alias
+		t := fv.Type()
+		switch {
+		case field.ss:
+			// Slices of structs have the index in the path.
+			for i := 0; i < fv.Len(); i++ {
+				if elem, ok := deref(fv.Index(i)); ok {
+					e.encode(elem, key+"."+strconv.Itoa(i)+".", dst)
+				}
+			}
+		case e.cache.converter(t) != nil:
+			// Converted as a whole, even if it is a slice (e.g. net.IP).
+			if t.Kind() != reflect.Slice || !fv.IsNil() {
+				dst[key] = []string{e.format(fv)}
+			}
+		case t.Kind() == reflect.Map:
+			for _, k := range fv.MapKeys() {
+				dst[key+"["+k.String()+"]"] = []string{e.format(fv.MapIndex(k))}
+			}
+		case t.Kind() == reflect.Struct:
+			e.encode(fv, key+".", dst)
+		case t.Kind() == reflect.Slice:
+			values := make([]string, 0, fv.Len())
+			for i := 0; i < fv.Len(); i++ {
+				if elem, ok := deref(fv.Index(i)); ok {
+					values = append(values, e.format(elem))
+				}
+			}
+			if len(values) > 0 {
+				dst[key] = values
+			}
+		}
+	}
+}
+
+// format returns the string representation of a single value.
+func (e *Encoder) format(v reflect.Value) string {
+	if enc := e.enc[v.Type()]; enc != nil {
+		return enc(v)
+	}
+	// Methods with a pointer receiver, like url.URL.String, need an
+	// addressable value.
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	if m, ok := p.Interface().(encoding.TextMarshaler); ok {
+		if text, err := m.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	if s, ok := p.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	}
+	return v.String()
+}
+
+// embeddedField returns the value of a field of struct v, walking the
+// embedded structs of promoted fields. It reports false if one of them is a
+// nil pointer.
+func embeddedField(v reflect.Value, field *fieldInfo) (reflect.Value, bool) {
+	for _, idx := range field.embed {
+		var ok bool
+		if v, ok = deref(v.Field(idx)); !ok {
+			return v, false
+		}
+	}
+	return v.Field(field.idx), true
+}
+
+// deref dereferences a slice element, reporting false for nil pointers.
+func deref(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, false
+		}
+		return v.Elem(), true
+	}
+	return v, true
+}
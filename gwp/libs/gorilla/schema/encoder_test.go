@@ -0,0 +1,118 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type E1 struct {
+	F01 int      `schema:"f1"`
+	F02 *string  `schema:"f2"`
+	F03 []int    `schema:"f3"`
+	F04 []*bool  `schema:"f4"`
+	F05 E2       `schema:"f5"`
+	F06 *E1      `schema:"f6"`
+	F07 []E2     `schema:"f7"`
+	F08 float64  `schema:"f8"`
+	F09 int      `schema:"-"`
+	F10 net.IP   `schema:"f10"`
+	F11 url.URL  `schema:"f11"`
+	F12 uint8    `schema:"f12"`
+	F13 []string `schema:"f13"`
+}
+
+type E2 struct {
+	F01 string        `schema:"f1"`
+	F02 time.Duration `schema:"f2"`
+}
+
+func TestEncode(t *testing.T) {
+	s, b := "two", true
+	u, _ := url.Parse("http://example.com/a?b=c")
+	src := &E1{
+		F01: 1,
+		F02: &s,
+		F03: []int{3, 4},
+		F04: []*bool{&b, nil},
+		F05: E2{F01: "five", F02: 90 * time.Minute},
+		F06: &E1{F01: 6},
+		F07: []E2{{F01: "a"}, {F01: "b"}},
+		F08: 1.5,
+		F09: 9,
+		F10: net.ParseIP("10.0.0.1"),
+		F11: *u,
+		F12: 12,
+	}
+	dst := map[string][]string{}
+	if err := NewEncoder().Encode(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"f1":       {"1"},
+		"f2":       {"two"},
+		"f3":       {"3", "4"},
+		"f4":       {"true"},
+		"f5.f1":    {"five"},
+		"f5.f2":    {"1h30m0s"},
+		"f6.f1":    {"6"},
+		"f6.f5.f1": {""},
+		"f6.f5.f2": {"0s"},
+		"f6.f8":    {"0"},
+		"f6.f11":   {""},
+		"f6.f12":   {"0"},
+		"f7.0.f1":  {"a"},
+		"f7.0.f2":  {"0s"},
+		"f7.1.f1":  {"b"},
+		"f7.1.f2":  {"0s"},
+		"f8":       {"1.5"},
+		"f10":      {"10.0.0.1"},
+		"f11":      {"http://example.com/a?b=c"},
+		"f12":      {"12"},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("Expected %v, got %v", want, dst)
+	}
+
+	// and back
+	d := NewDecoder()
+	d.RegisterConverters(DefaultConverters())
+	delete(dst, "f6.f11")
+	decoded := new(E1)
+	d.Decode(decoded, dst)
+	src.F04 = []*bool{&b}
+	src.F09 = 0
+	if !reflect.DeepEqual(decoded, src) {
+		t.Errorf("Expected round trip to %+v, got %+v", src, decoded)
+	}
+}
+
+func TestEncodeCustomType(t *testing.T) {
+	type point struct{ X, Y int }
+	src := struct {
+		P point
+	}{point{1, 2}}
+	e := NewEncoder()
+	e.RegisterEncoder(point{}, func(v reflect.Value) string {
+		p := v.Interface().(point)
+		return strconv.Itoa(p.X) + "," + strconv.Itoa(p.Y)
+	})
+	dst := map[string][]string{}
+	if err := e.Encode(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst["P"][0] != "1,2" {
+		t.Errorf("Expected P to be encoded as a whole, got %v", dst)
+	}
+
+	if err := e.Encode(1, dst); err == nil {
+		t.Errorf("Expected error encoding a non-struct")
+	}
+}
@@ -6,6 +6,7 @@ package securecookie
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -14,9 +15,11 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hash"
+	"io/ioutil"
 	"strconv"
 	"time"
 )
@@ -36,17 +39,19 @@ type Codec interface {
 // GenerateRandomKey(). The key length must correspond to the block size
 // of the encryption algorithm. For AES, used by default, valid lengths are
 // 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256.
+//
+// Values can instead be encrypted and authenticated in one step with an
+// AEAD cipher such as AES-GCM; see SecureCookie.AEAD(). The hashKey is not
+// needed then.
 func New(hashKey, blockKey []byte) *SecureCookie {
 	s := &SecureCookie{
 		hashKey:   hashKey,
 		blockKey:  blockKey,
 		hashFunc:  sha256.New,
+		sz:        GobSerializer{},
 		maxAge:    86400 * 30,
 		maxLength: 4096,
 	}
-	if hashKey == nil {
-		s.err = errors.New("securecookie: hash key is not set")
-	}
 	if blockKey != nil {
 		s.BlockFunc(aes.NewCipher)
 	}
@@ -60,6 +65,9 @@ type SecureCookie struct {
 	hashFunc  func() hash.Hash
 	blockKey  []byte
 	block     cipher.Block
+	aead      cipher.AEAD
+	compress  bool
+	sz        Serializer
 	maxLength int
 	maxAge    int64
 	minAge    int64
@@ -72,7 +80,11 @@ type SecureCookie struct {
 // MaxLength restricts the maximum length, in bytes, for the cookie value.
 //
 // Default is 4096, which is the maximum value accepted by Internet Explorer.
+// Set it to 0 for no restriction; negative values are an error.
 func (s *SecureCookie) MaxLength(value int) *SecureCookie {
+	if value < 0 {
+		s.err = errors.New("securecookie: max length must not be negative")
+	}
 	s.maxLength = value
 	return s
 }
@@ -115,6 +127,39 @@ func (s *SecureCookie) BlockFunc(f func([]byte) (cipher.Block, error)) *SecureCo
 	return s
 }
 
+// AEAD sets an authenticated encryption cipher, e.g. AES-GCM created with
+// cipher.NewGCM(). When set, it encrypts and authenticates values in one
+// step, replacing the HMAC and the block cipher:
+//
+//	block, _ := aes.NewCipher(blockKey)
+//	gcm, _ := cipher.NewGCM(block)
+//	s := securecookie.New(nil, nil).AEAD(gcm)
+//
+// Values encoded this way can't be decoded without it, and vice versa.
+func (s *SecureCookie) AEAD(aead cipher.AEAD) *SecureCookie {
+	s.aead = aead
+	return s
+}
+
+// Compress sets whether serialized values are compressed with gzip before
+// they are encrypted and signed, to let bigger values fit in MaxLength.
+//
+// Default is false. Values encoded with compression can only be decoded with
+// it, and vice versa.
+func (s *SecureCookie) Compress(value bool) *SecureCookie {
+	s.compress = value
+	return s
+}
+
+// SetSerializer sets the serializer used to encode values.
+//
+// Default is GobSerializer. Values can only be decoded with the serializer
+// they were encoded with.
+func (s *SecureCookie) SetSerializer(sz Serializer) *SecureCookie {
+	s.sz = sz
+	return s
+}
+
 // Encode encodes a cookie value.
 //
 // It decodes, verifies a message authentication code, optionally decrypts and
@@ -128,6 +173,9 @@ func (s *SecureCookie) Encode(name string, value interface{}) (string, error) {
 	if s.err != nil {
 		return "", s.err
 	}
+	if s.aead != nil {
+		return s.encodeAEAD(name, value)
+	}
 	if s.hashKey == nil {
 		s.err = errors.New("securecookie: hash key is not set")
 		return "", s.err
@@ -135,7 +183,7 @@ func (s *SecureCookie) Encode(name string, value interface{}) (string, error) {
 	var err error
 	var b []byte
 	// 1. Serialize.
-	if b, err = serialize(value); err != nil {
+	if b, err = s.serialize(value); err != nil {
 		return "", err
 	}
 	b = encode(b)
@@ -172,6 +220,9 @@ func (s *SecureCookie) Decode(name, value string, dst interface{}) error {
 	if s.err != nil {
 		return s.err
 	}
+	if s.aead != nil {
+		return s.decodeAEAD(name, value, dst)
+	}
 	if s.hashKey == nil {
 		s.err = errors.New("securecookie: hash key is not set")
 		return s.err
@@ -203,29 +254,88 @@ func (s *SecureCookie) Decode(name, value string, dst interface{}) error {
 		return err
 	}
 	// 6. Verify date ranges.
-	var t1 int64
-	if t1, err = strconv.ParseInt(string(parts[0]), 10, 64); err != nil {
-		return errors.New("securecookie: invalid timestamp")
-	}
-	t2 := s.timestamp()
-	if s.minAge != 0 && t1 > t2-s.minAge {
-		return errors.New("securecookie: timestamp is too new")
-	}
-	if s.maxAge != 0 && t1 < t2-s.maxAge {
-		return errors.New("securecookie: expired timestamp")
+	if err = s.checkTimestamp(parts[0]); err != nil {
+		return err
 	}
 	// 7. Deserialize.
 	b, err = decode(parts[1])
 	if err != nil {
 		return err
 	}
-	if err = deserialize(b, dst); err != nil {
+	if err = s.deserialize(b, dst); err != nil {
 		return err
 	}
 	// Done.
 	return nil
 }
 
+// encodeAEAD encodes a cookie value using the AEAD cipher.
+//
+// The sealed value is "date|serialized", with the name as additional data,
+// prepended by the nonce.
+func (s *SecureCookie) encodeAEAD(name string, value interface{}) (string, error) {
+	b, err := s.serialize(value)
+	if err != nil {
+		return "", err
+	}
+	b = []byte(fmt.Sprintf("%d|%s", s.timestamp(), encode(b)))
+	nonce := GenerateRandomKey(s.aead.NonceSize())
+	if nonce == nil {
+		return "", errors.New("securecookie: failed to generate random nonce")
+	}
+	b = encode(s.aead.Seal(nonce, nonce, b, []byte(name)))
+	if s.maxLength != 0 && len(b) > s.maxLength {
+		return "", errors.New("securecookie: the value is too long")
+	}
+	return string(b), nil
+}
+
+// decodeAEAD decodes a cookie value encoded by encodeAEAD.
+func (s *SecureCookie) decodeAEAD(name, value string, dst interface{}) error {
+	if s.maxLength != 0 && len(value) > s.maxLength {
+		return errors.New("securecookie: the value is too long")
+	}
+	b, err := decode([]byte(value))
+	if err != nil {
+		return err
+	}
+	size := s.aead.NonceSize()
+	if len(b) < size {
+		return errors.New("securecookie: the value is not valid")
+	}
+	if b, err = s.aead.Open(nil, b[:size], b[size:], []byte(name)); err != nil {
+		return errors.New("securecookie: the value is not valid")
+	}
+	parts := bytes.SplitN(b, []byte("|"), 2)
+	if len(parts) != 2 {
+		return errors.New("securecookie: invalid value")
+	}
+	if err = s.checkTimestamp(parts[0]); err != nil {
+		return err
+	}
+	if b, err = decode(parts[1]); err != nil {
+		return err
+	}
+	return s.deserialize(b, dst)
+}
+
+// checkTimestamp verifies that an encoded timestamp is within the minAge
+// and maxAge limits.
+func (s *SecureCookie) checkTimestamp(value []byte) error {
+	t1, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return errors.New("securecookie: invalid timestamp")
+	}
+	t2 := s.timestamp()
+	if s.minAge != 0 && t1 > t2-s.minAge {
+		return errors.New("securecookie: timestamp is too new")
+	}
+	if s.maxAge != 0 && t1 < t2-s.maxAge {
+		return errors.New("securecookie: expired timestamp")
+	}
+	return nil
+}
+
 // timestamp returns the current timestamp, in seconds.
 //
 // For testing purposes, the function that generates the timestamp can be
@@ -293,6 +403,90 @@ func decrypt(block cipher.Block, value []byte) ([]byte, error) {
 
 // Serialization --------------------------------------------------------------
 
+// serialize encodes a value using the serializer, compressing it if enabled.
+func (s *SecureCookie) serialize(src interface{}) ([]byte, error) {
+	b, err := s.sz.Serialize(src)
+	if err != nil || !s.compress {
+		return b, err
+	}
+	return compress(b)
+}
+
+// deserialize decodes a value using the serializer, decompressing it first if
+// enabled.
+func (s *SecureCookie) deserialize(src []byte, dst interface{}) error {
+	if s.compress {
+		var err error
+		if src, err = decompress(src); err != nil {
+			return err
+		}
+	}
+	return s.sz.Deserialize(src, dst)
+}
+
+// Serializer encodes values to bytes and back.
+type Serializer interface {
+	Serialize(src interface{}) ([]byte, error)
+	Deserialize(src []byte, dst interface{}) error
+}
+
+// GobSerializer encodes values using encoding/gob. Custom types must be
+// registered with gob.Register().
+type GobSerializer struct{}
+
+// Serialize encodes a value using gob.
+func (GobSerializer) Serialize(src interface{}) ([]byte, error) {
+	return serialize(src)
+}
+
+// Deserialize decodes a value using gob.
+func (GobSerializer) Deserialize(src []byte, dst interface{}) error {
+	return deserialize(src, dst)
+}
+
+// JSONSerializer encodes values using encoding/json. They can be read outside
+// Go, and don't need to be registered, but lose type fidelity: in
+// interface{} values numbers come back as float64, and structs as
+// map[string]interface{}.
+//
+// A map[interface{}]interface{}, like session values, is encoded as a JSON
+// object, so its keys must be strings.
+type JSONSerializer struct{}
+
+// Serialize encodes a value using json.
+func (JSONSerializer) Serialize(src interface{}) ([]byte, error) {
+	if m, ok := src.(map[interface{}]interface{}); ok {
+		obj := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("securecookie: JSON can't encode map key %v of type %T", k, k)
+			}
+			obj[key] = v
+		}
+		src = obj
+	}
+	return json.Marshal(src)
+}
+
+// Deserialize decodes a value using json.
+func (JSONSerializer) Deserialize(src []byte, dst interface{}) error {
+	if m, ok := dst.(*map[interface{}]interface{}); ok {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(src, &obj); err != nil {
+			return err
+		}
+		if *m == nil {
+			*m = make(map[interface{}]interface{}, len(obj))
+		}
+		for k, v := range obj {
+			(*m)[k] = v
+		}
+		return nil
+	}
+	return json.Unmarshal(src, dst)
+}
+
 // serialize encodes a value using gob.
 func serialize(src interface{}) ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -312,6 +506,31 @@ func deserialize(src []byte, dst interface{}) error {
 	return nil
 }
 
+// Compression ----------------------------------------------------------------
+
+// compress compresses a value using gzip.
+func compress(value []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses a value compressed by compress.
+func decompress(value []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
 // Encoding -------------------------------------------------------------------
 
 // encode encodes a value using base64.
@@ -378,10 +597,19 @@ func EncodeMulti(name string, value interface{},
 // key rotation.
 func DecodeMulti(name string, value string, dst interface{},
 	codecs ...Codec) error {
-	for _, codec := range codecs {
+	_, err := DecodeMultiIndex(name, value, dst, codecs...)
+	return err
+}
+
+// DecodeMultiIndex is like DecodeMulti, and also returns the index of the
+// codec which decoded the value. An index above 0 means that the value was
+// encoded with an old key, and should be encoded again.
+func DecodeMultiIndex(name string, value string, dst interface{},
+	codecs ...Codec) (int, error) {
+	for i, codec := range codecs {
 		if err := codec.Decode(name, value, dst); err == nil {
-			return nil
+			return i, nil
 		}
 	}
-	return errors.New("securecookie: the value could not be decoded")
+	return -1, errors.New("securecookie: the value could not be decoded")
 }
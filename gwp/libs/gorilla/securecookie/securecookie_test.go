@@ -6,11 +6,13 @@ package securecookie
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 )
 
 var testCookies = []interface{}{
@@ -150,3 +152,183 @@ func TestCustomType(t *testing.T) {
 		t.Fatalf("Expected %#v, got %#v", src, dst)
 	}
 }
+
+func TestAEAD(t *testing.T) {
+	newGCM := func(key string) cipher.AEAD {
+		block, err := aes.NewCipher([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gcm
+	}
+	s1 := New(nil, nil).AEAD(newGCM("1234567890123456"))
+	s2 := New(nil, nil).AEAD(newGCM("6543210987654321"))
+
+	encoded, err := s1.Encode("sid", &FooBar{42, "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := &FooBar{}
+	if err = s1.Decode("sid", encoded, dst); err != nil || dst.Foo != 42 || dst.Bar != "bar" {
+		t.Fatalf("Expected round trip, got %#v, %v", dst, err)
+	}
+
+	// Another key, another name or a changed value must fail.
+	if err = s2.Decode("sid", encoded, &FooBar{}); err == nil {
+		t.Errorf("Expected failure decoding with another key.")
+	}
+	if err = s1.Decode("other", encoded, &FooBar{}); err == nil {
+		t.Errorf("Expected failure decoding with another name.")
+	}
+	b, _ := decode([]byte(encoded))
+	b[len(b)-1] ^= 1
+	if err = s1.Decode("sid", string(encode(b)), &FooBar{}); err == nil {
+		t.Errorf("Expected failure decoding a tampered value.")
+	}
+
+	// Values of the CTR+HMAC chain are not accepted, and vice versa.
+	s3 := New([]byte("12345"), []byte("1234567890123456"))
+	if err = s3.Decode("sid", encoded, &FooBar{}); err == nil {
+		t.Errorf("Expected failure decoding an AEAD value without AEAD.")
+	}
+	encoded, _ = s3.Encode("sid", &FooBar{42, "bar"})
+	if err = s1.Decode("sid", encoded, &FooBar{}); err == nil {
+		t.Errorf("Expected failure decoding a CTR+HMAC value with AEAD.")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	value := map[string]interface{}{}
+	for i := 0; i < 200; i++ {
+		value[fmt.Sprintf("key-%d", i)] = "a value repeated in every key"
+	}
+	plain := New([]byte("12345"), []byte("1234567890123456")).MaxLength(0)
+	compressed := New([]byte("12345"), []byte("1234567890123456")).MaxLength(0).Compress(true)
+
+	encoded1, err := plain.Encode("sid", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded2, err := compressed.Encode("sid", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded2) >= len(encoded1)/2 {
+		t.Errorf("Expected compression to shrink %d bytes, got %d", len(encoded1), len(encoded2))
+	}
+	dst := make(map[string]interface{})
+	if err = compressed.Decode("sid", encoded2, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != len(value) || dst["key-7"] != value["key-7"] {
+		t.Errorf("Expected %d values, got %d", len(value), len(dst))
+	}
+
+	// A too long value fits once compressed.
+	if _, err = plain.MaxLength(4096).Encode("sid", value); err == nil {
+		t.Errorf("Expected the uncompressed value to be too long")
+	}
+	if _, err = compressed.MaxLength(4096).Encode("sid", value); err != nil {
+		t.Errorf("Expected the compressed value to fit, got %v", err)
+	}
+}
+
+func TestSerializers(t *testing.T) {
+	for _, sz := range []Serializer{GobSerializer{}, JSONSerializer{}} {
+		s := New([]byte("12345"), []byte("1234567890123456")).SetSerializer(sz)
+		// Session values.
+		value := map[interface{}]interface{}{"foo": "bar", "baz": 128}
+		encoded, err := s.Encode("sid", value)
+		if err != nil {
+			t.Fatalf("%T: %v", sz, err)
+		}
+		dst := make(map[interface{}]interface{})
+		if err = s.Decode("sid", encoded, &dst); err != nil {
+			t.Fatalf("%T: %v", sz, err)
+		}
+		if dst["foo"] != "bar" || fmt.Sprint(dst["baz"]) != "128" {
+			t.Errorf("%T: expected %v, got %v", sz, value, dst)
+		}
+		// Structs.
+		encoded, _ = s.Encode("sid", &FooBar{42, "bar"})
+		foobar := &FooBar{}
+		if err = s.Decode("sid", encoded, foobar); err != nil || foobar.Foo != 42 || foobar.Bar != "bar" {
+			t.Errorf("%T: expected round trip, got %#v, %v", sz, foobar, err)
+		}
+	}
+
+	// JSON loses the type of interface{} values, and can't have other keys.
+	s := New([]byte("12345"), nil).SetSerializer(JSONSerializer{})
+	encoded, _ := s.Encode("sid", map[interface{}]interface{}{"baz": 128})
+	dst := make(map[interface{}]interface{})
+	s.Decode("sid", encoded, &dst)
+	if _, ok := dst["baz"].(float64); !ok {
+		t.Errorf("Expected JSON numbers as float64, got %T", dst["baz"])
+	}
+	if _, err := s.Encode("sid", map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Errorf("Expected an error for a non-string key")
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	s := New([]byte("12345"), nil).MaxLength(0)
+	encoded, err := s.Encode("sid", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without encryption, the length is the same for every encoding.
+	if _, err = s.MaxLength(len(encoded)).Encode("sid", "value"); err != nil {
+		t.Errorf("Expected value of %d bytes to fit, got %v", len(encoded), err)
+	}
+	if err = s.Decode("sid", encoded, new(string)); err != nil {
+		t.Errorf("Expected value of %d bytes to decode, got %v", len(encoded), err)
+	}
+	if _, err = s.MaxLength(len(encoded)-1).Encode("sid", "value"); err == nil {
+		t.Errorf("Expected value of %d bytes to be too long", len(encoded))
+	}
+	if err = s.Decode("sid", encoded, new(string)); err == nil {
+		t.Errorf("Expected value of %d bytes to be too long to decode", len(encoded))
+	}
+	if _, err = New([]byte("12345"), nil).MaxLength(-1).Encode("sid", "value"); err == nil {
+		t.Errorf("Expected an error for a negative max length")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	now := time.Now().Unix()
+	s := New([]byte("12345"), nil)
+	s.timeFunc = func() int64 { return now - 86400*40 }
+	encoded, _ := s.Encode("sid", "value")
+	s.timeFunc = func() int64 { return now }
+	if err := s.Decode("sid", encoded, new(string)); err == nil {
+		t.Errorf("Expected a 40 days old value to expire by default")
+	}
+	if err := s.MaxAge(86400*60).Decode("sid", encoded, new(string)); err != nil {
+		t.Errorf("Expected a 40 days old value to decode with MaxAge of 60 days, got %v", err)
+	}
+}
+
+func TestCodecsStructValue(t *testing.T) {
+	// Codecs sign and encrypt any value, e.g. a token for a signed URL.
+	type token struct {
+		User    string
+		Expires int64
+	}
+	codecs := CodecsFromPairs([]byte("12345"), []byte("1234567890123456"))
+	src := token{"bob", 1400000000}
+	encoded, err := EncodeMulti("download", src, codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst token
+	if err = DecodeMulti("download", encoded, &dst, codecs...); err != nil || dst != src {
+		t.Errorf("Expected %#v, got %#v, %v", src, dst, err)
+	}
+	if err = DecodeMulti("upload", encoded, &dst, codecs...); err == nil {
+		t.Errorf("Expected failure decoding under another name.")
+	}
+}
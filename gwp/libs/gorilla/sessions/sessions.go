@@ -8,12 +8,16 @@ import (
 	"encoding/gob"
 	"fmt"
 	"net/http"
+	"reflect"
 	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
 )
 
 // Default flashes key.
 const flashesKey = "_flash"
 
+// Default leveled flashes key.
+const levelFlashesKey = "_flash_levels"
+
 // Options --------------------------------------------------------------------
 
 // Options stores configuration for a session or session store.
@@ -28,6 +32,28 @@ type Options struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	// SameSite restricts sending the cookie with cross-site requests.
+	// Zero means http.SameSiteLaxMode.
+	SameSite http.SameSite
+}
+
+// NewCookie returns an http.Cookie with the options set, for stores to add
+// to the response.
+func NewCookie(name, value string, options *Options) *http.Cookie {
+	sameSite := options.SameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+		SameSite: sameSite,
+	}
 }
 
 // Session --------------------------------------------------------------------
@@ -47,8 +73,30 @@ type Session struct {
 	Values  map[interface{}]interface{}
 	Options *Options
 	IsNew   bool
+	// Rotated is set by stores when the session was decoded with one of the
+	// old keys, so it should be saved again to be encoded with the new key.
+	// Saving the session resets it.
+	Rotated bool
 	store   Store
 	name    string
+	loaded  map[interface{}]interface{}
+}
+
+// MarkLoaded records a copy of the values as they were read from the backend.
+// Stores call it after loading a session so that Modified can tell whether
+// the session needs to be written back.
+func (s *Session) MarkLoaded(values map[interface{}]interface{}) {
+	s.loaded = values
+}
+
+// Modified reports whether the session values differ from the ones recorded
+// with MarkLoaded. A session that was never loaded is modified only if it
+// has values.
+func (s *Session) Modified() bool {
+	if len(s.loaded) == 0 && len(s.Values) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(s.loaded, s.Values)
 }
 
 // Flashes returns a slice of flash messages from the session.
@@ -85,6 +133,60 @@ func (s *Session) AddFlash(value interface{}, vars ...string) {
 	s.Values[key] = append(flashes, value)
 }
 
+// AddFlashWithLevel adds a flash message of the given level, e.g. "info" or
+// "error", to the session. Leveled flashes are kept apart from the ones added
+// with AddFlash, and are read with FlashesByLevel.
+//
+// A single variadic argument is accepted, and it is optional: it defines
+// the flash key. If not defined "_flash_levels" is used by default.
+func (s *Session) AddFlashWithLevel(level string, value interface{}, vars ...string) {
+	key := levelFlashesKey
+	if len(vars) > 0 {
+		key = vars[0]
+	}
+	// A new map, so the session is seen as modified.
+	flashes := map[string][]interface{}{}
+	for l, values := range levelFlashes(s.Values[key]) {
+		flashes[l] = values
+	}
+	flashes[level] = append(flashes[level], value)
+	s.Values[key] = flashes
+}
+
+// FlashesByLevel returns the flash messages added with AddFlashWithLevel,
+// grouped by level, and drops them from the session.
+//
+// A single variadic argument is accepted, and it is optional: it defines
+// the flash key. If not defined "_flash_levels" is used by default.
+func (s *Session) FlashesByLevel(vars ...string) map[string][]interface{} {
+	key := levelFlashesKey
+	if len(vars) > 0 {
+		key = vars[0]
+	}
+	v, ok := s.Values[key]
+	if !ok {
+		return nil
+	}
+	delete(s.Values, key)
+	return levelFlashes(v)
+}
+
+// levelFlashes returns the leveled flashes stored in a session value. Values
+// decoded from JSON hold them as map[string]interface{}.
+func levelFlashes(v interface{}) map[string][]interface{} {
+	switch v := v.(type) {
+	case map[string][]interface{}:
+		return v
+	case map[string]interface{}:
+		flashes := make(map[string][]interface{}, len(v))
+		for level, values := range v {
+			flashes[level], _ = values.([]interface{})
+		}
+		return flashes
+	}
+	return nil
+}
+
 // Save is a convenience method to save this session. It is the same as calling
 // store.Save(request, response, session)
 func (s *Session) Save(r *http.Request, w http.ResponseWriter) error {
@@ -173,6 +275,7 @@ func (s *Registry) Save(w http.ResponseWriter) error {
 
 func init() {
 	gob.Register([]interface{}{})
+	gob.Register(map[string][]interface{}{})
 }
 
 // Save saves all sessions used during the current request.
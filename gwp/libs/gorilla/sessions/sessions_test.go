@@ -6,9 +6,19 @@ package sessions
 
 import (
 	"bytes"
+	"crypto/sha512"
 	"encoding/gob"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
 )
 
 // ----------------------------------------------------------------------------
@@ -195,3 +205,570 @@ func TestFlashes(t *testing.T) {
 func init() {
 	gob.Register(FlashMessage{})
 }
+
+// newTestFSStore returns a FilesystemStore saving to a new temporary
+// directory, with the given keys or "secret-key". The returned func removes
+// the directory.
+func newTestFSStore(t *testing.T, keyPairs ...[]byte) (*FilesystemStore, func()) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	if len(keyPairs) == 0 {
+		keyPairs = [][]byte{[]byte("secret-key")}
+	}
+	return NewFilesystemStore(dir, keyPairs...), func() { os.RemoveAll(dir) }
+}
+
+func TestMaxLength(t *testing.T) {
+	var req *http.Request
+	var rsp *ResponseRecorder
+	var session *Session
+	var err error
+
+	large := strings.Repeat("x", 8192)
+
+	// Cookie store rejects values over 4096 bytes.
+	cookieStore := NewCookieStore([]byte("secret-key"))
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp = NewRecorder()
+	if session, err = cookieStore.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["large"] = large
+	if err = session.Save(req, rsp); err == nil {
+		t.Errorf("Expected error saving a large cookie session")
+	}
+
+	// Filesystem store accepts them.
+	fsStore, cleanup := newTestFSStore(t)
+	defer cleanup()
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp = NewRecorder()
+	if session, err = fsStore.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["large"] = large
+	if err = session.Save(req, rsp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookies := rsp.Header()["Set-Cookie"]
+	if len(cookies) != 1 {
+		t.Fatalf("No cookies. Header: %v", rsp.Header())
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	if session, err = fsStore.Get(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	if session.Values["large"] != large {
+		t.Errorf("Expected large value to be decoded")
+	}
+
+	// Unless limited explicitly.
+	fsStore.MaxLength(4096)
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	if session, err = fsStore.New(req, "session-key"); err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	session.Values["large"] = large
+	if err = fsStore.Save(req, NewRecorder(), session); err == nil {
+		t.Errorf("Expected error saving a large session")
+	}
+}
+
+func TestSameSite(t *testing.T) {
+	fsStore, cleanup := newTestFSStore(t)
+	defer cleanup()
+
+	stores := []Store{
+		NewCookieStore([]byte("secret-key")),
+		fsStore,
+	}
+	for i, store := range stores {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["a"] = "b"
+		if err := store.Save(req, rsp, session); err != nil {
+			t.Fatalf("%d: Error saving session: %v", i, err)
+		}
+		if c := rsp.Header().Get("Set-Cookie"); !strings.Contains(c, "; SameSite=Lax") {
+			t.Errorf("%d: Expected SameSite=Lax by default, got %q", i, c)
+		}
+
+		rsp = NewRecorder()
+		session.Options = &Options{Path: "/", SameSite: http.SameSiteStrictMode}
+		if err := store.Save(req, rsp, session); err != nil {
+			t.Fatalf("%d: Error saving session: %v", i, err)
+		}
+		if c := rsp.Header().Get("Set-Cookie"); !strings.Contains(c, "; SameSite=Strict") {
+			t.Errorf("%d: Expected SameSite=Strict, got %q", i, c)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	store, cleanup := newTestFSStore(t)
+	defer cleanup()
+	dir := store.path
+	var err error
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"session_old", "session_new", "other_old", "session_old.tmp42"} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(name, "_old") {
+			os.Chtimes(filename, old, old)
+		}
+	}
+
+	if removed, err := store.Cleanup(time.Hour); removed != 1 || err != nil {
+		t.Errorf("Expected 1 file removed, got %d, %v", removed, err)
+	}
+	for name, exists := range map[string]bool{"session_old": false, "session_new": true, "other_old": true,
+		"session_old.tmp42": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got %v", name, exists, err)
+		}
+	}
+
+	// periodically
+	filename := filepath.Join(dir, "session_new")
+	os.Chtimes(filename, old, old)
+	stop := store.StartCleanup(10*time.Millisecond, time.Hour)
+	defer stop()
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(filename); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected session_new to be removed by StartCleanup, got %v", err)
+	}
+	stop()
+}
+
+func TestFilesystemStoreConcurrency(t *testing.T) {
+	store, cleanup := newTestFSStore(t)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+			session, _ := store.New(req, "session-key")
+			for j := 0; j < 5; j++ {
+				session.Values["n"] = i*10 + j
+				if err := store.Save(req, NewRecorder(), session); err != nil {
+					errs <- err
+					return
+				}
+			}
+			loaded := NewSession(store, "session-key")
+			loaded.ID = session.ID
+			if err := store.load(loaded); err != nil {
+				errs <- err
+			} else if loaded.Values["n"] != i*10+4 {
+				errs <- fmt.Errorf("session %d: expected %d, got %v", i, i*10+4, loaded.Values["n"])
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if fileLock("abc") != fileLock("abc") {
+		t.Errorf("Expected the same lock for the same session id")
+	}
+}
+
+func TestFilesystemStoreAtomicSave(t *testing.T) {
+	store, cleanup := newTestFSStore(t)
+	defer cleanup()
+	dir := store.path
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	load := func() (*Session, error) {
+		loaded := NewSession(store, "session-key")
+		loaded.ID = session.ID
+		return loaded, store.load(loaded)
+	}
+
+	// a shorter value replaces a longer one
+	long, short := strings.Repeat("x", 1000), "y"
+	for _, v := range []string{long, short} {
+		session.Values["v"] = v
+		if err := store.Save(req, NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	if loaded, err := load(); err != nil || loaded.Values["v"] != short {
+		t.Errorf("Expected %q, got %v, %v", short, loaded.Values["v"], err)
+	}
+
+	// a write interrupted halfway doesn't touch the session file
+	partial := filepath.Join(dir, "session_"+session.ID+".tmp123")
+	if err := ioutil.WriteFile(partial, []byte("MTM2NDY4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := load(); err != nil || loaded.Values["v"] != short {
+		t.Errorf("Expected %q after a partial write, got %v, %v", short, loaded.Values["v"], err)
+	}
+
+	// readers not taking the lock only see complete files
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			session.Values["v"] = []string{long, short}[i%2]
+			store.Save(req, NewRecorder(), session)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		_, data, err := readFile(store.filename(session))
+		if err != nil {
+			t.Fatalf("Error reading session file: %v", err)
+		}
+		values := make(map[interface{}]interface{})
+		if err := securecookie.DecodeMulti("session-key", string(data), &values, store.Codecs...); err != nil {
+			t.Fatalf("Expected a complete session file, got %v", err)
+		}
+	}
+}
+
+func TestRotated(t *testing.T) {
+	oldKey, newKey := []byte("old-secret-key"), []byte("new-secret-key")
+	fsStore, cleanup := newTestFSStore(t, oldKey)
+	defer cleanup()
+
+	stores := []struct{ old, both, current Store }{
+		{
+			NewCookieStore(oldKey),
+			NewCookieStore(newKey, nil, oldKey, nil),
+			NewCookieStore(newKey),
+		},
+		{
+			fsStore,
+			NewFilesystemStore(fsStore.path, newKey, nil, oldKey, nil),
+			NewFilesystemStore(fsStore.path, newKey),
+		},
+	}
+	for i, st := range stores {
+		get := func(store Store, cookie string) *Session {
+			req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+			req.Header.Add("Cookie", cookie)
+			session, err := store.New(req, "session-key")
+			if err != nil {
+				t.Fatalf("%d: Error getting session: %v", i, err)
+			}
+			return session
+		}
+		save := func(store Store, session *Session) string {
+			req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+			rsp := NewRecorder()
+			if err := store.Save(req, rsp, session); err != nil {
+				t.Fatalf("%d: Error saving session: %v", i, err)
+			}
+			return rsp.Header().Get("Set-Cookie")
+		}
+
+		session := get(st.old, "")
+		session.Values["a"] = "b"
+		cookie := save(st.old, session)
+
+		// read with the old key, re-saved with the new one
+		session = get(st.both, cookie)
+		if !session.Rotated || session.Values["a"] != "b" {
+			t.Errorf("%d: Expected rotated session, got %v %v", i, session.Rotated, session.Values)
+		}
+		cookie = save(st.both, session)
+		if session.Rotated {
+			t.Errorf("%d: Expected Rotated to be reset by Save", i)
+		}
+		session = get(st.current, cookie)
+		if session.Rotated || session.Values["a"] != "b" {
+			t.Errorf("%d: Expected session encoded with the new key, got %v %v", i, session.Rotated, session.Values)
+		}
+	}
+}
+
+func TestStoreMaxAge(t *testing.T) {
+	cookieStore := NewCookieStore([]byte("secret-key"))
+	fsStore, cleanup := newTestFSStore(t)
+	defer cleanup()
+	cookieStore.MaxAge(86400 * 90)
+	fsStore.MaxAge(86400 * 90)
+
+	for i, store := range []Store{cookieStore, fsStore} {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["a"] = "b"
+		if err := store.Save(req, rsp, session); err != nil {
+			t.Fatalf("%d: Error saving session: %v", i, err)
+		}
+		if c := rsp.Header().Get("Set-Cookie"); !strings.Contains(c, "Max-Age=7776000") {
+			t.Errorf("%d: Expected Max-Age of 90 days, got %q", i, c)
+		}
+	}
+}
+
+func TestTouch(t *testing.T) {
+	fsStore, cleanup := newTestFSStore(t)
+	defer cleanup()
+	var err error
+	clock := time.Now().Add(-time.Hour).Truncate(time.Second)
+	SetClock(func() time.Time { return clock })
+	defer SetClock(nil)
+
+	stores := []Store{
+		NewCookieStore([]byte("secret-key")),
+		fsStore,
+	}
+	for i, store := range stores {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values["a"] = "b"
+		if err := store.Save(req, NewRecorder(), session); err != nil {
+			t.Fatalf("%d: Error saving session: %v", i, err)
+		}
+
+		// changes which were not saved are not stored by Touch
+		session.Values["a"] = "c"
+		clock = clock.Add(time.Minute)
+		rsp := NewRecorder()
+		if err := store.(Toucher).Touch(req, rsp, session); err != nil {
+			t.Fatalf("%d: Error touching session: %v", i, err)
+		}
+		cookie := rsp.Header().Get("Set-Cookie")
+		if !strings.Contains(cookie, "Max-Age=2592000") {
+			t.Errorf("%d: Expected cookie to be sent again, got %q", i, cookie)
+		}
+		req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		if session, err = store.New(req, "session-key"); err != nil || session.Values["a"] != "b" {
+			t.Errorf("%d: Expected touched session, got %v, %v", i, session.Values, err)
+		}
+		if fs, ok := store.(*FilesystemStore); ok {
+			if fi, err := os.Stat(fs.filename(session)); err != nil {
+				t.Errorf("Error reading session file: %v", err)
+			} else if !fi.ModTime().Equal(clock) {
+				t.Errorf("Expected file modification time %v, got %v", clock, fi.ModTime())
+			}
+		}
+	}
+
+	// a cookie session which was never loaded or saved is left alone
+	store := NewCookieStore([]byte("secret-key"))
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["a"] = "b"
+	rsp := NewRecorder()
+	if err := store.Touch(req, rsp, session); err != nil || rsp.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Expected new session not to be touched, got %q, %v", rsp.Header().Get("Set-Cookie"), err)
+	}
+}
+
+func TestFilesystemStoreExpired(t *testing.T) {
+	clock := time.Now().Add(-time.Hour).Truncate(time.Second)
+	SetClock(func() time.Time { return clock })
+	defer SetClock(nil)
+
+	store, cleanup := newTestFSStore(t)
+	defer cleanup()
+	var err error
+	store.MaxAge(60)
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["a"] = "b"
+	rsp := NewRecorder()
+	if err := store.Save(req, rsp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	id := session.ID
+
+	clock = clock.Add(2 * time.Minute)
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", rsp.Header().Get("Set-Cookie"))
+	session, err = store.New(req, "session-key")
+	if err != ErrSessionExpired || !session.IsNew || session.ID != "" || len(session.Values) != 0 {
+		t.Fatalf("Expected expired session with no id, got %q %v, %v", session.ID, session.Values, err)
+	}
+
+	// values of the new session are not saved under the expired id
+	session.Values["c"] = "d"
+	if err := store.Save(req, NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if session.ID == id {
+		t.Errorf("Expected new session id, got the expired one")
+	}
+}
+
+func TestFlashesByLevel(t *testing.T) {
+	jsonStore := NewCookieStore([]byte("secret-key"))
+	for _, c := range jsonStore.Codecs {
+		c.(*securecookie.SecureCookie).SetSerializer(securecookie.JSONSerializer{})
+	}
+	for i, store := range []*CookieStore{NewCookieStore([]byte("secret-key")), jsonStore} {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.AddFlash("plain")
+		session.AddFlashWithLevel("info", "saved")
+		session.AddFlashWithLevel("error", "failed")
+		session.AddFlashWithLevel("info", "sent")
+		if err := store.Save(req, rsp, session); err != nil {
+			t.Fatalf("%d: Error saving session: %v", i, err)
+		}
+
+		req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", rsp.Header().Get("Set-Cookie"))
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("%d: Error getting session: %v", i, err)
+		}
+		flashes := session.FlashesByLevel()
+		if fmt.Sprint(flashes) != "map[error:[failed] info:[saved sent]]" {
+			t.Errorf("%d: Expected flashes grouped by level, got %v", i, flashes)
+		}
+		if flashes = session.FlashesByLevel(); flashes != nil {
+			t.Errorf("%d: Expected dumped flashes, got %v", i, flashes)
+		}
+		if plain := session.Flashes(); len(plain) != 1 || plain[0] != "plain" {
+			t.Errorf("%d: Expected plain flash to be kept apart, got %v", i, plain)
+		}
+	}
+}
+
+func TestIDLength(t *testing.T) {
+	store, cleanup := newTestFSStore(t)
+	defer cleanup()
+	if err := store.IDLength(8); err != ErrBadIDLength {
+		t.Errorf("Expected ErrBadIDLength, got %v", err)
+	}
+
+	for _, l := range []int{0, 32} {
+		if l != 0 {
+			if err := store.IDLength(l); err != nil {
+				t.Fatalf("Error setting id length %d: %v", l, err)
+			}
+		}
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values["a"] = "b"
+		if err := store.Save(req, NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		want := 2 * l
+		if l == 0 {
+			want = 48
+		}
+		if len(session.ID) != want {
+			t.Errorf("Expected id of %d characters, got %q", want, session.ID)
+		}
+	}
+}
+
+func TestHashFunc(t *testing.T) {
+	sha256Store := NewCookieStore([]byte("secret-key"))
+	sha512Store := NewCookieStore([]byte("secret-key"))
+	sha512Store.HashFunc(sha512.New)
+
+	save := func(store *CookieStore) string {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["a"] = "b"
+		if err := store.Save(req, rsp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return rsp.Header().Get("Set-Cookie")
+	}
+	load := func(store *CookieStore, cookie string) (*Session, error) {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		return store.New(req, "session-key")
+	}
+
+	if session, err := load(sha512Store, save(sha512Store)); err != nil || session.Values["a"] != "b" {
+		t.Errorf("Expected SHA-512 session to round trip, got %v, %v", session.Values, err)
+	}
+	if _, err := load(sha512Store, save(sha256Store)); err == nil {
+		t.Errorf("Expected SHA-256 session to fail with a SHA-512 store")
+	}
+}
+
+func TestChunk(t *testing.T) {
+	store := NewCookieStore([]byte("secret-key"))
+	store.Chunk(3)
+	large := fmt.Sprintf("%x", securecookie.GenerateRandomKey(2500))
+
+	save := func(req *http.Request, value string) *ResponseRecorder {
+		rsp := NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["large"] = value
+		if err := store.Save(req, rsp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return rsp
+	}
+	cookies := func(rsp *ResponseRecorder) map[string]*http.Cookie {
+		m := make(map[string]*http.Cookie)
+		for _, c := range (&http.Response{Header: rsp.Header()}).Cookies() {
+			m[c.Name] = c
+		}
+		return m
+	}
+
+	// split in three cookies
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	set := cookies(save(req, large))
+	if len(set) != 3 || set["session-key_0"] == nil || set["session-key_2"] == nil {
+		t.Fatalf("Expected three chunks, got %v", set)
+	}
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	for _, c := range set {
+		req.AddCookie(c)
+	}
+	session, err := store.New(req, "session-key")
+	if err != nil || session.Values["large"] != large {
+		t.Fatalf("Expected chunks to be joined, got %v", err)
+	}
+
+	// a short value goes in a single cookie, and the chunks are expired
+	set = cookies(save(req, "small"))
+	if len(set) != 4 || set["session-key"] == nil || set["session-key_1"].MaxAge != -1 {
+		t.Errorf("Expected one cookie and three expired chunks, got %v", set)
+	}
+
+	// too long
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	session, _ = store.New(req, "session-key")
+	session.Values["large"] = large + large
+	if err = store.Save(req, NewRecorder(), session); err != ErrMaxLength {
+		t.Errorf("Expected ErrMaxLength, got %v", err)
+	}
+
+	// turning splitting off keeps the MaxLength set
+	store = NewCookieStore([]byte("secret-key"))
+	store.MaxLength(8192)
+	store.Chunk(1)
+	set = cookies(save(req, large[:4000]))
+	if len(set) != 1 || set["session-key"] == nil {
+		t.Errorf("Expected one cookie, got %v", set)
+	}
+}
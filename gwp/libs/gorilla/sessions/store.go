@@ -5,11 +5,18 @@
 package sessions
 
 import (
-	"io"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"fmt"
+	"time"
 
 	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
 )
@@ -21,6 +28,43 @@ type Store interface {
 	Save(r *http.Request, w http.ResponseWriter, s *Session) error
 }
 
+// Pinger is implemented by stores which can check they are usable, e.g.
+// that a server keeping the session values can be reached. Servers can call
+// Ping at startup to find a broken store before the first request does.
+type Pinger interface {
+	Ping() error
+}
+
+// Toucher is implemented by stores supporting sliding expiration. Touch
+// extends the lifetime of a stored session, in the backend and in the
+// cookie, without saving its values.
+type Toucher interface {
+	Touch(r *http.Request, w http.ResponseWriter, s *Session) error
+}
+
+// ErrSessionExpired is returned when loading a session which was not saved
+// or touched for longer than its MaxAge.
+var ErrSessionExpired = errors.New("sessions: session expired")
+
+// now is the clock against which session expiry is checked, see SetClock.
+var now = time.Now
+
+// SetClock sets the clock of session expiry, for the stores of this package
+// and of packages built on it. Tests can use it to move time forward without
+// sleeping. Passing nil restores time.Now.
+func SetClock(clock func() time.Time) {
+	if clock == nil {
+		clock = time.Now
+	}
+	now = clock
+}
+
+// Now returns the current time of the clock set with SetClock. Stores built
+// on this package check session expiry against it.
+func Now() time.Time {
+	return now()
+}
+
 // CookieStore ----------------------------------------------------------------
 
 // NewCookieStore returns a new CookieStore.
@@ -37,7 +81,7 @@ type Store interface {
 // AES-128, AES-192, or AES-256 modes.
 //
 // Use the convenience function securecookie.GenerateRandomKey() to create
-// strong keys.
+// strong keys. Use CheckEncryption() to make sure the keys encrypt sessions.
 func NewCookieStore(keyPairs ...[]byte) *CookieStore {
 	return &CookieStore{
 		Codecs: securecookie.CodecsFromPairs(keyPairs...),
@@ -48,10 +92,77 @@ func NewCookieStore(keyPairs ...[]byte) *CookieStore {
 	}
 }
 
+// ErrNoEncryptionKey is returned by CheckEncryption.
+var ErrNoEncryptionKey = errors.New("sessions: no encryption key set, session values are signed but not encrypted")
+
+// CheckEncryption returns ErrNoEncryptionKey if new sessions wouldn't be
+// encrypted with the given keys, that is, if the first pair has no
+// encryption key. For a CookieStore this means session values can be read
+// by anyone seeing the cookie, as they're only base64 encoded.
+//
+// It's meant to be called at configuration time, to warn about or refuse
+// such keys.
+func CheckEncryption(keyPairs ...[]byte) error {
+	if len(keyPairs) < 2 || len(keyPairs[1]) == 0 {
+		return ErrNoEncryptionKey
+	}
+	return nil
+}
+
 // CookieStore stores sessions using secure cookies.
 type CookieStore struct {
-	Codecs  []securecookie.Codec
-	Options *Options // default configuration
+	Codecs    []securecookie.Codec
+	Options   *Options // default configuration
+	maxChunks int
+}
+
+// chunkSize is the length of the cookie values when session values are split.
+const chunkSize = 4000
+
+// ErrMaxLength is returned by CookieStore.Save when session values don't fit
+// in the number of cookies allowed by Chunk.
+var ErrMaxLength = errors.New("sessions: session values are too long for the allowed cookies")
+
+// Chunk allows session values too long for a cookie to be split across up to
+// n cookies, named after the session with a suffix: name_0, name_1 and so on.
+// Values needing more return ErrMaxLength. Browsers limit the number and the
+// total size of cookies per domain, so n should be small. n below 2 turns
+// splitting off; it doesn't touch MaxLength, which splitting sets to 0, so
+// call MaxLength to limit the length again.
+func (s *CookieStore) Chunk(n int) {
+	s.maxChunks = n
+	if n > 1 {
+		// The length is checked by Save.
+		setMaxLength(s.Codecs, 0)
+	}
+}
+
+// MaxLength restricts the maximum length of new sessions to l.
+// If l is 0 there is no limit to the size of a session, use with caution.
+// The default for a new CookieStore is 4096, which is the maximum value
+// accepted by most browsers.
+func (s *CookieStore) MaxLength(l int) {
+	setMaxLength(s.Codecs, l)
+}
+
+// Ping always returns nil: sessions are kept in the cookies.
+func (s *CookieStore) Ping() error {
+	return nil
+}
+
+// MaxAge sets the maximum age of sessions to age seconds, both for the
+// cookie and for the values decoded by the codecs, which would otherwise
+// reject values older than the default 30 days.
+func (s *CookieStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	setMaxAge(s.Codecs, age)
+}
+
+// HashFunc sets the hash function used by the codecs to authenticate
+// session values with HMAC, e.g. crypto/sha512.New. The default is
+// crypto/sha256.New. Values signed with another hash can't be decoded.
+func (s *CookieStore) HashFunc(f func() hash.Hash) {
+	setHashFunc(s.Codecs, f)
 }
 
 // Get returns a session for the given name after adding it to the registry.
@@ -74,11 +185,14 @@ func (s *CookieStore) New(r *http.Request, name string) (*Session, error) {
 	session := NewSession(s, name)
 	session.IsNew = true
 	var err error
-	if c, errCookie := r.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, c.Value, &session.Values,
+	if value, ok := s.cookieValue(r, name); ok {
+		var i int
+		i, err = securecookie.DecodeMultiIndex(name, value, &session.Values,
 			s.Codecs...)
 		if err == nil {
 			session.IsNew = false
+			session.Rotated = i > 0
+			markLoaded(session)
 		}
 	}
 	return session, err
@@ -87,7 +201,29 @@ func (s *CookieStore) New(r *http.Request, name string) (*Session, error) {
 // Save adds a single session to the response.
 func (s *CookieStore) Save(r *http.Request, w http.ResponseWriter,
 	session *Session) error {
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
+	if err := s.setCookie(r, w, session, session.Values); err != nil {
+		return err
+	}
+	markLoaded(session)
+	session.Rotated = false
+	return nil
+}
+
+// markLoaded records a shallow copy of the session values as loaded, for
+// Touch and Session.Modified. Values changed in place, like the elements of
+// a slice, are not told apart.
+func markLoaded(session *Session) {
+	values := make(map[interface{}]interface{}, len(session.Values))
+	for k, v := range session.Values {
+		values[k] = v
+	}
+	session.MarkLoaded(values)
+}
+
+// setCookie encodes values and adds the session cookie to the response.
+func (s *CookieStore) setCookie(r *http.Request, w http.ResponseWriter,
+	session *Session, values map[interface{}]interface{}) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), values,
 		s.Codecs...)
 	if err != nil {
 		return err
@@ -96,22 +232,125 @@ func (s *CookieStore) Save(r *http.Request, w http.ResponseWriter,
 	if session.Options != nil {
 		options = session.Options
 	}
-	cookie := &http.Cookie{
-		Name:     session.Name(),
-		Value:    encoded,
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
+	if s.maxChunks > 1 {
+		return s.setChunks(r, w, session.Name(), encoded, options)
 	}
-	http.SetCookie(w, cookie)
+	http.SetCookie(w, NewCookie(session.Name(), encoded, options))
 	return nil
 }
 
+// cookieValue returns the value of the session cookie, joining the chunks if
+// it was split.
+func (s *CookieStore) cookieValue(r *http.Request, name string) (string, bool) {
+	if c, err := r.Cookie(name); err == nil {
+		return c.Value, true
+	}
+	var value string
+	for i := 0; i < s.maxChunks; i++ {
+		c, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			break
+		}
+		value += c.Value
+	}
+	return value, value != ""
+}
+
+// setChunks adds the session cookie to the response, split in chunks if the
+// value is too long for one. Cookies left from a previous value, which would
+// be read along with the new ones, are expired.
+func (s *CookieStore) setChunks(r *http.Request, w http.ResponseWriter,
+	name, value string, options *Options) error {
+	var chunks []string
+	if len(value) > chunkSize {
+		for ; len(value) > chunkSize; value = value[chunkSize:] {
+			chunks = append(chunks, value[:chunkSize])
+		}
+		chunks = append(chunks, value)
+		if len(chunks) > s.maxChunks {
+			return ErrMaxLength
+		}
+		for i, chunk := range chunks {
+			http.SetCookie(w, NewCookie(chunkName(name, i), chunk, options))
+		}
+	} else {
+		http.SetCookie(w, NewCookie(name, value, options))
+	}
+	expired := *options
+	expired.MaxAge = -1
+	if _, err := r.Cookie(name); err == nil && len(chunks) > 0 {
+		http.SetCookie(w, NewCookie(name, "", &expired))
+	}
+	for i := len(chunks); i < s.maxChunks; i++ {
+		if _, err := r.Cookie(chunkName(name, i)); err != nil {
+			break
+		}
+		http.SetCookie(w, NewCookie(chunkName(name, i), "", &expired))
+	}
+	return nil
+}
+
+// chunkName returns the name of the cookie holding chunk i of a session value.
+func chunkName(name string, i int) string {
+	return name + "_" + strconv.Itoa(i)
+}
+
+// Touch sends the cookie again, so the session expires MaxAge after the last
+// request touching it. Values are stored in the cookie along with the time
+// they were encoded, so they are encoded again, as they were loaded or last
+// saved: changes which were not saved are left out. A session which was
+// neither loaded nor saved is not touched.
+func (s *CookieStore) Touch(r *http.Request, w http.ResponseWriter,
+	session *Session) error {
+	if session.loaded == nil {
+		return nil
+	}
+	return s.setCookie(r, w, session, session.loaded)
+}
+
+// setMaxAge sets the maximum value age on all the codecs that support it.
+// A negative age, which expires sessions right away, means no limit there.
+func setMaxAge(codecs []securecookie.Codec, age int) {
+	if age < 0 {
+		age = 0
+	}
+	for _, c := range codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxAge(age)
+		}
+	}
+}
+
+// setHashFunc sets the HMAC hash function on all the codecs that support it.
+func setHashFunc(codecs []securecookie.Codec, f func() hash.Hash) {
+	for _, c := range codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.HashFunc(f)
+		}
+	}
+}
+
+// setMaxLength sets the maximum value length on all the codecs that support it.
+func setMaxLength(codecs []securecookie.Codec, l int) {
+	for _, c := range codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxLength(l)
+		}
+	}
+}
+
 // FilesystemStore ------------------------------------------------------------
 
-var fileMutex sync.RWMutex
+// fileLocks guard session files. A session id always maps to the same lock,
+// so writers to a session are serialized without blocking other sessions.
+var fileLocks [64]sync.RWMutex
+
+// fileLock returns the lock guarding the file of session id.
+func fileLock(id string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &fileLocks[h.Sum32()%uint32(len(fileLocks))]
+}
 
 // NewFilesystemStore returns a new FilesystemStore.
 //
@@ -123,10 +362,7 @@ func NewFilesystemStore(path string, keyPairs ...[]byte) *FilesystemStore {
 	if path == "" {
 		path = os.TempDir()
 	}
-	if path[len(path)-1] != '/' {
-		path += "/"
-	}
-	return &FilesystemStore{
+	fs := &FilesystemStore{
 		Codecs: securecookie.CodecsFromPairs(keyPairs...),
 		Options: &Options{
 			Path:   "/",
@@ -134,6 +370,10 @@ func NewFilesystemStore(path string, keyPairs ...[]byte) *FilesystemStore {
 		},
 		path: path,
 	}
+	// Session data is not stored in the cookie, so it doesn't need to fit
+	// the browser limits.
+	fs.MaxLength(0)
+	return fs
 }
 
 // FilesystemStore stores sessions in the filesystem.
@@ -142,9 +382,57 @@ func NewFilesystemStore(path string, keyPairs ...[]byte) *FilesystemStore {
 //
 // This store is still experimental and not well tested. Feedback is welcome.
 type FilesystemStore struct {
-	Codecs  []securecookie.Codec
-	Options *Options // default configuration
-	path    string
+	Codecs   []securecookie.Codec
+	Options  *Options // default configuration
+	path     string
+	idLength int
+}
+
+// ErrBadIDLength is returned by IDLength for lengths too short for session ids
+// to be unguessable.
+var ErrBadIDLength = errors.New("sessions: session id length must be at least 16 bytes")
+
+// IDLength sets the length, in bytes, of new session ids. Ids are hex encoded,
+// so they have twice as many characters. The default is 24; lengths under 16
+// return ErrBadIDLength.
+func (s *FilesystemStore) IDLength(l int) error {
+	if l < 16 {
+		return ErrBadIDLength
+	}
+	s.idLength = l
+	return nil
+}
+
+// NewID returns a new random session id.
+func (s *FilesystemStore) NewID() string {
+	l := s.idLength
+	if l == 0 {
+		l = 24
+	}
+	return fmt.Sprintf("%x", securecookie.GenerateRandomKey(l))
+}
+
+// MaxLength restricts the maximum length of new sessions to l.
+// If l is 0 there is no limit to the size of a session, which is the
+// default for a new FilesystemStore.
+func (s *FilesystemStore) MaxLength(l int) {
+	setMaxLength(s.Codecs, l)
+}
+
+// MaxAge sets the maximum age of sessions to age seconds.
+//
+// See CookieStore.MaxAge().
+func (s *FilesystemStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	setMaxAge(s.Codecs, age)
+}
+
+// HashFunc sets the hash function used to authenticate session ids and
+// values.
+//
+// See CookieStore.HashFunc().
+func (s *FilesystemStore) HashFunc(f func() hash.Hash) {
+	setHashFunc(s.Codecs, f)
 }
 
 // Get returns a session for the given name after adding it to the registry.
@@ -162,8 +450,10 @@ func (s *FilesystemStore) New(r *http.Request, name string) (*Session, error) {
 	session.IsNew = true
 	var err error
 	if c, errCookie := r.Cookie(name); errCookie == nil {
-		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+		var i int
+		i, err = securecookie.DecodeMultiIndex(name, c.Value, &session.ID, s.Codecs...)
 		if err == nil {
+			session.Rotated = i > 0
 			err = s.load(session)
 			if err == nil {
 				session.IsNew = false
@@ -177,35 +467,151 @@ func (s *FilesystemStore) New(r *http.Request, name string) (*Session, error) {
 func (s *FilesystemStore) Save(r *http.Request, w http.ResponseWriter,
 	session *Session) error {
 	if session.ID == "" {
-		k := securecookie.GenerateRandomKey(24)
-		session.ID = fmt.Sprintf("%x", k)
+		session.ID = s.NewID()
 	}
 	if err := s.save(session); err != nil {
 		return err
 	}
+	if err := s.setCookie(w, session); err != nil {
+		return err
+	}
+	session.Rotated = false
+	return nil
+}
+
+// Touch updates modification time of the file storing the session and sends
+// the cookie again, so the session expires MaxAge after the last request
+// touching it, instead of MaxAge after it was saved.
+func (s *FilesystemStore) Touch(r *http.Request, w http.ResponseWriter,
+	session *Session) error {
+	filename := s.filename(session)
+	t := now()
+	lock := fileLock(session.ID)
+	lock.Lock()
+	err := os.Chtimes(filename, t, t)
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.setCookie(w, session)
+}
+
+// setCookie adds the session cookie to the response.
+func (s *FilesystemStore) setCookie(w http.ResponseWriter, session *Session) error {
 	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
 		s.Codecs...)
 	if err != nil {
 		return err
 	}
-	options := s.Options
+	http.SetCookie(w, NewCookie(session.Name(), encoded, s.options(session)))
+	return nil
+}
+
+// options returns the options of the session, or the store defaults.
+func (s *FilesystemStore) options(session *Session) *Options {
 	if session.Options != nil {
-		options = session.Options
+		return session.Options
 	}
-	cookie := &http.Cookie{
-		Name:     session.Name(),
-		Value:    encoded,
-		Path:     options.Path,
-		Domain:   options.Domain,
-		MaxAge:   options.MaxAge,
-		Secure:   options.Secure,
-		HttpOnly: options.HttpOnly,
+	return s.Options
+}
+
+// Delete removes the file storing the session values. The session cookie is
+// not touched; a request sending it will just get a new session.
+func (s *FilesystemStore) Delete(session *Session) error {
+	filename := s.filename(session)
+	lock := fileLock(session.ID)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-	http.SetCookie(w, cookie)
 	return nil
 }
 
+// Ping checks that session files can be written to the store directory.
+func (s *FilesystemStore) Ping() error {
+	fp, err := ioutil.TempFile(s.path, "ping_")
+	if err != nil {
+		return err
+	}
+	fp.Close()
+	return os.Remove(fp.Name())
+}
+
+// Cleanup removes the files of sessions not saved or touched for longer than
+// maxAge. Expired sessions are otherwise only removed when they are requested
+// again, so files of abandoned sessions stay around. It returns the number of
+// files removed. Temporary files of sessions being saved are left alone.
+func (s *FilesystemStore) Cleanup(maxAge time.Duration) (removed int, err error) {
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return 0, err
+	}
+	t := now()
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasPrefix(fi.Name(), "session_") ||
+			strings.Contains(fi.Name(), ".tmp") || t.Sub(fi.ModTime()) <= maxAge {
+			continue
+		}
+		ok, err := removeExpired(filepath.Join(s.path, fi.Name()),
+			strings.TrimPrefix(fi.Name(), "session_"), t, maxAge)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeExpired removes the file of session id if it's still older than
+// maxAge once locked, as the session could have been saved in between.
+func removeExpired(filename, id string, now time.Time, maxAge time.Duration) (bool, error) {
+	lock := fileLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	fi, err := os.Stat(filename)
+	if err != nil || now.Sub(fi.ModTime()) <= maxAge {
+		return false, nil
+	}
+	if err = os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// StartCleanup calls Cleanup every interval in a new goroutine, until the
+// returned stop function is called.
+func (s *FilesystemStore) StartCleanup(interval, maxAge time.Duration) (stop func()) {
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Cleanup(maxAge)
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(quit) })
+	}
+}
+
+// filename returns the path of the file storing the session values.
+func (s *FilesystemStore) filename(session *Session) string {
+	return filepath.Join(s.path, "session_"+session.ID)
+}
+
 // save writes encoded session.Values to a file.
+//
+// The values are written to a temporary file which then replaces the session
+// file, so readers never see a partially written one.
 func (s *FilesystemStore) save(session *Session) error {
 	if len(session.Values) == 0 {
 		// Don't need to write anything.
@@ -216,44 +622,69 @@ func (s *FilesystemStore) save(session *Session) error {
 	if err != nil {
 		return err
 	}
-	filename := s.path + "session_" + session.ID
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-	fp, err2 := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0600)
-	if err2 != nil {
-		return err2
-	}
-	if _, err = fp.Write([]byte(encoded)); err != nil {
+	filename := s.filename(session)
+	lock := fileLock(session.ID)
+	lock.Lock()
+	defer lock.Unlock()
+	fp, err := ioutil.TempFile(s.path, "session_"+session.ID+".tmp")
+	if err != nil {
 		return err
 	}
-	fp.Close()
-	return nil
+	_, err = fp.Write([]byte(encoded))
+	if errClose := fp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		t := now()
+		if err = os.Chtimes(fp.Name(), t, t); err == nil {
+			err = os.Rename(fp.Name(), filename)
+		}
+	}
+	if err != nil {
+		os.Remove(fp.Name())
+	}
+	return err
 }
 
 // load reads a file and decodes its content into session.Values.
+// Files not modified for longer than MaxAge are expired, and removed. The
+// session id of an expired session is cleared, so it's saved under a new one.
 func (s *FilesystemStore) load(session *Session) error {
-	filename := s.path + "session_" + session.ID
-	fp, err := os.OpenFile(filename, os.O_RDONLY, 0400)
+	lock := fileLock(session.ID)
+	lock.RLock()
+	fi, fdata, err := readFile(s.filename(session))
+	lock.RUnlock()
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-	var fdata []byte
-	buf := make([]byte, 128)
-	for {
-		var n int
-		n, err = fp.Read(buf[0:])
-		fdata = append(fdata, buf[0:n]...)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
+	if maxAge := s.options(session).MaxAge; maxAge > 0 &&
+		now().Sub(fi.ModTime()) > time.Duration(maxAge)*time.Second {
+		s.Delete(session)
+		session.ID = ""
+		return ErrSessionExpired
 	}
-	if err = securecookie.DecodeMulti(session.Name(), string(fdata),
-		&session.Values, s.Codecs...); err != nil {
+	i, err := securecookie.DecodeMultiIndex(session.Name(), string(fdata),
+		&session.Values, s.Codecs...)
+	if err != nil {
 		return err
 	}
+	if i > 0 {
+		session.Rotated = true
+	}
 	return nil
 }
+
+// readFile returns the info and the content of a file.
+func readFile(filename string) (os.FileInfo, []byte, error) {
+	fp, err := os.OpenFile(filename, os.O_RDONLY, 0400)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	fdata, err := ioutil.ReadAll(fp)
+	return fi, fdata, err
+}
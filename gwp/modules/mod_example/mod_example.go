@@ -72,14 +72,19 @@ type Content struct {
 
 // adminHandler function serves content.
 func adminHandler(w http.ResponseWriter, r *http.Request) {
-	sess,_ := mod_sessions.GetSession(r, "sf")
+	// session may be missing or undecodable, GetId tells us if there's a stored one
+	sess, _ := mod_sessions.GetSession(r, mod_sessions.SessionName)
+	id, ok := mod_sessions.GetId(sess)
+	if !ok {
+		id = "no session started yet"
+	}
         tpl, err := gwp_template.Load(M.ModCtx.Ctx, "admin.html")
         if err != nil {
                 http.Error(w, err.Error(), http.StatusInternalServerError)
                 return
         }
 
-        mydata := Content{ExampleData: sess.ID}
+        mydata := Content{ExampleData: id}
         buff := new(bytes.Buffer)
 
         tpl.Execute(buff, mydata)
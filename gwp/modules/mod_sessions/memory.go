@@ -0,0 +1,144 @@
+package mod_sessions
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+// NewMemoryStore returns a MemoryStore.
+//
+// See sessions.NewCookieStore() for a description of keyPairs, which sign
+// the session id in the cookie.
+func NewMemoryStore(keyPairs ...[]byte) *MemoryStore {
+	ms := &MemoryStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		values: make(map[string]memorySession),
+	}
+	unlimitLength(ms.Codecs)
+	return ms
+}
+
+// MemoryStore keeps sessions in memory, for tests and servers which don't
+// need sessions to survive a restart. Like sessions.FilesystemStore, only the
+// session id goes into the cookie, and sessions not saved or touched for
+// longer than MaxAge expire. It's safe for concurrent use.
+type MemoryStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	mu      sync.Mutex
+	values  map[string]memorySession
+}
+
+// memorySession is a session kept by MemoryStore.
+type memorySession struct {
+	values  map[interface{}]interface{}
+	updated time.Time
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See sessions.CookieStore.Get().
+func (s *MemoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+// A missing or expired session is returned as a new empty session, without
+// an error.
+func (s *MemoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+	err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	if s.load(session) {
+		session.IsNew = false
+	} else {
+		// Don't save the values of a new session under the old id.
+		session.ID = ""
+	}
+	return session, nil
+}
+
+// Save keeps a copy of session values and adds the session cookie to the
+// response. A negative MaxAge deletes the session.
+func (s *MemoryStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		session.ID = newID()
+	}
+	options := sessionOptions(session, s.Options)
+	if options.MaxAge < 0 {
+		s.Delete(session)
+		return setIDCookie(w, session, options, s.Codecs)
+	}
+	s.mu.Lock()
+	s.values[session.ID] = memorySession{copyValues(session.Values), sessions.Now()}
+	s.mu.Unlock()
+	return setIDCookie(w, session, options, s.Codecs)
+}
+
+// Touch updates the time the session was last used and sends the cookie
+// again. It returns sessions.ErrSessionExpired if the session is gone.
+func (s *MemoryStore) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	s.mu.Lock()
+	ms, ok := s.values[session.ID]
+	if ok {
+		ms.updated = sessions.Now()
+		s.values[session.ID] = ms
+	}
+	s.mu.Unlock()
+	if !ok {
+		return sessions.ErrSessionExpired
+	}
+	return setIDCookie(w, session, sessionOptions(session, s.Options), s.Codecs)
+}
+
+// Delete removes the session values. The session cookie is not touched; a
+// request sending it will just get a new session.
+func (s *MemoryStore) Delete(session *sessions.Session) error {
+	s.mu.Lock()
+	delete(s.values, session.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// load copies the kept values into session.Values, and reports whether they
+// were found. Sessions not used for longer than MaxAge are removed.
+func (s *MemoryStore) load(session *sessions.Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms, ok := s.values[session.ID]
+	if !ok {
+		return false
+	}
+	if maxAge := sessionOptions(session, s.Options).MaxAge; maxAge > 0 &&
+		sessions.Now().Sub(ms.updated) > time.Duration(maxAge)*time.Second {
+		delete(s.values, session.ID)
+		return false
+	}
+	session.Values = copyValues(ms.values)
+	return true
+}
+
+// copyValues returns a shallow copy of session values, so changes to a
+// session are only kept once it's saved.
+func copyValues(values map[interface{}]interface{}) map[interface{}]interface{} {
+	c := make(map[interface{}]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
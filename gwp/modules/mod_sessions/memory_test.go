@@ -0,0 +1,82 @@
+package mod_sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore([]byte("secret-key"))
+	store.Options.MaxAge = 60
+	clock := time.Now()
+	sessions.SetClock(func() time.Time { return clock })
+	defer sessions.SetClock(nil)
+
+	// a new session, saved
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, err := store.Get(r, SessionName)
+	if err != nil || !s.IsNew || len(s.Values) != 0 {
+		t.Fatalf("Expected new empty session, got %v, %v", s.Values, err)
+	}
+	s.Values["user"] = "bob"
+	if err := store.Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := sessionCookie(t, w)
+	load := func() (*sessions.Session, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		return store.New(r, SessionName)
+	}
+
+	// loaded back; changes are only kept once saved
+	s, err = load()
+	if err != nil || s.IsNew || s.Values["user"] != "bob" {
+		t.Errorf("Expected stored session, got %v, %v", s.Values, err)
+	}
+	s.Values["user"] = "alice"
+	if s, _ = load(); s.Values["user"] != "bob" {
+		t.Errorf("Expected unsaved change not to be kept, got %v", s.Values)
+	}
+
+	// a miss is a new empty session, not an error
+	clock = clock.Add(61 * time.Second)
+	s, err = load()
+	if err != nil || !s.IsNew || len(s.Values) != 0 || s.ID != "" {
+		t.Errorf("Expected new session for an expired one, got %v %q, %v", s.Values, s.ID, err)
+	}
+	if err := store.Touch(r, httptest.NewRecorder(), s); err != sessions.ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired touching a missing session, got %v", err)
+	}
+}
+
+func TestMemoryStoreConcurrency(t *testing.T) {
+	store := NewMemoryStore([]byte("secret-key"))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, _ := http.NewRequest("GET", "/", nil)
+			s, _ := store.New(r, SessionName)
+			s.Values["n"] = i
+			w := httptest.NewRecorder()
+			if err := store.Save(r, w, s); err != nil {
+				t.Errorf("Error saving session: %v", err)
+				return
+			}
+			r, _ = http.NewRequest("GET", "/", nil)
+			r.AddCookie(sessionCookie(t, w))
+			if s, err := store.New(r, SessionName); err != nil || s.Values["n"] != i {
+				t.Errorf("Expected n=%d, got %v, %v", i, s.Values, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -3,9 +3,12 @@ package mod_sessions
 import (
 	"os"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"github.com/scyth/go-webproject/gwp/gwp_context"
 	"github.com/scyth/go-webproject/gwp/gwp_module"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/context"
 	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
 	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
 )
@@ -13,14 +16,29 @@ import (
 // myname represents 'official' module name
 var myname = "mod_sessions"
 
+// SessionName is the name of session (and its cookie) used by CheckSession and OnPrivilegeChange
+const SessionName = "sf"
+
 // myparams is an example of how custom attributes can be exposed to server.conf.
 var myparams = &gwp_context.ModParams{
         &gwp_context.ModParam{Name: "secret-key", Value: "", Default: "", Type: gwp_context.TypeStr, Must: true},
 	&gwp_context.ModParam{Name: "encryption-key", Value: "", Default: "", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "store-check", Value: "strict", Default: "strict", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "require-encryption", Value: false, Default: false, Type: gwp_context.TypeBool, Must: false},
+	&gwp_context.ModParam{Name: "sliding-expiration", Value: false, Default: false, Type: gwp_context.TypeBool, Must: false},
+	&gwp_context.ModParam{Name: "session-dir", Value: "", Default: "", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "serializer", Value: "gob", Default: "gob", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "store", Value: "file", Default: "file", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "cookie-path", Value: "/", Default: "/", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "cookie-domain", Value: "", Default: "", Type: gwp_context.TypeStr, Must: false},
+	&gwp_context.ModParam{Name: "max-age", Value: 86400 * 30, Default: 86400 * 30, Type: gwp_context.TypeInt, Must: false},
 }
 
 var M *ModSessions
 
+// warnings is where configuration warnings are written
+var warnings io.Writer = os.Stdout
+
 // LoadModule is a MUST for every module. It returns Module interface.
 func LoadModule() gwp_module.Module {
 	M = new(ModSessions)
@@ -31,7 +49,7 @@ func LoadModule() gwp_module.Module {
 // ModSessions is base struct for this module. It will implement Module interface.
 type ModSessions struct {
 	ModCtx *gwp_module.ModContext
-	Store *sessions.FilesystemStore
+	Store sessions.Store
 }
 
 
@@ -61,46 +79,455 @@ func (ms *ModSessions) GetName() string {
 
 // ReadParamStr returns named parameter value from ModContext.
 func ReadParamStr(name string) string {
+	if M.ModCtx == nil {
+		return ""
+	}
 	for _,v := range *M.ModCtx.Params {
 		if v.Name == name {
-			return v.Value.(string)
+			s, _ := v.Value.(string)
+			return s
 		}
 	}
 	return ""
 }
 
-// RegisterStore registers a session store. This module uses FilesystemStore
-func RegisterStore(keyPairs ...[]byte) {
-	store := sessions.NewFilesystemStore("", keyPairs...)
+// ReadParamInt returns named int parameter value from ModContext.
+func ReadParamInt(name string) int {
+	if M.ModCtx == nil {
+		return 0
+	}
+	for _,v := range *M.ModCtx.Params {
+		if v.Name == name {
+			i, _ := v.Value.(int)
+			return i
+		}
+	}
+	return 0
+}
+
+// ReadParamBool returns named bool parameter value from ModContext.
+func ReadParamBool(name string) bool {
+	if M.ModCtx == nil {
+		return false
+	}
+	for _,v := range *M.ModCtx.Params {
+		if v.Name == name {
+			b, _ := v.Value.(bool)
+			return b
+		}
+	}
+	return false
+}
+
+// RegisterStore registers a session store. The store parameter chooses it: file (the
+// default) uses FilesystemStore, keeping session files in the session-dir parameter
+// directory, or os.TempDir() if it's not set; cookie uses CookieStore, and memory
+// MemoryStore. Cookies are set with the cookie-path, cookie-domain and max-age parameters.
+// Values in cookie store sessions are only signed if there is no encryption key, which is
+// warned about. With require-encryption turned on, it's an error and the store is not registered.
+// Session values are serialized as set by the serializer parameter, gob or json.
+// The store is then checked as set by the store-check parameter, see CheckStore.
+func RegisterStore(keyPairs ...[]byte) error {
+	sz, ok := serializers[ReadParamStr("serializer")]
+	if !ok {
+		return fmt.Errorf("%s: unknown serializer %q", myname, ReadParamStr("serializer"))
+	}
+	options, err := storeOptions()
+	if err != nil {
+		return err
+	}
+	var store sessions.Store
+	var codecs []securecookie.Codec
+	switch kind := ReadParamStr("store"); kind {
+	case "", "file":
+		st := sessions.NewFilesystemStore(ReadParamStr("session-dir"), keyPairs...)
+		st.Options = options
+		store, codecs = st, st.Codecs
+	case "cookie":
+		// other stores keep only the session id in the cookie
+		if err := sessions.CheckEncryption(keyPairs...); err != nil {
+			if ReadParamBool("require-encryption") {
+				return err
+			}
+			fmt.Fprintln(warnings, "Warning:", myname, "-", err.Error())
+		}
+		st := sessions.NewCookieStore(keyPairs...)
+		st.Options = options
+		store, codecs = st, st.Codecs
+	case "memory":
+		st := NewMemoryStore(keyPairs...)
+		st.Options = options
+		store, codecs = st, st.Codecs
+	default:
+		return fmt.Errorf("%s: unknown store %q", myname, kind)
+	}
+	for _, c := range codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.SetSerializer(sz)
+			// signed values must not expire before the cookie does
+			codec.MaxAge(options.MaxAge)
+		}
+	}
 	M.Store = store
+	return CheckStore(ReadParamStr("store-check"))
 }
 
+// PingStore checks that the session store is usable, for stores implementing
+// sessions.Pinger. It can back a readiness check of the server.
+func PingStore() error {
+	if p, ok := M.Store.(sessions.Pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// CheckStore pings the session store at startup. In strict mode, the default, a failing
+// ping is returned as an error, so the server doesn't start. In lenient mode it's only
+// logged, and requests get the store errors. Mode off skips the check.
+func CheckStore(mode string) error {
+	switch mode {
+	case "off":
+		return nil
+	case "", "strict", "lenient":
+	default:
+		return fmt.Errorf("%s: unknown store-check mode %q", myname, mode)
+	}
+	err := PingStore()
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%s: session store check failed: %v", myname, err)
+	if mode == "lenient" {
+		fmt.Println("Warning:", err.Error())
+		return nil
+	}
+	return err
+}
 
-// GetSession returns a session
+// storeOptions returns the default cookie options of the store, from the cookie-path,
+// cookie-domain and max-age parameters. Sessions last 30 days unless max-age is set.
+func storeOptions() (*sessions.Options, error) {
+	options := &sessions.Options{
+		Path:   "/",
+		Domain: ReadParamStr("cookie-domain"),
+		MaxAge: 86400 * 30,
+	}
+	if path := ReadParamStr("cookie-path"); path != "" {
+		options.Path = path
+	}
+	if maxAge := ReadParamInt("max-age"); maxAge < 0 {
+		return nil, fmt.Errorf("%s: max-age must not be negative", myname)
+	} else if maxAge > 0 {
+		options.MaxAge = maxAge
+	}
+	return options, nil
+}
+
+// serializers maps values of the serializer parameter to serializers. JSON values
+// can be read outside Go, but numbers in them come back as float64.
+var serializers = map[string]securecookie.Serializer{
+	"":     securecookie.GobSerializer{},
+	"gob":  securecookie.GobSerializer{},
+	"json": securecookie.JSONSerializer{},
+}
+
+// DefaultStore is the key of the store registered with RegisterStore
+const DefaultStore = ""
+
+// contextKey is the type of keys this module stores in the request context
+type contextKey int
+
+// selectedStoreKey is the request context key of the store selected for the request
+const selectedStoreKey contextKey = 0
+
+var (
+	storesMu sync.RWMutex
+	stores   = make(map[string]sessions.Store)
+	selector func(*http.Request) string
+)
+
+// AddStore registers an additional session store under key, to be used with GetSessionFrom
+// or picked by the store selector. Passing nil store removes it.
+func AddStore(key string, store sessions.Store) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if store == nil {
+		delete(stores, key)
+		return
+	}
+	stores[key] = store
+}
+
+// SetStoreSelector sets fn to choose the store for each request, eg. to move some users to
+// a new backend gradually. fn returns the key of a store added with AddStore, or DefaultStore.
+// The choice is made once per request, so sessions are loaded and saved with the same store.
+// Passing nil restores the default store for all requests.
+func SetStoreSelector(fn func(r *http.Request) string) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	selector = fn
+}
+
+// storeByKey returns the store registered under key, or the default store
+func storeByKey(key string) sessions.Store {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+	if s, ok := stores[key]; ok && key != DefaultStore {
+		return s
+	}
+	return M.Store
+}
+
+// selectStore returns the store chosen for the request by the store selector
+func selectStore(r *http.Request) sessions.Store {
+	if key, ok := context.DefaultContext.GetOk(r, selectedStoreKey); ok {
+		return storeByKey(key.(string))
+	}
+	storesMu.RLock()
+	fn := selector
+	storesMu.RUnlock()
+	key := DefaultStore
+	if fn != nil {
+		key = fn(r)
+	}
+	context.DefaultContext.Set(r, selectedStoreKey, key)
+	return storeByKey(key)
+}
+
+// GetSession returns a session from the store chosen for the request (see SetStoreSelector)
 func GetSession(r *http.Request, session_name string) (*sessions.Session, error) {
-	s, err := M.Store.Get(r, session_name)
+	return getSession(r, session_name, selectStore(r))
+}
+
+// GetSessionFrom returns a session from the store registered under storeKey with AddStore,
+// regardless of the store selector
+func GetSessionFrom(r *http.Request, session_name, storeKey string) (*sessions.Session, error) {
+	return getSession(r, session_name, storeByKey(storeKey))
+}
+
+func getSession(r *http.Request, session_name string, st sessions.Store) (*sessions.Session, error) {
+	s, err := st.Get(r, session_name)
 	if s.ID == "" {
-		k := securecookie.GenerateRandomKey(24)
-		s.ID = fmt.Sprintf("%x", k)
+		s.ID = storeID(st)
 	}
 	return s, err
 }
 
-// Save calls sessions.Save
+// GetId returns id of the session and true, or empty string and false if the session
+// wasn't loaded from the store (it's new, or couldn't be decoded).
+func GetId(s *sessions.Session) (string, bool) {
+	if s == nil || s.IsNew || s.ID == "" {
+		return "", false
+	}
+	return s.ID, true
+}
+
+// GetString returns string session value stored under key. It returns false if the value is
+// missing or is not a string, instead of panicking as type assertion on s.Values would.
+func GetString(s *sessions.Session, key interface{}) (string, bool) {
+	v, ok := value(s, key).(string)
+	return v, ok
+}
+
+// GetInt returns int session value stored under key, see GetString.
+func GetInt(s *sessions.Session, key interface{}) (int, bool) {
+	v, ok := value(s, key).(int)
+	return v, ok
+}
+
+// GetBool returns bool session value stored under key, see GetString.
+func GetBool(s *sessions.Session, key interface{}) (bool, bool) {
+	v, ok := value(s, key).(bool)
+	return v, ok
+}
+
+// value returns session value stored under key, or nil
+func value(s *sessions.Session, key interface{}) interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.Values[key]
+}
+
+// AddFlashWithLevel adds a flash message of level, eg. "info" or "error", to the session
+// named SessionName, to be shown as a styled alert. The session still has to be saved.
+// The optional vars[0] is the flash key (see sessions.Session.AddFlashWithLevel).
+func AddFlashWithLevel(r *http.Request, level string, value interface{}, vars ...string) error {
+	s, err := GetSession(r, SessionName)
+	if err != nil {
+		return err
+	}
+	s.AddFlashWithLevel(level, value, vars...)
+	return nil
+}
+
+// FlashesByLevel returns the flash messages of the session named SessionName, grouped by
+// level, and drops them. The session has to be saved for them to be gone on the next request.
+func FlashesByLevel(r *http.Request, vars ...string) (map[string][]interface{}, error) {
+	s, err := GetSession(r, SessionName)
+	if err != nil {
+		return nil, err
+	}
+	return s.FlashesByLevel(vars...), nil
+}
+
+// newID returns a random session id
+func newID() string {
+	return fmt.Sprintf("%x", securecookie.GenerateRandomKey(24))
+}
+
+// idGenerator is implemented by stores choosing the length of their session ids, like
+// FilesystemStore
+type idGenerator interface {
+	NewID() string
+}
+
+// storeID returns a new session id for store st
+func storeID(st sessions.Store) string {
+	if g, ok := st.(idGenerator); ok {
+		return g.NewID()
+	}
+	return newID()
+}
+
+// Save saves the session with the store it was loaded from
 func Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
-	return M.Store.Save(r, w, s)
+	return storeOf(s).Save(r, w, s)
+}
+
+// storeOf returns the store session s belongs to
+func storeOf(s *sessions.Session) sessions.Store {
+	if st := s.Store(); st != nil {
+		return st
+	}
+	return M.Store
+}
+
+// deleter is implemented by stores which can remove a stored session, like FilesystemStore
+type deleter interface {
+	Delete(s *sessions.Session) error
+}
+
+// sessionOptions returns the options of session s, or the store defaults
+func sessionOptions(s *sessions.Session, defaults *sessions.Options) *sessions.Options {
+	if s.Options != nil {
+		return s.Options
+	}
+	return defaults
+}
+
+// setIDCookie adds the cookie holding the signed session id to the response,
+// for stores keeping session values on the server
+func setIDCookie(w http.ResponseWriter, s *sessions.Session, options *sessions.Options, codecs []securecookie.Codec) error {
+	encoded, err := securecookie.EncodeMulti(s.Name(), s.ID, codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(s.Name(), encoded, options))
+	return nil
+}
+
+// unlimitLength lifts the length limit of codecs, for stores which only put the
+// session id in the cookie
+func unlimitLength(codecs []securecookie.Codec) {
+	for _, c := range codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxLength(0)
+		}
+	}
+}
+
+
+// Touch extends the lifetime of the current session by MaxAge, without saving its values.
+// Backing file and the cookie are both refreshed, so sessions only expire when idle.
+// New sessions, not stored yet, are left alone. The session is named by the optional
+// vars[0], or SessionName.
+func Touch(r *http.Request, w http.ResponseWriter, vars ...string) error {
+	name := SessionName
+	if len(vars) > 0 {
+		name = vars[0]
+	}
+	s, err := GetSession(r, name)
+	if err != nil || s.IsNew {
+		return nil
+	}
+	return touch(r, w, s)
+}
+
+// touch touches s, if its store supports it
+func touch(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if t, ok := storeOf(s).(sessions.Toucher); ok {
+		return t.Touch(r, w, s)
+	}
+	return nil
+}
+
+// Regenerate issues a new id for the session, keeping its values, and saves it.
+// Record stored under the old id is removed, so the old session cookie can't be used anymore.
+func Regenerate(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	old := sessions.NewSession(storeOf(s), s.Name())
+	old.ID = s.ID
+	s.ID = storeID(storeOf(s))
+	if err := Save(r, w, s); err != nil {
+		s.ID = old.ID
+		return err
+	}
+	if d, ok := old.Store().(deleter); ok && old.ID != "" {
+		return d.Delete(old)
+	}
+	return nil
+}
+
+// OnPrivilegeChange must be called whenever authorization level of the client changes,
+// like on login, logout or becoming an admin. It regenerates the session, so the session id
+// known before the change (possibly planted by an attacker, or leaked) is no longer valid.
+// With the cookie store there is no record to remove: the session lives in the cookie, so a
+// copy of the old cookie stays valid until it expires.
+// Session values are preserved. Call it before writing the response body:
+//
+//	sess, _ := mod_sessions.CheckSession(r, w)
+//	sess.Values["admin"] = true
+//	if err := mod_sessions.OnPrivilegeChange(r, w); err != nil {
+//		// handle error
+//	}
+func OnPrivilegeChange(r *http.Request, w http.ResponseWriter) error {
+	return RegenerateId(r, w)
+}
+
+// RegenerateId regenerates the session named by the optional vars[0], or SessionName,
+// like OnPrivilegeChange: the session gets a new id, keeps its values and is saved,
+// the record stored under the old id is removed and the cookie is rewritten.
+func RegenerateId(r *http.Request, w http.ResponseWriter, vars ...string) error {
+	name := SessionName
+	if len(vars) > 0 {
+		name = vars[0]
+	}
+	// session which can't be loaded is replaced with a new one anyway
+	s, _ := GetSession(r, name)
+	return Regenerate(r, w, s)
 }
 
 
 // checkSession initializes the session, and can also check for specified session parameter
-// returns session data and bool if match is found, or just session data
+// returns session data and bool if match is found, or just session data.
+// With sliding-expiration turned on, the loaded session is touched (see Touch).
+// Sessions read with an old key are saved again, to be encoded with the new one.
 func CheckSession(req *http.Request, writer http.ResponseWriter, param ...string) (*sessions.Session, bool) {
-        sess, err := GetSession(req, "sf")
+        sess, err := GetSession(req, SessionName)
         
         if err != nil {
                 fmt.Println("Session error: ", err.Error())
                 return sess, false
         }
+        if sess.Rotated {
+                if err := Save(req, writer, sess); err != nil {
+                        fmt.Println("Session error: ", err.Error())
+                }
+        } else if !sess.IsNew && ReadParamBool("sliding-expiration") {
+                if err := touch(req, writer, sess); err != nil {
+                        fmt.Println("Session error: ", err.Error())
+                }
+        }
         if len(param) > 0 {
                 if _,ok := sess.Values[param[0]]; ok {
                         return sess, true
@@ -0,0 +1,530 @@
+package mod_sessions
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/gwp_context"
+	"github.com/scyth/go-webproject/gwp/gwp_core"
+	"github.com/scyth/go-webproject/gwp/gwp_module"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+func sessionCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+		if c.Name == SessionName {
+			return c
+		}
+	}
+	t.Fatalf("No %q cookie set", SessionName)
+	return nil
+}
+
+// setupModule loads the module with the given parameters, keeping session files in
+// a new temporary session-dir unless it's among them. The returned func removes the
+// directory and restores the module loaded before.
+func setupModule(t *testing.T, params ...*gwp_context.ModParam) (dir string, teardown func()) {
+	dir, err := ioutil.TempDir("", "mod_sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := M
+	LoadModule()
+	ps := append(gwp_context.ModParams(nil), params...)
+	ps = append(ps, &gwp_context.ModParam{Name: "session-dir", Value: dir, Type: gwp_context.TypeStr})
+	M.ModCtx = &gwp_module.ModContext{Name: myname, Params: &ps}
+	return dir, func() {
+		M = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestOnPrivilegeChange(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+
+	// start a session
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := GetSession(r, SessionName)
+	s.Values["user"] = "bob"
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	oldCookie := sessionCookie(t, w)
+	oldID := s.ID
+
+	// escalate
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	w = httptest.NewRecorder()
+	if err := OnPrivilegeChange(r, w); err != nil {
+		t.Fatalf("Error regenerating session: %v", err)
+	}
+	newCookie := sessionCookie(t, w)
+
+	// old id no longer loads
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	s, err := GetSession(r, SessionName)
+	if err == nil || !s.IsNew || len(s.Values) != 0 {
+		t.Errorf("Expected old session to be gone, got %v, %v", s.Values, err)
+	}
+
+	// new id keeps the values
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(newCookie)
+	s, err = GetSession(r, SessionName)
+	if err != nil {
+		t.Fatalf("Error loading regenerated session: %v", err)
+	}
+	defer M.Store.(deleter).Delete(s)
+	if s.ID == oldID {
+		t.Errorf("Expected new session id, got %q", s.ID)
+	}
+	if s.Values["user"] != "bob" {
+		t.Errorf("Expected session values to be preserved, got %v", s.Values)
+	}
+}
+
+func TestGetIdUninitialized(t *testing.T) {
+	if id, ok := GetId(nil); ok || id != "" {
+		t.Errorf("Expected no id for nil session, got %q, %v", id, ok)
+	}
+
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+
+	// new session gets an id assigned, but it's not stored yet
+	r, _ := http.NewRequest("GET", "/", nil)
+	s, _ := GetSession(r, SessionName)
+	if id, ok := GetId(s); ok || id != "" {
+		t.Errorf("Expected no id for new session, got %q, %v", id, ok)
+	}
+	if v, ok := GetString(s, "user"); ok || v != "" {
+		t.Errorf("Expected missing value, got %q, %v", v, ok)
+	}
+
+	// cookie pointing to a session which doesn't exist
+	w := httptest.NewRecorder()
+	s.Values["user"] = 1
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	c := sessionCookie(t, w)
+	M.Store.(deleter).Delete(s)
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	s, _ = GetSession(r, SessionName)
+	if id, ok := GetId(s); ok || id != "" {
+		t.Errorf("Expected no id for undecodable session, got %q, %v", id, ok)
+	}
+
+	// stored session
+	r, _ = http.NewRequest("GET", "/", nil)
+	s, _ = GetSession(r, SessionName)
+	s.Values["user"] = 1
+	w = httptest.NewRecorder()
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer M.Store.(deleter).Delete(s)
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(sessionCookie(t, w))
+	s, _ = GetSession(r, SessionName)
+	if id, ok := GetId(s); !ok || id == "" {
+		t.Errorf("Expected id for stored session, got %q, %v", id, ok)
+	}
+	if v, ok := GetString(s, "user"); ok || v != "" {
+		t.Errorf("Expected int value not to be returned as string, got %q, %v", v, ok)
+	}
+	if v, ok := GetInt(s, "user"); !ok || v != 1 {
+		t.Errorf("Expected user=1, got %v, %v", v, ok)
+	}
+}
+
+func TestCheckStore(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+	if err := CheckStore("strict"); err != nil {
+		t.Errorf("Expected temp dir store to pass the check, got %v", err)
+	}
+
+	// a store whose Ping fails
+	M.Store = sessions.NewFilesystemStore(filepath.Join(os.TempDir(), "mod_sessions_missing"), []byte("secret-key"))
+	if err := PingStore(); err == nil {
+		t.Fatalf("Expected Ping to fail for a missing session directory")
+	}
+	if err := CheckStore("strict"); err == nil {
+		t.Errorf("Expected strict check to fail")
+	}
+	if err := CheckStore(""); err == nil {
+		t.Errorf("Expected strict check by default")
+	}
+	if err := CheckStore("lenient"); err != nil {
+		t.Errorf("Expected lenient check only to warn, got %v", err)
+	}
+	if err := CheckStore("off"); err != nil {
+		t.Errorf("Expected no check, got %v", err)
+	}
+	if err := CheckStore("sometimes"); err == nil {
+		t.Errorf("Expected error for unknown mode")
+	}
+}
+
+func TestRegisterStoreEncryption(t *testing.T) {
+	var out bytes.Buffer
+	warnings = &out
+	defer func() { warnings = os.Stdout }()
+	_, teardown := setupModule(t)
+	defer teardown()
+
+	// session values aren't in the cookie
+	if err := RegisterStore([]byte("secret-key")); err != nil || out.Len() != 0 {
+		t.Errorf("Expected file store to be registered without a warning, got %v, %q", err, out.String())
+	}
+
+	// warned about only
+	_, teardown = setupModule(t,
+		&gwp_context.ModParam{Name: "store", Value: "cookie", Type: gwp_context.TypeStr})
+	defer teardown()
+	if err := RegisterStore([]byte("secret-key")); err != nil {
+		t.Errorf("Expected store without encryption key to be registered, got %v", err)
+	}
+	if want := "Warning: " + myname + " - " + sessions.ErrNoEncryptionKey.Error() + "\n"; out.String() != want {
+		t.Errorf("Expected warning %q, got %q", want, out.String())
+	}
+
+	out.Reset()
+	*M.ModCtx.Params = append(*M.ModCtx.Params,
+		&gwp_context.ModParam{Name: "require-encryption", Value: true, Type: gwp_context.TypeBool})
+	store := M.Store
+	if err := RegisterStore([]byte("secret-key")); err != sessions.ErrNoEncryptionKey {
+		t.Errorf("Expected ErrNoEncryptionKey in strict mode, got %v", err)
+	}
+	if M.Store != store {
+		t.Errorf("Expected store not to be registered in strict mode")
+	}
+	if err := RegisterStore([]byte("secret-key"), []byte("0123456789abcdef")); err != nil || M.Store == store {
+		t.Errorf("Expected store with encryption key to be registered, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no warnings in strict mode, got %q", out.String())
+	}
+}
+
+func TestRegisterStoreSerializer(t *testing.T) {
+	_, teardown := setupModule(t,
+		&gwp_context.ModParam{Name: "serializer", Value: "json", Type: gwp_context.TypeStr})
+	defer teardown()
+	if err := RegisterStore([]byte("secret-key")); err != nil {
+		t.Fatalf("Error registering store: %v", err)
+	}
+
+	// values survive a round trip, as JSON types
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := GetSession(r, SessionName)
+	s.Values["user"] = "bob"
+	s.Values["visits"] = 3
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(sessionCookie(t, w))
+	s, err := GetSession(r, SessionName)
+	if err != nil || s.IsNew || s.Values["user"] != "bob" || s.Values["visits"] != 3.0 {
+		t.Errorf("Expected stored session, got %v, %v", s.Values, err)
+	}
+
+	(*M.ModCtx.Params)[0].Value = "xml"
+	if err := RegisterStore([]byte("secret-key")); err == nil {
+		t.Errorf("Expected an error for an unknown serializer")
+	}
+}
+
+func TestRegisterStoreSessionDir(t *testing.T) {
+	dir, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	s, _ := GetSession(r, SessionName)
+	s.Values["user"] = "bob"
+	if err := Save(r, httptest.NewRecorder(), s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session_"+s.ID)); err != nil {
+		t.Errorf("Expected session file in %s, got %v", dir, err)
+	}
+}
+
+func TestRegisterStoreConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "server.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[mod_sessions]
+secret-key = secret-key
+store = memory
+cookie-path = /app
+cookie-domain = example.com
+max-age = 3600
+`)
+	file.Close()
+	params := make(gwp_context.ModParams, len(*myparams))
+	for i, p := range *myparams {
+		param := *p
+		params[i] = &param
+	}
+	if err := gwp_core.ParseConfigParams(file.Name(), myname, &params); err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+	_, teardown := setupModule(t, params...)
+	defer teardown()
+	if err := RegisterStore([]byte(ReadParamStr("secret-key"))); err != nil {
+		t.Fatalf("Error registering store: %v", err)
+	}
+
+	store, ok := M.Store.(*MemoryStore)
+	if !ok {
+		t.Fatalf("Expected MemoryStore, got %T", M.Store)
+	}
+	want := sessions.Options{Path: "/app", Domain: "example.com", MaxAge: 3600}
+	if *store.Options != want {
+		t.Errorf("Expected options %+v, got %+v", want, *store.Options)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := GetSession(r, SessionName)
+	s.Values["user"] = "bob"
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if c := sessionCookie(t, w); c.Path != "/app" || c.Domain != "example.com" || c.MaxAge != 3600 {
+		t.Errorf("Expected cookie with configured options, got %+v", c)
+	}
+
+	for name, value := range map[string]interface{}{"store": "disk", "max-age": -1} {
+		_, teardown := setupModule(t, &gwp_context.ModParam{Name: name, Value: value})
+		defer teardown()
+		if err := RegisterStore([]byte("secret-key")); err == nil {
+			t.Errorf("Expected an error for %s = %v", name, value)
+		}
+	}
+}
+
+func TestFlashesByLevel(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	AddFlashWithLevel(r, "info", "Profile saved")
+	AddFlashWithLevel(r, "warning", "Password expires soon")
+	s, _ := GetSession(r, SessionName)
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	defer M.Store.(deleter).Delete(s)
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(sessionCookie(t, w))
+	flashes, err := FlashesByLevel(r)
+	if err != nil || len(flashes) != 2 || flashes["info"][0] != "Profile saved" ||
+		flashes["warning"][0] != "Password expires soon" {
+		t.Errorf("Expected flashes grouped by level, got %v, %v", flashes, err)
+	}
+}
+
+func TestStoreIDLength(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+	if err := M.Store.(*sessions.FilesystemStore).IDLength(32); err != nil {
+		t.Fatalf("Error setting id length: %v", err)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	s, _ := GetSession(r, SessionName)
+	if len(s.ID) != 64 {
+		t.Errorf("Expected id of 64 characters, got %q", s.ID)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+	M.Store.(*sessions.FilesystemStore).Options.MaxAge = 60
+	clock := time.Now()
+	sessions.SetClock(func() time.Time { return clock })
+	defer sessions.SetClock(nil)
+
+	// two sessions, saved 50s ago
+	var cookies []*http.Cookie
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		s, _ := GetSession(r, SessionName)
+		s.Values["user"] = i
+		if err := Save(r, w, s); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		defer M.Store.(deleter).Delete(s)
+		cookies = append(cookies, sessionCookie(t, w))
+	}
+	clock = clock.Add(50 * time.Second)
+
+	// sliding: the first one is used now
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w := httptest.NewRecorder()
+	if err := Touch(r, w); err != nil {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	if c := sessionCookie(t, w); c.MaxAge != 60 {
+		t.Errorf("Expected cookie to be re-issued with MaxAge 60, got %d", c.MaxAge)
+	}
+
+	// 15s later the touched session lives on, the other one (absolute) has expired
+	clock = clock.Add(15 * time.Second)
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	s, err := GetSession(r, SessionName)
+	if err != nil || s.IsNew || s.Values["user"] != 0 {
+		t.Errorf("Expected touched session to be loaded, got %v, %v", s.Values, err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[1])
+	s, err = GetSession(r, SessionName)
+	if err != sessions.ErrSessionExpired || !s.IsNew {
+		t.Errorf("Expected untouched session to expire, got %v, %v", s.Values, err)
+	}
+
+	// new sessions are not touched
+	r, _ = http.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	if err := Touch(r, w); err != nil || len(w.Header()["Set-Cookie"]) != 0 {
+		t.Errorf("Expected new session not to be touched, got %v, %v", w.Header(), err)
+	}
+}
+
+func TestStoreSelector(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+	dir, err := ioutil.TempDir("", "mod_sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	AddStore("new", sessions.NewFilesystemStore(dir, []byte("new-secret-key")))
+	defer AddStore("new", nil)
+	SetStoreSelector(func(r *http.Request) string {
+		if r.Header.Get("X-Beta") != "" {
+			return "new"
+		}
+		return DefaultStore
+	})
+	defer SetStoreSelector(nil)
+
+	save := func(beta bool, user string) (*sessions.Session, *http.Cookie) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if beta {
+			r.Header.Set("X-Beta", "1")
+		}
+		w := httptest.NewRecorder()
+		s, _ := GetSession(r, SessionName)
+		s.Values["user"] = user
+		if err := Save(r, w, s); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return s, sessionCookie(t, w)
+	}
+	oldSession, oldCookie := save(false, "old")
+	defer M.Store.(deleter).Delete(oldSession)
+	newSession, newCookie := save(true, "new")
+
+	// each request used its own store
+	if _, err := os.Stat(filepath.Join(dir, "session_"+oldSession.ID)); err == nil {
+		t.Errorf("Expected default store session not to be saved in the new store")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session_"+newSession.ID)); err != nil {
+		t.Errorf("Expected selected store session to be saved in the new store: %v", err)
+	}
+
+	// and loads from it again
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Beta", "1")
+	r.AddCookie(newCookie)
+	if s, err := GetSession(r, SessionName); err != nil || s.Values["user"] != "new" {
+		t.Errorf("Expected session from the new store, got %v, %v", s.Values, err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	if s, err := GetSession(r, SessionName); err != nil || s.Values["user"] != "old" {
+		t.Errorf("Expected session from the default store, got %v, %v", s.Values, err)
+	}
+
+	// explicit store key wins over the selector
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	r.Header.Set("X-Beta", "1")
+	if s, err := GetSessionFrom(r, SessionName, DefaultStore); err != nil || s.Values["user"] != "old" {
+		t.Errorf("Expected session from the explicit store, got %v, %v", s.Values, err)
+	}
+}
+
+func TestRegenerateId(t *testing.T) {
+	_, teardown := setupModule(t)
+	defer teardown()
+	RegisterStore([]byte("secret-key"))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := GetSession(r, "auth")
+	s.Values["user"] = "bob"
+	if err := Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	oldID := s.ID
+	var oldCookie *http.Cookie
+	for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+		oldCookie = c
+	}
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	w = httptest.NewRecorder()
+	if err := RegenerateId(r, w, "auth"); err != nil {
+		t.Fatalf("Error regenerating session: %v", err)
+	}
+	s, _ = GetSession(r, "auth")
+	defer M.Store.(deleter).Delete(s)
+	if s.ID == oldID || s.ID == "" {
+		t.Errorf("Expected a new id, got %q", s.ID)
+	}
+	if s.Values["user"] != "bob" {
+		t.Errorf("Expected values to survive, got %v", s.Values)
+	}
+	if c := w.Header().Get("Set-Cookie"); !strings.HasPrefix(c, "auth=") {
+		t.Errorf("Expected the auth cookie to be rewritten, got %q", c)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(oldCookie)
+	if s, err := GetSession(r, "auth"); err == nil || !s.IsNew {
+		t.Errorf("Expected old session to be gone, got %v, %v", s.Values, err)
+	}
+}
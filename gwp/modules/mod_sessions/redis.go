@@ -0,0 +1,327 @@
+package mod_sessions
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+// redisKeyPrefix is prepended to session ids to get the Redis key, like the
+// file names of FilesystemStore.
+const redisKeyPrefix = "session_"
+
+// redisDialTimeout limits how long connecting to Redis may take.
+var redisDialTimeout = 5 * time.Second
+
+// redisTimeout limits how long a command may take, including reading its reply.
+var redisTimeout = 5 * time.Second
+
+// redisPoolSize is the number of idle connections kept by a RedisStore.
+const redisPoolSize = 8
+
+// redisDefaultTTL is the expiration, in seconds, of sessions saved with MaxAge 0,
+// which otherwise would never be removed from Redis.
+const redisDefaultTTL = 86400 * 30
+
+// NewRedisStore returns a RedisStore keeping sessions in the Redis server at
+// addr, eg. "localhost:6379". An empty password skips AUTH; db is the
+// database selected with SELECT.
+//
+// See sessions.NewCookieStore() for a description of keyPairs, which sign
+// the session id in the cookie.
+func NewRedisStore(addr, password string, db int, keyPairs ...[]byte) *RedisStore {
+	rs := &RedisStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		addr:     addr,
+		password: password,
+		db:       db,
+		idle:     make(chan *redisConn, redisPoolSize),
+	}
+	// Session data is not stored in the cookie, so it doesn't need to fit
+	// the browser limits.
+	unlimitLength(rs.Codecs)
+	return rs
+}
+
+// RedisStore stores sessions in Redis. Like sessions.FilesystemStore, only
+// the session id goes into the cookie. Values expire in Redis after MaxAge,
+// so stale sessions don't need to be cleaned up.
+//
+// It's meant to be added next to the default store, or chosen with the
+// store selector:
+//
+//	mod_sessions.AddStore("redis", mod_sessions.NewRedisStore("localhost:6379", "", 0, key))
+type RedisStore struct {
+	Codecs   []securecookie.Codec
+	Options  *sessions.Options // default configuration
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn // connection pool
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See sessions.CookieStore.Get().
+func (s *RedisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+// A session missing from Redis, eg. because it expired, is returned as a new
+// empty session, without an error.
+func (s *RedisStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+	err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	found, err := s.load(session)
+	if err == nil && found {
+		session.IsNew = false
+	} else if !found {
+		// Don't save the values of a new session under the old id.
+		session.ID = ""
+	}
+	return session, err
+}
+
+// Save stores session values in Redis, expiring after MaxAge, and adds the
+// session cookie to the response. A negative MaxAge deletes the session, and
+// sessions with MaxAge 0 expire after 30 days.
+func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		session.ID = newID()
+	}
+	options := sessionOptions(session, s.Options)
+	if options.MaxAge < 0 {
+		if err := s.Delete(session); err != nil {
+			return err
+		}
+		return setIDCookie(w, session, options, s.Codecs)
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	_, err = s.do("SET", redisKeyPrefix+session.ID, encoded, "EX", strconv.Itoa(redisTTL(options.MaxAge)))
+	if err != nil {
+		return err
+	}
+	return setIDCookie(w, session, options, s.Codecs)
+}
+
+// Touch resets the expiration of the stored session to MaxAge and sends the
+// cookie again. It returns sessions.ErrSessionExpired if the session is no
+// longer in Redis.
+func (s *RedisStore) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if maxAge := sessionOptions(session, s.Options).MaxAge; maxAge >= 0 {
+		reply, err := s.do("EXPIRE", redisKeyPrefix+session.ID, strconv.Itoa(redisTTL(maxAge)))
+		if err != nil {
+			return err
+		}
+		if n, _ := reply.(int64); n == 0 {
+			return sessions.ErrSessionExpired
+		}
+	}
+	return setIDCookie(w, session, sessionOptions(session, s.Options), s.Codecs)
+}
+
+// Delete removes the session values from Redis. The session cookie is not
+// touched; a request sending it will just get a new session.
+func (s *RedisStore) Delete(session *sessions.Session) error {
+	_, err := s.do("DEL", redisKeyPrefix+session.ID)
+	return err
+}
+
+// Ping checks that Redis can be reached, with the configured password and db.
+func (s *RedisStore) Ping() error {
+	reply, err := s.do("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("redis: unexpected reply to PING: %v", reply)
+	}
+	return nil
+}
+
+// load reads session values from Redis, and reports whether they were found.
+func (s *RedisStore) load(session *sessions.Session) (bool, error) {
+	reply, err := s.do("GET", redisKeyPrefix+session.ID)
+	if err != nil || reply == nil {
+		return false, err
+	}
+	data, _ := reply.([]byte)
+	err = securecookie.DecodeMulti(session.Name(), string(data), &session.Values, s.Codecs...)
+	return err == nil, err
+}
+
+// do sends a command to Redis and returns the reply, on an idle connection
+// or a new one if there is none. A command taking longer than redisTimeout
+// fails. The connection is dropped after network errors, and kept for the
+// next commands otherwise.
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-s.idle:
+	default:
+		var err error
+		if conn, err = dialRedis(s.addr, s.password, s.db); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		conn.c.Close()
+		return nil, err
+	}
+	reply, err := conn.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.c.Close()
+		return reply, err
+	}
+	select {
+	case s.idle <- conn:
+	default:
+		conn.c.Close()
+	}
+	return reply, err
+}
+
+// redisTTL returns the expiration of a session with the given MaxAge.
+func redisTTL(maxAge int) int {
+	if maxAge == 0 {
+		return redisDefaultTTL
+	}
+	return maxAge
+}
+
+// ----------------------------------------------------------------------------
+
+// redisError is an error reply from Redis. The connection is still usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// errRedisProtocol is returned for replies which can't be parsed.
+var errRedisProtocol = errors.New("redis: protocol error")
+
+// redisConn is a connection speaking the Redis protocol (RESP).
+type redisConn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// dialRedis connects to addr, authenticates and selects db.
+func dialRedis(addr, password string, db int) (*redisConn, error) {
+	c, err := net.DialTimeout("tcp", addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(redisTimeout))
+	rc := &redisConn{c: c, r: bufio.NewReader(c)}
+	if password != "" {
+		if _, err = rc.do("AUTH", password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if db != 0 {
+		if _, err = rc.do("SELECT", strconv.Itoa(db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and reads its reply: a string for status replies, an
+// int64 for integers, []byte or nil for bulk strings, and []interface{} for
+// arrays. Error replies are returned as redisError.
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, fmt.Sprintf("*%d\r\n", len(args))...)
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(arg))...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := rc.c.Write(buf); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+// readReply reads a single reply.
+func (rc *redisConn) readReply() (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errRedisProtocol
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, errRedisProtocol
+}
@@ -0,0 +1,246 @@
+package mod_sessions
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+// fakeRedis is a Redis server knowing the commands used by RedisStore.
+type fakeRedis struct {
+	l        net.Listener
+	password string
+	mu       sync.Mutex
+	data     map[string]string
+	ttl      map[string]int
+	db       int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Can't listen: %v", err)
+	}
+	f := &fakeRedis{l: l, password: password, data: map[string]string{}, ttl: map[string]int{}}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authed := f.password == ""
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		io.WriteString(c, f.reply(args, &authed))
+	}
+}
+
+func (f *fakeRedis) reply(args []string, authed *bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if args[0] == "AUTH" {
+		if args[1] != f.password {
+			return "-ERR invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		f.db, _ = strconv.Atoi(args[1])
+		return "+OK\r\n"
+	case "SET":
+		f.data[args[1]] = args[2]
+		delete(f.ttl, args[1])
+		if len(args) == 5 && args[3] == "EX" {
+			f.ttl[args[1]], _ = strconv.Atoi(args[4])
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "DEL":
+		_, ok := f.data[args[1]]
+		delete(f.data, args[1])
+		delete(f.ttl, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "EXPIRE":
+		if _, ok := f.data[args[1]]; !ok {
+			return ":0\r\n"
+		}
+		f.ttl[args[1]], _ = strconv.Atoi(args[2])
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisStore(t *testing.T) {
+	f := newFakeRedis(t, "pass")
+	defer f.l.Close()
+	store := NewRedisStore(f.l.Addr().String(), "pass", 2, []byte("secret-key"))
+	store.Options.MaxAge = 60
+
+	// save a session
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := store.Get(r, SessionName)
+	s.Values["user"] = "bob"
+	if err := store.Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := sessionCookie(t, w)
+	key := redisKeyPrefix + s.ID
+	if f.data[key] == "" || f.ttl[key] != 60 || f.db != 2 {
+		t.Errorf("Expected session in db 2 with TTL 60, got %v %v db %d", f.data, f.ttl, f.db)
+	}
+
+	// load it back
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	s, err := store.Get(r, SessionName)
+	if err != nil || s.IsNew || s.Values["user"] != "bob" {
+		t.Errorf("Expected stored session, got %v, %v", s.Values, err)
+	}
+
+	// touch resets the TTL
+	store.Options.MaxAge = 120
+	if err := store.Touch(r, httptest.NewRecorder(), s); err != nil || f.ttl[key] != 120 {
+		t.Errorf("Expected TTL 120 after touch, got %d, %v", f.ttl[key], err)
+	}
+
+	// browser session cookies still expire in Redis
+	store.Options.MaxAge = 0
+	if err := store.Save(r, httptest.NewRecorder(), s); err != nil || f.ttl[key] != redisDefaultTTL {
+		t.Errorf("Expected default TTL for MaxAge 0, got %d, %v", f.ttl[key], err)
+	}
+
+	// a miss is a new empty session, not an error
+	if err := store.Delete(s); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	s, err = store.Get(r, SessionName)
+	if err != nil || !s.IsNew || len(s.Values) != 0 || s.ID != "" {
+		t.Errorf("Expected new session, got %v %q, %v", s.Values, s.ID, err)
+	}
+	if err := store.Touch(r, httptest.NewRecorder(), s); err != sessions.ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired touching a missing session, got %v", err)
+	}
+
+	// wrong password
+	bad := NewRedisStore(f.l.Addr().String(), "wrong", 0, []byte("secret-key"))
+	if _, err := bad.do("GET", "x"); err == nil {
+		t.Errorf("Expected an error with a wrong password")
+	}
+
+	// the startup check
+	if err := sessions.Pinger(store).Ping(); err != nil {
+		t.Errorf("Expected Ping to succeed, got %v", err)
+	}
+	if err := bad.Ping(); err == nil {
+		t.Errorf("Expected Ping to fail with a wrong password")
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	l.Close()
+	if err := NewRedisStore(l.Addr().String(), "", 0).Ping(); err == nil {
+		t.Errorf("Expected Ping to fail without a server")
+	}
+}
+
+func TestRedisStoreTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Can't listen: %v", err)
+	}
+	defer l.Close()
+	// accepts connections, but never replies
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	defer func(d time.Duration) { redisTimeout = d }(redisTimeout)
+	redisTimeout = 50 * time.Millisecond
+
+	store := NewRedisStore(l.Addr().String(), "", 0, []byte("secret-key"))
+	start := time.Now()
+	if _, err := store.do("GET", "x"); err == nil {
+		t.Errorf("Expected an error from a server not replying")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the command to time out, took %v", d)
+	}
+	if len(store.idle) != 0 {
+		t.Errorf("Expected the timed out connection to be dropped")
+	}
+}
+
+func TestRedisStorePool(t *testing.T) {
+	f := newFakeRedis(t, "")
+	defer f.l.Close()
+	store := NewRedisStore(f.l.Addr().String(), "", 0, []byte("secret-key"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2*redisPoolSize; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := store.do("SET", strconv.Itoa(i), "v"); err != nil {
+				t.Errorf("Error on SET: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(store.idle); n == 0 || n > redisPoolSize {
+		t.Errorf("Expected 1 to %d idle connections, got %d", redisPoolSize, n)
+	}
+}
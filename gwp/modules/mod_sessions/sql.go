@@ -0,0 +1,190 @@
+package mod_sessions
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/securecookie"
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+// validTable matches table names which can be put in queries as they are.
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewSQLStore returns a SQLStore keeping sessions in table of db. The table
+// can be created with EnsureTable. It panics if table is not a plain SQL
+// identifier, as it's put in queries as it is.
+//
+// See sessions.NewCookieStore() for a description of keyPairs, which sign
+// the session id in the cookie.
+func NewSQLStore(db *sql.DB, table string, keyPairs ...[]byte) *SQLStore {
+	if !validTable.MatchString(table) {
+		panic("mod_sessions: invalid table name " + table)
+	}
+	ss := &SQLStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		db:    db,
+		table: table,
+	}
+	// Session data is not stored in the cookie, so it doesn't need to fit
+	// the browser limits.
+	unlimitLength(ss.Codecs)
+	return ss
+}
+
+// SQLStore stores sessions in a SQL database, for servers which already run
+// one. Like sessions.FilesystemStore, only the session id goes into the
+// cookie, and sessions not saved or touched for longer than MaxAge expire.
+//
+// Rows are (id, data, updated_at). Queries use "?" placeholders, as
+// understood by the SQLite and MySQL drivers.
+type SQLStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	db      *sql.DB
+	table   string
+}
+
+// EnsureTable creates the session table if it doesn't exist yet.
+func (s *SQLStore) EnsureTable() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + s.table +
+		" (id TEXT PRIMARY KEY, data BLOB, updated_at TIMESTAMP)")
+	return err
+}
+
+// Get returns a session for the given name after adding it to the registry.
+//
+// See sessions.CookieStore.Get().
+func (s *SQLStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+// A session missing from the table, eg. because it expired, is returned as a
+// new empty session, without an error.
+func (s *SQLStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+	err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	found, err := s.load(session)
+	if err == nil && found {
+		session.IsNew = false
+	} else if !found {
+		// Don't save the values of a new session under the old id.
+		session.ID = ""
+	}
+	return session, err
+}
+
+// Save writes session values to the table, inserting or updating the row,
+// and adds the session cookie to the response. A negative MaxAge deletes the
+// session.
+func (s *SQLStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		session.ID = newID()
+	}
+	options := sessionOptions(session, s.Options)
+	if options.MaxAge < 0 {
+		if err := s.Delete(session); err != nil {
+			return err
+		}
+		return setIDCookie(w, session, options, s.Codecs)
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	// Upserts are not portable, and the rows affected by an update can't
+	// tell a missing row: MySQL doesn't count rows left unchanged.
+	found, err := s.exists(session.ID)
+	if err != nil {
+		return err
+	}
+	if t := sessions.Now(); found {
+		_, err = s.db.Exec("UPDATE "+s.table+" SET data = ?, updated_at = ? WHERE id = ?",
+			[]byte(encoded), t, session.ID)
+	} else {
+		_, err = s.db.Exec("INSERT INTO "+s.table+" (id, data, updated_at) VALUES (?, ?, ?)",
+			session.ID, []byte(encoded), t)
+	}
+	if err != nil {
+		return err
+	}
+	return setIDCookie(w, session, options, s.Codecs)
+}
+
+// Touch updates the time the session was last used and sends the cookie
+// again, so the session expires MaxAge after the last request touching it.
+// It returns sessions.ErrSessionExpired if the session is gone.
+func (s *SQLStore) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	found, err := s.exists(session.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return sessions.ErrSessionExpired
+	}
+	_, err = s.db.Exec("UPDATE "+s.table+" SET updated_at = ? WHERE id = ?",
+		sessions.Now(), session.ID)
+	if err != nil {
+		return err
+	}
+	return setIDCookie(w, session, sessionOptions(session, s.Options), s.Codecs)
+}
+
+// exists reports whether the table has a row for session id.
+func (s *SQLStore) exists(id string) (bool, error) {
+	var one int
+	err := s.db.QueryRow("SELECT 1 FROM "+s.table+" WHERE id = ?", id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the row storing the session values. The session cookie is
+// not touched; a request sending it will just get a new session.
+func (s *SQLStore) Delete(session *sessions.Session) error {
+	_, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id = ?", session.ID)
+	return err
+}
+
+// Ping checks that the database can be reached.
+func (s *SQLStore) Ping() error {
+	return s.db.Ping()
+}
+
+// load reads the row of the session and decodes it into session.Values, and
+// reports whether it was found. Rows not updated for longer than MaxAge are
+// expired, and removed.
+func (s *SQLStore) load(session *sessions.Session) (bool, error) {
+	var data []byte
+	var updated time.Time
+	err := s.db.QueryRow("SELECT data, updated_at FROM "+s.table+" WHERE id = ?",
+		session.ID).Scan(&data, &updated)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if maxAge := sessionOptions(session, s.Options).MaxAge; maxAge > 0 &&
+		sessions.Now().Sub(updated) > time.Duration(maxAge)*time.Second {
+		s.Delete(session)
+		return false, nil
+	}
+	err = securecookie.DecodeMulti(session.Name(), string(data), &session.Values, s.Codecs...)
+	return err == nil, err
+}
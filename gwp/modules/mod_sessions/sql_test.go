@@ -0,0 +1,309 @@
+package mod_sessions
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scyth/go-webproject/gwp/libs/gorilla/sessions"
+)
+
+// fakeDriver is a database/sql driver knowing the queries of SQLStore.
+type fakeDriver struct {
+	mu     sync.Mutex
+	tables map[string]map[string]fakeRow
+}
+
+type fakeRow struct {
+	data    []byte
+	updated time.Time
+}
+
+var registerFakeDriver sync.Once
+
+func openFakeDB(t *testing.T) (*sql.DB, *fakeDriver) {
+	d := &fakeDriver{tables: map[string]map[string]fakeRow{}}
+	registerFakeDriver.Do(func() { sql.Register("fakesessions", fakeDrivers{}) })
+	fakeDriversMu.Lock()
+	fakeDriversByName[t.Name()] = d
+	fakeDriversMu.Unlock()
+	db, err := sql.Open("fakesessions", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, d
+}
+
+var (
+	fakeDriversMu     sync.Mutex
+	fakeDriversByName = map[string]*fakeDriver{}
+)
+
+// fakeDrivers opens the fakeDriver registered under the data source name.
+type fakeDrivers struct{}
+
+func (fakeDrivers) Open(name string) (driver.Conn, error) {
+	fakeDriversMu.Lock()
+	defer fakeDriversMu.Unlock()
+	d := fakeDriversByName[name]
+	if d == nil {
+		return nil, errors.New("fake: unknown database " + name)
+	}
+	return &fakeConn{d}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c.d, query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake: no transactions")
+}
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f := strings.Fields(s.query)
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS "):
+		if s.d.tables[f[5]] == nil {
+			s.d.tables[f[5]] = map[string]fakeRow{}
+		}
+		return driver.RowsAffected(0), nil
+	}
+	var table map[string]fakeRow
+	switch f[0] {
+	case "UPDATE", "INSERT", "DELETE":
+		name := f[1]
+		if f[0] != "UPDATE" {
+			name = f[2]
+		}
+		if table = s.d.tables[name]; table == nil {
+			return nil, errors.New("fake: no such table " + name)
+		}
+	}
+	switch {
+	// Like MySQL, rows left unchanged are not counted as affected.
+	case strings.Contains(s.query, "SET data = ?, updated_at = ?"):
+		id := args[2].(string)
+		row, ok := table[id]
+		if !ok || string(row.data) == string(args[0].([]byte)) && row.updated.Equal(args[1].(time.Time)) {
+			return driver.RowsAffected(0), nil
+		}
+		table[id] = fakeRow{args[0].([]byte), args[1].(time.Time)}
+		return driver.RowsAffected(1), nil
+	case strings.Contains(s.query, "SET updated_at = ?"):
+		id := args[1].(string)
+		row, ok := table[id]
+		if !ok || row.updated.Equal(args[0].(time.Time)) {
+			return driver.RowsAffected(0), nil
+		}
+		row.updated = args[0].(time.Time)
+		table[id] = row
+		return driver.RowsAffected(1), nil
+	case f[0] == "INSERT":
+		if _, ok := table[args[0].(string)]; ok {
+			return nil, errors.New("fake: duplicate key " + args[0].(string))
+		}
+		table[args[0].(string)] = fakeRow{args[1].([]byte), args[2].(time.Time)}
+		return driver.RowsAffected(1), nil
+	case f[0] == "DELETE":
+		delete(table, args[0].(string))
+		return driver.RowsAffected(1), nil
+	}
+	return nil, errors.New("fake: unknown query " + s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f := strings.Fields(s.query)
+	var table map[string]fakeRow
+	for i := range f {
+		if f[i] == "FROM" && i+1 < len(f) {
+			table = s.d.tables[f[i+1]]
+		}
+	}
+	if f[0] != "SELECT" || table == nil {
+		return nil, errors.New("fake: unknown query " + s.query)
+	}
+	rows := &fakeRows{exists: f[1] == "1"}
+	if row, ok := table[args[0].(string)]; ok {
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+// fakeRows returns data and updated_at of the rows, or 1 for exists queries.
+type fakeRows struct {
+	rows   []fakeRow
+	exists bool
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.exists {
+		return []string{"1"}
+	}
+	return []string{"data", "updated_at"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	if r.exists {
+		dest[0] = int64(1)
+	} else {
+		dest[0], dest[1] = r.rows[0].data, r.rows[0].updated
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	db, d := openFakeDB(t)
+	defer db.Close()
+	store := NewSQLStore(db, "sessions", []byte("secret-key"))
+	store.Options.MaxAge = 60
+	if err := store.EnsureTable(); err != nil {
+		t.Fatalf("Error creating table: %v", err)
+	}
+	clock := time.Now()
+	sessions.SetClock(func() time.Time { return clock })
+	defer sessions.SetClock(nil)
+
+	// save a session, then update it
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s, _ := store.Get(r, SessionName)
+	s.Values["user"] = "bob"
+	if err := store.Save(r, w, s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	s.Values["admin"] = true
+	if err := store.Save(r, w, s); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+	if len(d.tables["sessions"]) != 1 {
+		t.Errorf("Expected a single row, got %d", len(d.tables["sessions"]))
+	}
+	cookie := sessionCookie(t, w)
+
+	// the row is found even if nothing changes, as MySQL counts no affected rows
+	if err := store.Save(r, httptest.NewRecorder(), s); err != nil {
+		t.Fatalf("Error saving unchanged session: %v", err)
+	}
+	if err := store.Touch(r, httptest.NewRecorder(), s); err != nil {
+		t.Fatalf("Error touching unchanged session: %v", err)
+	}
+
+	// load it back
+	load := func() (*sessions.Session, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		return store.Get(r, SessionName)
+	}
+	s, err := load()
+	if err != nil || s.IsNew || s.Values["user"] != "bob" || s.Values["admin"] != true {
+		t.Errorf("Expected stored session, got %v, %v", s.Values, err)
+	}
+
+	// touching postpones expiry
+	clock = clock.Add(50 * time.Second)
+	if err := store.Touch(r, httptest.NewRecorder(), s); err != nil {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	clock = clock.Add(50 * time.Second)
+	if s, err = load(); err != nil || s.IsNew {
+		t.Errorf("Expected touched session to survive, got %v", err)
+	}
+
+	// a miss is a new empty session, not an error
+	id := s.ID
+	if err := store.Delete(s); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if s, err = load(); err != nil || !s.IsNew || len(s.Values) != 0 || s.ID != "" {
+		t.Errorf("Expected new session, got %v %q, %v", s.Values, s.ID, err)
+	}
+	s.ID = id
+	if err := store.Save(r, httptest.NewRecorder(), s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// idle sessions expire, and their row is removed
+	clock = clock.Add(61 * time.Second)
+	if s, err = load(); err != nil || !s.IsNew || s.ID != "" {
+		t.Errorf("Expected expired session to be new, got %q, %v", s.ID, err)
+	}
+	if len(d.tables["sessions"]) != 0 {
+		t.Errorf("Expected expired row to be removed")
+	}
+	if err := store.Touch(r, httptest.NewRecorder(), s); err != sessions.ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired touching a missing session, got %v", err)
+	}
+
+	// values of the new session are not saved under the expired id
+	s.Values["user"] = "eve"
+	if err := store.Save(r, httptest.NewRecorder(), s); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if s.ID == id {
+		t.Errorf("Expected new session id, got the expired one")
+	}
+
+	// negative MaxAge deletes the session
+	s.Options = &sessions.Options{MaxAge: -1}
+	w = httptest.NewRecorder()
+	if err := store.Save(r, w, s); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if len(d.tables["sessions"]) != 0 || sessionCookie(t, w).MaxAge >= 0 {
+		t.Errorf("Expected row and cookie to be removed, got %d rows", len(d.tables["sessions"]))
+	}
+}
+
+func TestSQLStorePing(t *testing.T) {
+	db, _ := openFakeDB(t)
+	defer db.Close()
+	if err := sessions.Pinger(NewSQLStore(db, "sessions")).Ping(); err != nil {
+		t.Errorf("Expected Ping to succeed, got %v", err)
+	}
+	bad, _ := sql.Open("fakesessions", "missing")
+	defer bad.Close()
+	if err := NewSQLStore(bad, "sessions").Ping(); err == nil {
+		t.Errorf("Expected Ping to fail for a bad data source")
+	}
+}
+
+func TestSQLStoreInvalidTable(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for an invalid table name")
+		}
+	}()
+	NewSQLStore(nil, "sessions; DROP TABLE users")
+}
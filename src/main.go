@@ -5,15 +5,23 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"github.com/scyth/go-webproject/gwp/gwp_context"
 	"github.com/scyth/go-webproject/gwp/gwp_core"
-	"github.com/scyth/go-webproject/gwp/libs/gorilla/mux"
+	"github.com/scyth/go-webproject/gwp/gwp_module"
+)
+
+// build info, set with: go build -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   string
+	commit    string
+	buildTime string
 )
 
 var (
 	configPath string
 	ctx        *gwp_context.Context
-	router     *mux.Router
 )
 
 const (
@@ -44,30 +52,61 @@ func main() {
 	appconf, err := gwp_core.ParseConfig(ctx.ConfigFile)
 	if err != nil {
 		fmt.Println(err.Error())
+		if ce, ok := err.(*gwp_core.ConfigError); ok {
+			fmt.Printf("Fix %s setting in [%s] section of %s\n", ce.Key, ce.Section, ctx.ConfigFile)
+		}
 		fmt.Println("See examples/config/server.conf for all the options")
 		os.Exit(1)
 	}
-	ctx.App = appconf
-
-	// if gorilla-mux is not set, we will use default methods from http package
-	if ctx.App.Mux == "gorilla" {
-		router = new(mux.Router)
-		router.StrictSlash(true)
-		ctx.Router = router
-		initHandlers(router)
-		http.Handle("/", router)
-	} else {
-		initHandlers(nil)
+	ctx.SetConfig(appconf)
+	gwp_core.SetBuildInfo(ctx, version, commit, buildTime)
+	gwp_core.SetFeatures(appconf.Features)
+
+	// forwarding headers are trusted only if they come from configured proxies
+	proxy, err := gwp_core.NewProxyConfig(appconf)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
 	}
+	gwp_core.SetProxy(proxy)
+
+	// handlers are registered with gorilla-mux if it's set, with default methods from http package otherwise
+	initHandlers(ctx)
+	routes := gwp_module.Routes(ctx)
+	routes.NotFound(http.HandlerFunc(gwp_core.NotFound))
 
 	// initialize modules
 	initModules(ctx)
 
+	if appconf.VersionPath != "" {
+		gwp_module.RegisterHandler(ctx, appconf.VersionPath, gwp_core.VersionHandler(ctx))
+	}
+	if appconf.MetricsPath != "" {
+		gwp_module.RegisterHandler(ctx, appconf.MetricsPath, gwp_core.MetricsHandler())
+	}
+	if appconf.LiveTemplates {
+		gwp_module.RegisterHandler(ctx, gwp_core.LiveReloadPath, gwp_core.LiveReloadHandler(ctx))
+	}
+
 	// run the watcher for templates
 	go gwp_core.WatchTemplates(ctx)
 
+	// reload configuration on SIGHUP
+	go reloadOnSignal(ctx)
+
+	// wrap registered handlers with runtime middleware
+	var handler http.Handler = routes
+	handler = gwp_core.CSRFMiddleware(handler)
+	handler = gwp_core.CSPMiddleware(ctx, handler)
+	handler = gwp_core.MaintenanceMiddleware(ctx, handler)
+	handler = gwp_core.CleanPathMiddleware(handler)
+	handler = gwp_core.LimitMiddleware(ctx, handler)
+	handler = gwp_core.TimeoutMiddleware(ctx, handler)
+	handler = gwp_core.RecoveryMiddleware(ctx, handler)
+	handler = gwp_core.CleanupMiddleware(ctx, handler)
+
 	// serve the world
-	err = http.ListenAndServe(ctx.App.ListenAddr, nil)
+	err = gwp_core.ListenAndServe(ctx, handler)
 	if err != nil {
 		fmt.Printf("Failed to create listener: %s \n", err.Error())
 		os.Exit(1)
@@ -78,3 +117,16 @@ func main() {
 	err = <-ctx.ErrorMsg
 	fmt.Println("Aborting runtime. Got error:", err.Error())
 }
+
+// reloadOnSignal reloads the config file whenever the process gets SIGHUP
+func reloadOnSignal(ctx *gwp_context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for _ = range sig {
+		if err := gwp_module.ReloadConfig(ctx); err != nil {
+			fmt.Println("Config reload failed, keeping current config:", err.Error())
+			continue
+		}
+		fmt.Println("Config reloaded")
+	}
+}